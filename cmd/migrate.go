@@ -0,0 +1,120 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/migration"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/routing"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
+)
+
+const (
+	cobraKeyMigrateDryRun string = "dry-run"
+	viperKeyMigrateDryRun string = "migrate.dry_run"
+
+	cobraKeyMigrateStatesFile string = "states-file"
+	viperKeyMigrateStatesFile string = "migrate.states_file"
+
+	cobraKeyMigrateHost string = "host"
+	viperKeyMigrateHost string = "migrate.host"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [state path]...",
+	Short: "Encrypt existing plaintext terraform states",
+	Long: `Encrypts the plaintext terraform states of the backend terraform state server
+with the currently configured keys.
+
+Each state is locked, read from the backend, encrypted and written back.
+States which are already encrypted, missing or locked by someone else are
+left unchanged. The state paths are taken from the arguments and the states
+file, one path per line. A dry run only reports which states are plaintext.
+The backend routes match the state paths with the given host, states of
+routes with other hosts are migrated by another run.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := newServerConfig()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			_ = cmd.Usage()
+			os.Exit(200)
+		}
+		paths, err := statePaths(args, cmdViper.GetString(viperKeyMigrateStatesFile))
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(200)
+		}
+		if len(paths) == 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "no state paths given")
+			_ = cmd.Usage()
+			os.Exit(200)
+		}
+		backendClient, err := backend.New(config)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(200)
+		}
+		routes, err := routing.New(config, backendClient)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(200)
+		}
+		stateTransformer := transformer.New()
+		migrators := map[string]migration.Migrator{}
+		counts := map[migration.Status]int{}
+		for i, path := range paths {
+			result := migration.Result{Path: path, Status: migration.StatusFailed}
+			if route, err := routes.Match(cmdViper.GetString(viperKeyMigrateHost), path); err != nil {
+				result.Err = err
+			} else {
+				migrator, ok := migrators[route.Name]
+				if !ok {
+					migrator = migration.New(route.Config, route.Backend, stateTransformer, cmdViper.GetBool(viperKeyMigrateDryRun))
+					migrators[route.Name] = migrator
+				}
+				result = migrator.Migrate(path, route.UpstreamPath(path))
+			}
+			counts[result.Status]++
+			fmt.Printf("[%d/%d] %s\n", i+1, len(paths), result)
+		}
+		fmt.Printf("%d migrated, %d dry-run, %d encrypted, %d skipped, %d locked, %d failed\n",
+			counts[migration.StatusMigrated],
+			counts[migration.StatusDryRun],
+			counts[migration.StatusEncrypted],
+			counts[migration.StatusSkipped],
+			counts[migration.StatusLocked],
+			counts[migration.StatusFailed],
+		)
+		if counts[migration.StatusFailed] > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func initMigrateCmd() {
+	rootCmd.AddCommand(migrateCmd)
+
+	registerBoolParameterWithDefault(migrateCmd, cobraKeyMigrateDryRun, viperKeyMigrateDryRun, "report plaintext states without locking and writing them", false)
+	registerStringParameter(migrateCmd, cobraKeyMigrateStatesFile, viperKeyMigrateStatesFile, "file with one state path per line, \"-\" reads from stdin", false)
+	registerStringParameter(migrateCmd, cobraKeyMigrateHost, viperKeyMigrateHost, "host of the state paths to match backend routes with hosts", false)
+	registerTransformParameters(migrateCmd)
+	registerBackendParameters(migrateCmd)
+	registerLogParameters(migrateCmd)
+}
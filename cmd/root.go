@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -36,6 +37,9 @@ to the backend terraform HTTP backend`,
 	cfgFile       string
 	cmdViper      *viper.Viper
 	viperReplacer replacer
+	// parameterBindings holds the flag of each viper key per command. Commands share viper keys, so the flags of
+	// the executed command are bound again before it runs.
+	parameterBindings = map[*cobra.Command]map[string]string{}
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -54,11 +58,17 @@ func init() {
 
 	initRootCmd()
 	initStartCmd()
+	initRotateCmd()
+	initMigrateCmd()
+	initTransformCmds()
 
 }
 
 func initRootCmd() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "/etc/terraform-sops-backend/conf.yaml", "config file")
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		bindParameters(cmd)
+	}
 }
 
 func initConfig() {
@@ -85,14 +95,47 @@ func registerStringParameterWithDefault(cmd *cobra.Command, cobraKey, viperKey,
 		requiredText = "required"
 	}
 	cmd.Flags().String(cobraKey, defaultValue, fmt.Sprintf("%s (%s) %s", envVarName(viperKey), requiredText, helpText))
-	cmdViper.BindPFlag(viperKey, cmd.Flags().Lookup(cobraKey))
+	bindParameter(cmd, cobraKey, viperKey)
+}
+
+func registerStringSliceParameter(cmd *cobra.Command, cobraKey, viperKey, helpText string, required bool) {
+	requiredText := "optional"
+	if required {
+		requiredText = "required"
+	}
+	cmd.Flags().StringSlice(cobraKey, []string{}, fmt.Sprintf("%s (%s) %s", envVarName(viperKey), requiredText, helpText))
+	bindParameter(cmd, cobraKey, viperKey)
+}
+
+func registerIntParameterWithDefault(cmd *cobra.Command, cobraKey, viperKey, helpText string, defaultValue int) {
+	cmd.Flags().Int(cobraKey, defaultValue, fmt.Sprintf("%s (optional) %s", envVarName(viperKey), helpText))
+	bindParameter(cmd, cobraKey, viperKey)
+}
+
+func registerDurationParameterWithDefault(cmd *cobra.Command, cobraKey, viperKey, helpText string, defaultValue time.Duration) {
+	cmd.Flags().Duration(cobraKey, defaultValue, fmt.Sprintf("%s (optional) %s", envVarName(viperKey), helpText))
+	bindParameter(cmd, cobraKey, viperKey)
 }
 
 func registerBoolParameterWithDefault(cmd *cobra.Command, cobraKey, viperKey, helpText string, defaultValue bool) {
 	cmd.Flags().Bool(cobraKey, defaultValue, fmt.Sprintf("%s (optional) %s", envVarName(viperKey), helpText))
+	bindParameter(cmd, cobraKey, viperKey)
+}
+
+func bindParameter(cmd *cobra.Command, cobraKey, viperKey string) {
+	if parameterBindings[cmd] == nil {
+		parameterBindings[cmd] = map[string]string{}
+	}
+	parameterBindings[cmd][viperKey] = cobraKey
 	cmdViper.BindPFlag(viperKey, cmd.Flags().Lookup(cobraKey))
 }
 
+func bindParameters(cmd *cobra.Command) {
+	for viperKey, cobraKey := range parameterBindings[cmd] {
+		cmdViper.BindPFlag(viperKey, cmd.Flags().Lookup(cobraKey))
+	}
+}
+
 func envVarName(viperKey string) string {
 	return strings.ToUpper(viperReplacer.Replace(viperKey))
 }
@@ -0,0 +1,150 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/rotation"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/routing"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
+)
+
+const (
+	cobraKeyRotateDryRun string = "dry-run"
+	viperKeyRotateDryRun string = "rotate.dry_run"
+
+	cobraKeyRotateStatesFile string = "states-file"
+	viperKeyRotateStatesFile string = "rotate.states_file"
+
+	cobraKeyRotateHost string = "host"
+	viperKeyRotateHost string = "rotate.host"
+)
+
+// rotateCmd represents the rotate command
+var rotateCmd = &cobra.Command{
+	Use:   "rotate [state path]...",
+	Short: "Re-encrypt terraform states with the current keys",
+	Long: `Re-encrypts terraform states of the backend terraform state server with the
+currently configured keys.
+
+Each state is locked, read from the backend, decrypted with the configured
+private keys, encrypted with the current key groups and written back. The
+state paths are taken from the arguments and the states file, one path per
+line. Keep the old AGE private keys configured until all states are rotated.
+The backend routes match the state paths with the given host, states of
+routes with other hosts are rotated by another run.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := newServerConfig()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			_ = cmd.Usage()
+			os.Exit(200)
+		}
+		paths, err := statePaths(args, cmdViper.GetString(viperKeyRotateStatesFile))
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(200)
+		}
+		if len(paths) == 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "no state paths given")
+			_ = cmd.Usage()
+			os.Exit(200)
+		}
+		backendClient, err := backend.New(config)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(200)
+		}
+		routes, err := routing.New(config, backendClient)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(200)
+		}
+		stateTransformer := transformer.New()
+		rotators := map[string]rotation.Rotator{}
+		counts := map[rotation.Status]int{}
+		for _, path := range paths {
+			result := rotation.Result{Path: path, Status: rotation.StatusFailed}
+			if route, err := routes.Match(cmdViper.GetString(viperKeyRotateHost), path); err != nil {
+				result.Err = err
+			} else {
+				rotator, ok := rotators[route.Name]
+				if !ok {
+					rotator = rotation.New(route.Config, route.Backend, stateTransformer, cmdViper.GetBool(viperKeyRotateDryRun))
+					rotators[route.Name] = rotator
+				}
+				result = rotator.Rotate(path, route.UpstreamPath(path))
+			}
+			counts[result.Status]++
+			fmt.Println(result)
+		}
+		fmt.Printf("%d rotated, %d dry-run, %d skipped, %d failed\n",
+			counts[rotation.StatusRotated],
+			counts[rotation.StatusDryRun],
+			counts[rotation.StatusSkipped],
+			counts[rotation.StatusFailed],
+		)
+		if counts[rotation.StatusFailed] > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func initRotateCmd() {
+	rootCmd.AddCommand(rotateCmd)
+
+	registerBoolParameterWithDefault(rotateCmd, cobraKeyRotateDryRun, viperKeyRotateDryRun, "decrypt and encrypt the states without locking and writing them", false)
+	registerStringParameter(rotateCmd, cobraKeyRotateStatesFile, viperKeyRotateStatesFile, "file with one state path per line, \"-\" reads from stdin", false)
+	registerStringParameter(rotateCmd, cobraKeyRotateHost, viperKeyRotateHost, "host of the state paths to match backend routes with hosts", false)
+	registerTransformParameters(rotateCmd)
+	registerBackendParameters(rotateCmd)
+	registerLogParameters(rotateCmd)
+}
+
+// statePaths returns the paths of the arguments followed by the paths of the states file
+func statePaths(args []string, statesFile string) ([]string, error) {
+	paths := append([]string{}, args...)
+	if statesFile == "" {
+		return paths, nil
+	}
+	var reader io.Reader = os.Stdin
+	if statesFile != "-" {
+		file, err := os.Open(statesFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not read states file: %w", err)
+		}
+		defer file.Close()
+		reader = file
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" || strings.HasPrefix(path, "#") {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can not read states file: %w", err)
+	}
+	return paths, nil
+}
@@ -15,17 +15,24 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/spf13/cobra"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/audit"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/auth"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/cache"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/monitoring"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/routing"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/server"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
 )
@@ -40,30 +47,227 @@ const (
 	cobraKeyAgePublicKey string = "age-public-key"
 	viperKeyAgePublicKey string = "transform.age.public_key"
 
+	cobraKeyAgePublicKeys string = "age-public-keys"
+	viperKeyAgePublicKeys string = "transform.age.public_keys"
+
 	cobraKeyAgePrivateKey string = "age-private-key"
 	viperKeyAgePrivateKey string = "transform.age.private_key"
 
 	cobraKeyVaultAddr string = "vault-addr"
 	viperKeyVaultAddr string = "transform.vault.address"
 
+	cobraKeyVaultAuthMethod string = "vault-auth-method"
+	viperKeyVaultAuthMethod string = "transform.vault.auth.method"
+
+	cobraKeyVaultAuthMount string = "vault-auth-mount"
+	viperKeyVaultAuthMount string = "transform.vault.auth.mount"
+
+	cobraKeyVaultAuthRole string = "vault-auth-role"
+	viperKeyVaultAuthRole string = "transform.vault.auth.role"
+
+	cobraKeyVaultAuthJWT string = "vault-auth-jwt"
+	viperKeyVaultAuthJWT string = "transform.vault.auth.jwt"
+
+	cobraKeyVaultAuthJWTFile string = "vault-auth-jwt-file"
+	viperKeyVaultAuthJWTFile string = "transform.vault.auth.jwt_file"
+
+	cobraKeyVaultToken string = "vault-token"
+	viperKeyVaultToken string = "transform.vault.auth.token"
+
 	cobraKeyVaultAppRoleID string = "vault-app-role-id"
 	viperKeyVaultAppRoleID string = "transform.vault.app_role.id"
 
 	cobraKeyVaultAppRoleSecretID string = "vault-app-role-secret-id"
 	viperKeyVaultAppRoleSecretID string = "transform.vault.app_role.secret_id"
 
+	cobraKeyVaultNamespace string = "vault-namespace"
+	viperKeyVaultNamespace string = "transform.vault.namespace"
+
+	cobraKeyVaultCACert string = "vault-ca-cert"
+	viperKeyVaultCACert string = "transform.vault.tls.ca_cert"
+
+	cobraKeyVaultCACertFile string = "vault-ca-cert-file"
+	viperKeyVaultCACertFile string = "transform.vault.tls.ca_cert_file"
+
+	cobraKeyVaultClientCert string = "vault-client-cert"
+	viperKeyVaultClientCert string = "transform.vault.tls.client_cert"
+
+	cobraKeyVaultClientCertFile string = "vault-client-cert-file"
+	viperKeyVaultClientCertFile string = "transform.vault.tls.client_cert_file"
+
+	cobraKeyVaultClientKey string = "vault-client-key"
+	viperKeyVaultClientKey string = "transform.vault.tls.client_key"
+
+	cobraKeyVaultClientKeyFile string = "vault-client-key-file"
+	viperKeyVaultClientKeyFile string = "transform.vault.tls.client_key_file"
+
+	cobraKeyVaultTLSServerName string = "vault-tls-server-name"
+	viperKeyVaultTLSServerName string = "transform.vault.tls.server_name"
+
 	cobraKeyVaultTransitMount string = "vault-transit-mount"
 	viperKeyVaultTransitMount string = "transform.vault.transit.mount"
 
 	cobraKeyVaultTransitName string = "vault-transit-name"
 	viperKeyVaultTransitName string = "transform.vault.transit.name"
 
+	cobraKeyAWSKMSEndpoint string = "aws-kms-endpoint"
+	viperKeyAWSKMSEndpoint string = "transform.aws_kms.endpoint"
+
+	cobraKeyGCPKMSEndpoint string = "gcp-kms-endpoint"
+	viperKeyGCPKMSEndpoint string = "transform.gcp_kms.endpoint"
+
+	cobraKeyShamirThreshold string = "shamir-threshold"
+	viperKeyShamirThreshold string = "transform.shamir_threshold"
+
+	// key groups are a list of maps and can be set by the configuration file only
+	viperKeyKeyGroups string = "transform.key_groups"
+
+	// creation rules are a list of maps and can be set by the configuration file only
+	viperKeyCreationRules string = "transform.creation_rules"
+
+	cobraKeyEncryptionStrategy string = "encryption-strategy"
+	viperKeyEncryptionStrategy string = "transform.strategy"
+
+	cobraKeyAlwaysEncryptedKeys string = "always-encrypted-keys"
+	viperKeyAlwaysEncryptedKeys string = "transform.sensitive.always_encrypt"
+
+	cobraKeyEncryptedRegex string = "encrypted-regex"
+	viperKeyEncryptedRegex string = "transform.encrypted_regex"
+
+	cobraKeyUnencryptedRegex string = "unencrypted-regex"
+	viperKeyUnencryptedRegex string = "transform.unencrypted_regex"
+
+	cobraKeyUnencryptedSuffix string = "unencrypted-suffix"
+	viperKeyUnencryptedSuffix string = "transform.unencrypted_suffix"
+
+	cobraKeyMACOnlyEncrypted string = "mac-only-encrypted"
+	viperKeyMACOnlyEncrypted string = "transform.mac_only_encrypted"
+
 	cobraKeyServerPort string = "port"
 	viperKeyServerPort string = "server.port"
 
+	cobraKeyServerShutdownTimeout string = "shutdown-timeout"
+	viperKeyServerShutdownTimeout string = "server.shutdown_timeout"
+
+	cobraKeyServerReadTimeout string = "read-timeout"
+	viperKeyServerReadTimeout string = "server.read_timeout"
+
+	cobraKeyServerWriteTimeout string = "write-timeout"
+	viperKeyServerWriteTimeout string = "server.write_timeout"
+
+	cobraKeyServerMaxBodySize string = "max-body-size"
+	viperKeyServerMaxBodySize string = "server.max_body_size"
+
+	cobraKeyServerDecryptFailurePolicy string = "decrypt-failure-policy"
+	viperKeyServerDecryptFailurePolicy string = "server.decrypt_failure_policy"
+
+	cobraKeyServerMigrationMode string = "migration-mode"
+	viperKeyServerMigrationMode string = "server.migration_mode"
+
+	cobraKeyServerWriteGuard string = "write-guard"
+	viperKeyServerWriteGuard string = "server.write_guard"
+
+	cobraKeyCacheMaxSize string = "cache-max-size"
+	viperKeyCacheMaxSize string = "cache.max_size"
+
+	cobraKeyCacheTTL string = "cache-ttl"
+	viperKeyCacheTTL string = "cache.ttl"
+
+	cobraKeyDataKeyReuseWindow string = "data-key-reuse-window"
+	viperKeyDataKeyReuseWindow string = "cache.data_key_reuse_window"
+
+	// dataKeyCacheMaxSize holds about 2000 data keys of 32 bytes
+	dataKeyCacheMaxSize int64 = 64 << 10
+	// reusableDataKeyCacheMaxSize holds the data keys and their encrypted copies of about 1000 states
+	reusableDataKeyCacheMaxSize int64 = 1 << 20
+
+	cobraKeyMonitoringAddress string = "monitoring-address"
+	viperKeyMonitoringAddress string = "monitoring.address"
+
+	cobraKeyMonitoringPort string = "monitoring-port"
+	viperKeyMonitoringPort string = "monitoring.port"
+
+	cobraKeyServerTLSCertFile string = "tls-cert-file"
+	viperKeyServerTLSCertFile string = "server.tls.cert_file"
+
+	cobraKeyServerTLSKeyFile string = "tls-key-file"
+	viperKeyServerTLSKeyFile string = "server.tls.key_file"
+
+	cobraKeyServerTLSClientCAFile string = "tls-client-ca-file"
+	viperKeyServerTLSClientCAFile string = "server.tls.client_ca_file"
+
+	cobraKeyServerTLSClientSubjects string = "tls-client-subjects"
+	viperKeyServerTLSClientSubjects string = "server.tls.client_subjects"
+
+	cobraKeyAuthTokensFile string = "auth-tokens-file"
+	viperKeyAuthTokensFile string = "auth.tokens_file"
+
+	cobraKeyAuthHtpasswdFile string = "auth-htpasswd-file"
+	viperKeyAuthHtpasswdFile string = "auth.htpasswd_file"
+
+	cobraKeyAuthJWTKeyFile string = "auth-jwt-key-file"
+	viperKeyAuthJWTKeyFile string = "auth.jwt.key_file"
+
+	cobraKeyAuthJWTIssuer string = "auth-jwt-issuer"
+	viperKeyAuthJWTIssuer string = "auth.jwt.issuer"
+
+	cobraKeyAuthJWTAudience string = "auth-jwt-audience"
+	viperKeyAuthJWTAudience string = "auth.jwt.audience"
+
+	cobraKeyAuthJWTIdentityClaim string = "auth-jwt-identity-claim"
+	viperKeyAuthJWTIdentityClaim string = "auth.jwt.identity_claim"
+
+	cobraKeyAuthPolicyFile string = "auth-policy-file"
+	viperKeyAuthPolicyFile string = "auth.policy_file"
+
+	cobraKeyAuthForwardCredentials string = "auth-forward-credentials"
+	viperKeyAuthForwardCredentials string = "auth.forward_credentials"
+
+	cobraKeyAuditFile string = "audit-file"
+	viperKeyAuditFile string = "audit.file"
+
+	cobraKeyAuditSyslogNetwork string = "audit-syslog-network"
+	viperKeyAuditSyslogNetwork string = "audit.syslog.network"
+
+	cobraKeyAuditSyslogAddress string = "audit-syslog-address"
+	viperKeyAuditSyslogAddress string = "audit.syslog.address"
+
+	cobraKeyBackendType string = "backend-type"
+	viperKeyBackendType string = "backend.type"
+
 	cobraKeyBackendURL string = "backend-url"
 	viperKeyBackendURL string = "backend.url"
 
+	cobraKeyBackendLocalDirectory string = "backend-local-directory"
+	viperKeyBackendLocalDirectory string = "backend.local.directory"
+
+	cobraKeyBackendS3Bucket string = "backend-s3-bucket"
+	viperKeyBackendS3Bucket string = "backend.s3.bucket"
+
+	cobraKeyBackendS3Prefix string = "backend-s3-prefix"
+	viperKeyBackendS3Prefix string = "backend.s3.prefix"
+
+	cobraKeyBackendS3Region string = "backend-s3-region"
+	viperKeyBackendS3Region string = "backend.s3.region"
+
+	cobraKeyBackendS3Endpoint string = "backend-s3-endpoint"
+	viperKeyBackendS3Endpoint string = "backend.s3.endpoint"
+
+	cobraKeyBackendS3PathStyle string = "backend-s3-use-path-style"
+	viperKeyBackendS3PathStyle string = "backend.s3.use_path_style"
+
+	cobraKeyBackendS3AccessKeyID string = "backend-s3-access-key-id"
+	viperKeyBackendS3AccessKeyID string = "backend.s3.access_key_id"
+
+	cobraKeyBackendS3SecretAccessKey string = "backend-s3-secret-access-key"
+	viperKeyBackendS3SecretAccessKey string = "backend.s3.secret_access_key"
+
+	cobraKeyBackendPostgresDSN string = "backend-postgres-dsn"
+	viperKeyBackendPostgresDSN string = "backend.postgres.dsn"
+
+	cobraKeyBackendPostgresTable string = "backend-postgres-table"
+	viperKeyBackendPostgresTable string = "backend.postgres.table"
+
 	cobraKeyBackendLockMethod string = "backend-lock-method"
 	viperKeyBackendLockMethod string = "backend.lock_method"
 
@@ -84,6 +288,23 @@ const (
 
 	cobraKeyBackendMTLSKeyFile string = "backend-mtls-key-file"
 	viperKeyBackendMTLSKeyFile string = "backend.mtls.key_file"
+
+	cobraKeyBackendUsername string = "backend-username"
+	viperKeyBackendUsername string = "backend.username"
+
+	cobraKeyBackendPassword string = "backend-password"
+	viperKeyBackendPassword string = "backend.password"
+
+	cobraKeyBackendPasswordFile string = "backend-password-file"
+	viperKeyBackendPasswordFile string = "backend.password_file"
+
+	cobraKeyBackendToken string = "backend-token"
+	viperKeyBackendToken string = "backend.token"
+
+	cobraKeyBackendTokenFile string = "backend-token-file"
+	viperKeyBackendTokenFile string = "backend.token_file"
+
+	viperKeyBackendRoutes string = "backend.routes"
 )
 
 var (
@@ -123,51 +344,213 @@ This uses the default interface to accept incoming traffic`,
 			_ = cmd.Usage()
 			os.Exit(200)
 		}
-		wg := sync.WaitGroup{}
-		wg.Add(2)
-		go func() {
-			defer wg.Done()
-			monitoring.NewMonitoringServer(
-				config,
-				backendClient,
-			).Start()
-		}()
-		go func() {
-			defer wg.Done()
-			server.New(
-				config,
-				backendClient,
-				transformer.New(),
-			).Start()
-		}()
-		wg.Wait()
+		routes, err := routing.New(config, backendClient)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			_, _ = fmt.Fprintln(os.Stderr, config)
+			_ = cmd.Usage()
+			os.Exit(200)
+		}
+		authorizer, err := auth.New(config)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			_, _ = fmt.Fprintln(os.Stderr, config)
+			_ = cmd.Usage()
+			os.Exit(200)
+		}
+		auditor, err := audit.New(config)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			_, _ = fmt.Fprintln(os.Stderr, config)
+			_ = cmd.Usage()
+			os.Exit(200)
+		}
+		runServers(
+			config,
+			monitoring.NewMonitoringServer(config, backendClient, transformer.NewChecker()),
+			server.New(config, backendClient, routes, newTransformer(config), authorizer, auditor),
+		)
 	},
 }
 
+// newTransformer creates the transformer of the service, which caches the data keys if the cache is enabled and
+// reuses the data keys if the data key reuse window is set
+func newTransformer(config config.ServerConfig) transformer.SOPSTransformer {
+	var dataKeys, reusableDataKeys *cache.Cache
+	if config.CacheMaxSize() > 0 {
+		dataKeys = cache.New("data_key", dataKeyCacheMaxSize, config.CacheTTL())
+	}
+	if config.DataKeyReuseWindow() > 0 {
+		reusableDataKeys = cache.New("data_key_reuse", reusableDataKeyCacheMaxSize, config.DataKeyReuseWindow())
+	}
+	return transformer.NewCaching(dataKeys, reusableDataKeys)
+}
+
+// runServers runs the servers until SIGINT or SIGTERM or until a server fails. On shutdown the readiness probe fails
+// first and the in-flight requests get the shutdown timeout to finish.
+func runServers(config config.ServerConfig, monitoringServer monitoring.Server, frontendServer server.Server) {
+	logger := config.Logger()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 2)
+	go func() { errs <- monitoringServer.Start() }()
+	go func() { errs <- frontendServer.Start() }()
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		logger.Info("Received signal, shutdown", "timeout", config.ServerShutdownTimeout())
+	case err := <-errs:
+		logger.Error("Server failed, shutdown", "err", err)
+		exitCode = 1
+	}
+	stop()
+	monitoringServer.MarkShuttingDown()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ServerShutdownTimeout())
+	defer cancel()
+	if err := frontendServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("In-flight requests not finished within the shutdown timeout", "err", err)
+		exitCode = 1
+	}
+	if err := monitoringServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Can not shutdown monitoring service", "err", err)
+		exitCode = 1
+	}
+	logger.Info("Shutdown finished")
+	if exitCode != 0 {
+		cancel()
+		os.Exit(exitCode)
+	}
+}
+
 func initStartCmd() {
 	rootCmd.AddCommand(startCmd)
 
-	registerStringParameter(startCmd, cobraKeyAgePublicKey, viperKeyAgePublicKey, "public AGE key to encrypt terraform state", true)
-	registerStringParameter(startCmd, cobraKeyAgePrivateKey, viperKeyAgePrivateKey, "private AGE key to decrypt terraform state", false)
-	registerStringParameter(startCmd, cobraKeyVaultAddr, viperKeyVaultAddr, "vault address to de- and encrypt terraform state", false)
-	registerStringParameter(startCmd, cobraKeyVaultAppRoleID, viperKeyVaultAppRoleID, "(required if --vault-addr != \"\") AppRole ID to authenticate with vault", false)
-	registerStringParameter(startCmd, cobraKeyVaultAppRoleSecretID, viperKeyVaultAppRoleSecretID, "(required if --vault-addr != \"\") AppRole secret ID to authenticate with vault", false)
-	registerStringParameterWithDefault(startCmd, cobraKeyVaultTransitMount, viperKeyVaultTransitMount, "mount point of the transit engine to use", false, "sops")
-	registerStringParameterWithDefault(startCmd, cobraKeyVaultTransitName, viperKeyVaultTransitName, "name of the transit engine secret to use", false, "terraform")
+	registerTransformParameters(startCmd)
 	registerStringParameterWithDefault(startCmd, cobraKeyServerPort, viperKeyServerPort, "port the service is listening to", false, "8080")
-	registerStringParameter(startCmd, cobraKeyBackendURL, viperKeyBackendURL, "base url to connect with the backend terraform state server", true)
-	registerStringParameter(startCmd, cobraKeyBackendMTLSCert, viperKeyBackendMTLSCert, "cert data for mTLS authentication", false)
-	registerStringParameter(startCmd, cobraKeyBackendMTLSCertFile, viperKeyBackendMTLSCertFile, "certificate file for mTLS authentication", false)
-	registerStringParameter(startCmd, cobraKeyBackendMTLSKey, viperKeyBackendMTLSKey, "key data for mTLS authentication", false)
-	registerStringParameter(startCmd, cobraKeyBackendMTLSKeyFile, viperKeyBackendMTLSKeyFile, "key file for mTLS authentication", false)
-	registerStringParameterWithDefault(startCmd, cobraKeyBackendLockMethod, viperKeyBackendLockMethod, "lock method to use with the backend terraform state server", false, "LOCK")
-	registerStringParameterWithDefault(startCmd, cobraKeyBackendUnlockMethod, viperKeyBackendUnlockMethod, "unlock method to use with the backend terraform state server", false, "UNLOCK")
+	registerDurationParameterWithDefault(startCmd, cobraKeyServerShutdownTimeout, viperKeyServerShutdownTimeout, "time to finish in-flight requests on SIGINT or SIGTERM", 30*time.Second)
+	registerDurationParameterWithDefault(startCmd, cobraKeyServerReadTimeout, viperKeyServerReadTimeout, "time to read a request including its body (0 = no timeout)", time.Minute)
+	registerDurationParameterWithDefault(startCmd, cobraKeyServerWriteTimeout, viperKeyServerWriteTimeout, "time to answer a request after its header has been read (0 = no timeout)", 2*time.Minute)
+	registerIntParameterWithDefault(startCmd, cobraKeyServerMaxBodySize, viperKeyServerMaxBodySize, "maximum size in bytes of request and backend response bodies, larger requests are rejected with 413 (0 = unlimited)", 64<<20)
+	registerStringParameterWithDefault(startCmd, cobraKeyServerDecryptFailurePolicy, viperKeyServerDecryptFailurePolicy, fmt.Sprintf("answer to a terraform state, which can not be decrypted, one of [%s]", strings.Join([]string{
+		config.DecryptFailurePolicyPassthrough,
+		config.DecryptFailurePolicyPassthroughIfPlaintext,
+		config.DecryptFailurePolicyFail,
+	}, ", ")), false, config.DecryptFailurePolicyPassthrough)
+	registerStringParameterWithDefault(startCmd, cobraKeyServerMigrationMode, viperKeyServerMigrationMode, fmt.Sprintf("handling of plaintext terraform states read by GET requests one of [%s]", strings.Join([]string{
+		config.MigrationModeOff,
+		config.MigrationModeDetect,
+		config.MigrationModeEncrypt,
+	}, ", ")), false, config.MigrationModeOff)
+	registerBoolParameterWithDefault(startCmd, cobraKeyServerWriteGuard, viperKeyServerWriteGuard, "reject writes of terraform states with another lineage or a lower serial than the stored state with 409", false)
+	registerStringParameter(startCmd, cobraKeyServerTLSCertFile, viperKeyServerTLSCertFile, "certificate file to serve TLS, reloaded on change", false)
+	registerStringParameter(startCmd, cobraKeyServerTLSKeyFile, viperKeyServerTLSKeyFile, "(required if --tls-cert-file != \"\") key file to serve TLS, reloaded on change", false)
+	registerStringParameter(startCmd, cobraKeyServerTLSClientCAFile, viperKeyServerTLSClientCAFile, "PEM encoded CA bundle file to verify client certificates, clients without a valid certificate are rejected", false)
+	registerStringSliceParameter(startCmd, cobraKeyServerTLSClientSubjects, viperKeyServerTLSClientSubjects, "allowed subjects (distinguished name like \"CN=runner,O=example\" or common name) of client certificates (default all subjects)", false)
+	registerStringParameter(startCmd, cobraKeyMonitoringAddress, viperKeyMonitoringAddress, "address the monitoring service is listening to (default all interfaces)", false)
+	registerStringParameterWithDefault(startCmd, cobraKeyMonitoringPort, viperKeyMonitoringPort, "port the monitoring service is listening to", false, "2112")
+	registerIntParameterWithDefault(startCmd, cobraKeyCacheMaxSize, viperKeyCacheMaxSize, "maximum size in bytes of the decrypted terraform states kept in memory (0 = cache disabled)", 0)
+	registerDurationParameterWithDefault(startCmd, cobraKeyCacheTTL, viperKeyCacheTTL, "time to keep decrypted terraform states and data keys in memory", 5*time.Minute)
+	registerDurationParameterWithDefault(startCmd, cobraKeyDataKeyReuseWindow, viperKeyDataKeyReuseWindow, "time to reuse the data key of a terraform state for further encryptions of the same path as long as its key groups do not change, which skips the key services (0 = new data key for every encryption)", 0)
+	registerAuthParameters(startCmd)
+	registerStringParameter(startCmd, cobraKeyAuditFile, viperKeyAuditFile, "file to append one JSON audit record per request to", false)
+	registerStringParameterWithDefault(startCmd, cobraKeyAuditSyslogNetwork, viperKeyAuditSyslogNetwork, "network of the syslog server to send the audit records to one of [udp, tcp, unix, unixgram]", false, "udp")
+	registerStringParameter(startCmd, cobraKeyAuditSyslogAddress, viperKeyAuditSyslogAddress, "address of the syslog server to send the audit records to, e.g. \"localhost:514\" or \"/dev/log\"", false)
+	registerBackendParameters(startCmd)
 	registerStringParameterWithDefault(startCmd, cobraKeyBackendReadinessProbePath, viperKeyBackendReadinessProbePath, "path to probe backend for readiness.", false, "/")
+	registerLogParameters(startCmd)
+}
 
-	//-------
+// registerTransformParameters registers the parameters to de- and encrypt terraform states
+func registerTransformParameters(cmd *cobra.Command) {
+	registerStringParameter(cmd, cobraKeyAgePublicKey, viperKeyAgePublicKey, "(deprecated, use --age-public-keys) public AGE key to encrypt terraform state", false)
+	registerStringSliceParameter(cmd, cobraKeyAgePublicKeys, viperKeyAgePublicKeys, "(required if --age-public-key == \"\") public AGE keys (recipients) to encrypt terraform state", false)
+	registerStringParameter(cmd, cobraKeyAgePrivateKey, viperKeyAgePrivateKey, "private AGE key to decrypt terraform state", false)
+	registerStringParameter(cmd, cobraKeyVaultAddr, viperKeyVaultAddr, "vault address to de- and encrypt terraform state", false)
+	registerStringParameterWithDefault(cmd, cobraKeyVaultAuthMethod, viperKeyVaultAuthMethod, fmt.Sprintf("method to authenticate with vault one of [%s]", strings.Join([]string{
+		config.VaultAuthMethodAppRole,
+		config.VaultAuthMethodToken,
+		config.VaultAuthMethodKubernetes,
+		config.VaultAuthMethodJWT,
+	}, ", ")), false, config.VaultAuthMethodAppRole)
+	registerStringParameter(cmd, cobraKeyVaultAuthMount, viperKeyVaultAuthMount, "mount path of the vault auth method (default the name of the auth method)", false)
+	registerStringParameter(cmd, cobraKeyVaultAuthRole, viperKeyVaultAuthRole, "(required if --vault-auth-method == \"kubernetes\") role to authenticate with vault using the kubernetes or jwt auth method", false)
+	registerStringParameter(cmd, cobraKeyVaultAuthJWT, viperKeyVaultAuthJWT, "(required if --vault-auth-method == \"jwt\" and no --vault-auth-jwt-file) JWT to authenticate with vault", false)
+	registerStringParameter(cmd, cobraKeyVaultAuthJWTFile, viperKeyVaultAuthJWTFile, "file containing the JWT to authenticate with vault, read on every login (kubernetes default \"/var/run/secrets/kubernetes.io/serviceaccount/token\")", false)
+	registerStringParameter(cmd, cobraKeyVaultToken, viperKeyVaultToken, "(required if --vault-auth-method == \"token\") token to authenticate with vault", false)
+	registerStringParameter(cmd, cobraKeyVaultAppRoleID, viperKeyVaultAppRoleID, "(required if --vault-addr != \"\" and --vault-auth-method == \"approle\") AppRole ID to authenticate with vault", false)
+	registerStringParameter(cmd, cobraKeyVaultAppRoleSecretID, viperKeyVaultAppRoleSecretID, "(required if --vault-addr != \"\" and --vault-auth-method == \"approle\") AppRole secret ID to authenticate with vault", false)
+	registerStringParameter(cmd, cobraKeyVaultNamespace, viperKeyVaultNamespace, "vault enterprise namespace of the auth method and transit engine", false)
+	registerStringParameter(cmd, cobraKeyVaultCACert, viperKeyVaultCACert, "PEM encoded CA bundle data to verify the vault server certificate", false)
+	registerStringParameter(cmd, cobraKeyVaultCACertFile, viperKeyVaultCACertFile, "PEM encoded CA bundle file to verify the vault server certificate", false)
+	registerStringParameter(cmd, cobraKeyVaultClientCert, viperKeyVaultClientCert, "cert data for TLS client authentication with vault", false)
+	registerStringParameter(cmd, cobraKeyVaultClientCertFile, viperKeyVaultClientCertFile, "certificate file for TLS client authentication with vault", false)
+	registerStringParameter(cmd, cobraKeyVaultClientKey, viperKeyVaultClientKey, "key data for TLS client authentication with vault", false)
+	registerStringParameter(cmd, cobraKeyVaultClientKeyFile, viperKeyVaultClientKeyFile, "key file for TLS client authentication with vault", false)
+	registerStringParameter(cmd, cobraKeyVaultTLSServerName, viperKeyVaultTLSServerName, "server name to verify the vault server certificate with (default the host of --vault-addr)", false)
+	registerStringParameterWithDefault(cmd, cobraKeyVaultTransitMount, viperKeyVaultTransitMount, "mount point of the transit engine to use", false, "sops")
+	registerStringParameterWithDefault(cmd, cobraKeyVaultTransitName, viperKeyVaultTransitName, "name of the transit engine secret to use", false, "terraform")
+	registerStringParameter(cmd, cobraKeyAWSKMSEndpoint, viperKeyAWSKMSEndpoint, "URL of an AWS KMS compatible service, e.g. a local emulator (default the AWS KMS endpoint of the region of the key)", false)
+	registerStringParameter(cmd, cobraKeyGCPKMSEndpoint, viperKeyGCPKMSEndpoint, "URL of a GCP KMS compatible gRPC service, an http URL connects without TLS and authentication, e.g. to a local emulator (default the GCP KMS endpoint)", false)
+	registerIntParameterWithDefault(cmd, cobraKeyShamirThreshold, viperKeyShamirThreshold, "number of key groups required to decrypt the terraform state (0 = all key groups)", 0)
+	registerStringParameterWithDefault(cmd, cobraKeyEncryptionStrategy, viperKeyEncryptionStrategy, fmt.Sprintf("values to encrypt one of [%s, %s]", config.EncryptionStrategyAll, config.EncryptionStrategySensitive), false, config.EncryptionStrategyAll)
+	registerStringSliceParameter(cmd, cobraKeyAlwaysEncryptedKeys, viperKeyAlwaysEncryptedKeys, fmt.Sprintf("keys to encrypt in addition to the sensitive values with the %q encryption strategy", config.EncryptionStrategySensitive), false)
+	registerStringParameter(cmd, cobraKeyEncryptedRegex, viperKeyEncryptedRegex, "regex of the keys to encrypt, all other keys stay unencrypted", false)
+	registerStringParameter(cmd, cobraKeyUnencryptedRegex, viperKeyUnencryptedRegex, "regex of the keys to leave unencrypted (default \"^(version|terraform_version|serial|lineage)$\" if no other selection is set)", false)
+	registerStringParameter(cmd, cobraKeyUnencryptedSuffix, viperKeyUnencryptedSuffix, "suffix of the keys to leave unencrypted", false)
+	registerBoolParameterWithDefault(cmd, cobraKeyMACOnlyEncrypted, viperKeyMACOnlyEncrypted, "if the MAC only covers the encrypted values", false)
+}
 
-	registerBoolParameterWithDefault(startCmd, "log-json", "log.json", "if logging has to use json format", false)
-	registerStringParameterWithDefault(startCmd, "log-level", "log.level", fmt.Sprintf("active log level one of [%s]", strings.Join([]string{
+// registerAuthParameters registers the parameters to authenticate and authorize incoming requests
+func registerAuthParameters(cmd *cobra.Command) {
+	registerStringParameter(cmd, cobraKeyAuthTokensFile, viperKeyAuthTokensFile, "file with one \"<identity>:<token>\" per line to authenticate with static bearer tokens", false)
+	registerStringParameter(cmd, cobraKeyAuthHtpasswdFile, viperKeyAuthHtpasswdFile, "htpasswd file with bcrypt or SHA-1 hashes to authenticate with basic credentials", false)
+	registerStringParameter(cmd, cobraKeyAuthJWTKeyFile, viperKeyAuthJWTKeyFile, "file with PEM encoded public keys or certificates to verify JWTs", false)
+	registerStringParameter(cmd, cobraKeyAuthJWTIssuer, viperKeyAuthJWTIssuer, "required issuer of JWTs", false)
+	registerStringParameter(cmd, cobraKeyAuthJWTAudience, viperKeyAuthJWTAudience, "required audience of JWTs", false)
+	registerStringParameterWithDefault(cmd, cobraKeyAuthJWTIdentityClaim, viperKeyAuthJWTIdentityClaim, "claim of JWTs with the identity", false, "sub")
+	registerStringParameter(cmd, cobraKeyAuthPolicyFile, viperKeyAuthPolicyFile, "YAML file with rules granting permissions on state paths to identities (default all permissions for authenticated identities)", false)
+	registerBoolParameterWithDefault(cmd, cobraKeyAuthForwardCredentials, viperKeyAuthForwardCredentials, "forward the Authorization header of authorized requests to the backend instead of removing it", false)
+}
+
+// registerBackendParameters registers the parameters to connect with the backend terraform state server
+func registerBackendParameters(cmd *cobra.Command) {
+	registerStringParameterWithDefault(cmd, cobraKeyBackendType, viperKeyBackendType, fmt.Sprintf("storage of the terraform states one of [%s]", strings.Join([]string{
+		config.BackendTypeHTTP,
+		config.BackendTypeLocal,
+		config.BackendTypeS3,
+		config.BackendTypePostgres,
+	}, ", ")), false, config.BackendTypeHTTP)
+	registerStringParameter(cmd, cobraKeyBackendURL, viperKeyBackendURL, "(required if --backend-type == \"http\" without backend routes) base url to connect with the backend terraform state server", false)
+	registerStringParameter(cmd, cobraKeyBackendMTLSCert, viperKeyBackendMTLSCert, "cert data for mTLS authentication", false)
+	registerStringParameter(cmd, cobraKeyBackendMTLSCertFile, viperKeyBackendMTLSCertFile, "certificate file for mTLS authentication", false)
+	registerStringParameter(cmd, cobraKeyBackendMTLSKey, viperKeyBackendMTLSKey, "key data for mTLS authentication", false)
+	registerStringParameter(cmd, cobraKeyBackendMTLSKeyFile, viperKeyBackendMTLSKeyFile, "key file for mTLS authentication", false)
+	registerStringParameter(cmd, cobraKeyBackendUsername, viperKeyBackendUsername, "user name to authenticate with basic credentials at the backend terraform state server, replaces the credentials of the requests", false)
+	registerStringParameter(cmd, cobraKeyBackendPassword, viperKeyBackendPassword, "password to authenticate with basic credentials at the backend terraform state server", false)
+	registerStringParameter(cmd, cobraKeyBackendPasswordFile, viperKeyBackendPasswordFile, "file with the password to authenticate with basic credentials at the backend terraform state server", false)
+	registerStringParameter(cmd, cobraKeyBackendToken, viperKeyBackendToken, "bearer token to authenticate at the backend terraform state server, replaces the credentials of the requests", false)
+	registerStringParameter(cmd, cobraKeyBackendTokenFile, viperKeyBackendTokenFile, "file with the bearer token to authenticate at the backend terraform state server", false)
+	registerStringParameterWithDefault(cmd, cobraKeyBackendLockMethod, viperKeyBackendLockMethod, "lock method to use with the backend terraform state server", false, "LOCK")
+	registerStringParameterWithDefault(cmd, cobraKeyBackendUnlockMethod, viperKeyBackendUnlockMethod, "unlock method to use with the backend terraform state server", false, "UNLOCK")
+	registerStringParameter(cmd, cobraKeyBackendLocalDirectory, viperKeyBackendLocalDirectory, "(required if --backend-type == \"local\") directory to store the terraform states in", false)
+	registerStringParameter(cmd, cobraKeyBackendS3Bucket, viperKeyBackendS3Bucket, "(required if --backend-type == \"s3\") bucket to store the terraform states in", false)
+	registerStringParameter(cmd, cobraKeyBackendS3Prefix, viperKeyBackendS3Prefix, "prefix of the object keys of the terraform states", false)
+	registerStringParameter(cmd, cobraKeyBackendS3Region, viperKeyBackendS3Region, "region of the bucket (default the region of the AWS environment)", false)
+	registerStringParameter(cmd, cobraKeyBackendS3Endpoint, viperKeyBackendS3Endpoint, "endpoint of a S3 compatible object storage like MinIO", false)
+	registerBoolParameterWithDefault(cmd, cobraKeyBackendS3PathStyle, viperKeyBackendS3PathStyle, "if the bucket is addressed by the path instead of the host name", false)
+	registerStringParameter(cmd, cobraKeyBackendS3AccessKeyID, viperKeyBackendS3AccessKeyID, "access key ID of the object storage (default the credentials of the AWS environment)", false)
+	registerStringParameter(cmd, cobraKeyBackendS3SecretAccessKey, viperKeyBackendS3SecretAccessKey, "secret access key of the object storage", false)
+	registerStringParameter(cmd, cobraKeyBackendPostgresDSN, viperKeyBackendPostgresDSN, "(required if --backend-type == \"postgres\") connection string of the PostgreSQL database", false)
+	registerStringParameterWithDefault(cmd, cobraKeyBackendPostgresTable, viperKeyBackendPostgresTable, "table to store the terraform states in, the locks are stored in the table with the suffix \"_locks\"", false, "terraform_states")
+}
+
+// registerLogParameters registers the logging parameters
+func registerLogParameters(cmd *cobra.Command) {
+	registerBoolParameterWithDefault(cmd, "log-json", "log.json", "if logging has to use json format", false)
+	registerStringParameterWithDefault(cmd, "log-level", "log.level", fmt.Sprintf("active log level one of [%s]", strings.Join([]string{
 		traceLevel,
 		debugLevel,
 		infoLevel,
@@ -175,7 +558,6 @@ func initStartCmd() {
 		errorLevel,
 		offLevel,
 	}, ", ")), false, infoLevel)
-
 }
 
 func newServerConfig() (config.ServerConfig, error) {
@@ -189,40 +571,222 @@ type serverConfig struct {
 	logger hclog.Logger
 }
 
-func (c serverConfig) AgePublicKey() string   { return cmdViper.GetString(viperKeyAgePublicKey) }
-func (c serverConfig) AgePrivateKey() string  { return cmdViper.GetString(viperKeyAgePrivateKey) }
-func (c serverConfig) VaultAddr() string      { return cmdViper.GetString(viperKeyVaultAddr) }
+func (c serverConfig) AgePublicKeys() []string {
+	keys := make([]string, 0)
+	if key := cmdViper.GetString(viperKeyAgePublicKey); key != "" {
+		keys = append(keys, key)
+	}
+	for _, key := range cmdViper.GetStringSlice(viperKeyAgePublicKeys) {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+func (c serverConfig) AgePrivateKey() string { return cmdViper.GetString(viperKeyAgePrivateKey) }
+func (c serverConfig) VaultAddr() string     { return cmdViper.GetString(viperKeyVaultAddr) }
+func (c serverConfig) VaultAuthMethod() string {
+	return cmdViper.GetString(viperKeyVaultAuthMethod)
+}
+func (c serverConfig) VaultAuthMount() string { return cmdViper.GetString(viperKeyVaultAuthMount) }
+func (c serverConfig) VaultAuthRole() string  { return cmdViper.GetString(viperKeyVaultAuthRole) }
+func (c serverConfig) VaultAuthJWT() string   { return cmdViper.GetString(viperKeyVaultAuthJWT) }
+func (c serverConfig) VaultAuthJWTFile() string {
+	return cmdViper.GetString(viperKeyVaultAuthJWTFile)
+}
+func (c serverConfig) VaultToken() string     { return cmdViper.GetString(viperKeyVaultToken) }
 func (c serverConfig) VaultAppRoleID() string { return cmdViper.GetString(viperKeyVaultAppRoleID) }
 func (c serverConfig) VaultAppRoleSecretID() string {
 	return cmdViper.GetString(viperKeyVaultAppRoleSecretID)
 }
+func (c serverConfig) VaultNamespace() string { return cmdViper.GetString(viperKeyVaultNamespace) }
+func (c serverConfig) VaultCACert() []byte {
+	return c.dataOrFile(viperKeyVaultCACert, viperKeyVaultCACertFile, "vault CA cert")
+}
+func (c serverConfig) VaultClientCert() []byte {
+	return c.dataOrFile(viperKeyVaultClientCert, viperKeyVaultClientCertFile, "vault client cert")
+}
+func (c serverConfig) VaultClientKey() []byte {
+	return c.dataOrFile(viperKeyVaultClientKey, viperKeyVaultClientKeyFile, "vault client key")
+}
+func (c serverConfig) VaultTLSServerName() string {
+	return cmdViper.GetString(viperKeyVaultTLSServerName)
+}
 func (c serverConfig) VaultKeyMount() string { return cmdViper.GetString(viperKeyVaultTransitMount) }
 func (c serverConfig) VaultKeyName() string  { return cmdViper.GetString(viperKeyVaultTransitName) }
-func (c serverConfig) ServerPort() string    { return cmdViper.GetString(viperKeyServerPort) }
-func (c serverConfig) BackendURL() string    { return cmdViper.GetString(viperKeyBackendURL) }
-func (c serverConfig) BackendMTLSCert() []byte {
-	file := cmdViper.GetString(viperKeyBackendMTLSCertFile)
-	if file != "" {
-		data, err := os.ReadFile(file)
-		if err != nil {
-			c.logger.Error("error reading mTLS cert file", "file", file, "err", err)
-			os.Exit(200)
+func (c serverConfig) AWSKMSEndpoint() string {
+	return cmdViper.GetString(viperKeyAWSKMSEndpoint)
+}
+func (c serverConfig) GCPKMSEndpoint() string {
+	return cmdViper.GetString(viperKeyGCPKMSEndpoint)
+}
+func (c serverConfig) KeyGroups() []config.KeyGroup {
+	var values []keyGroupValue
+	if err := cmdViper.UnmarshalKey(viperKeyKeyGroups, &values); err != nil {
+		c.logger.Error("error reading key groups", "key", viperKeyKeyGroups, "err", err)
+		os.Exit(200)
+	}
+	keyGroups := make([]config.KeyGroup, 0, len(values))
+	for _, value := range values {
+		keyGroup := config.KeyGroup{
+			Name:                 value.Name,
+			AgePublicKeys:        value.Age.PublicKeys,
+			GCPKMSResourceIDs:    value.GCPKMS.ResourceIDs,
+			AzureKeyVaultKeyURLs: value.AzureKeyVault.KeyURLs,
+			PGPFingerprints:      value.PGP.Fingerprints,
 		}
-		return data
+		for _, transitKey := range value.Vault.TransitKeys {
+			keyGroup.VaultTransitKeys = append(keyGroup.VaultTransitKeys, config.VaultTransitKey{
+				Mount: transitKey.Mount,
+				Name:  transitKey.Name,
+			})
+		}
+		for _, awsKMSKey := range value.AWSKMS.Keys {
+			keyGroup.AWSKMSKeys = append(keyGroup.AWSKMSKeys, config.AWSKMSKey{
+				ARN:     awsKMSKey.ARN,
+				Role:    awsKMSKey.Role,
+				Profile: awsKMSKey.Profile,
+				Context: awsKMSKey.Context,
+			})
+		}
+		keyGroups = append(keyGroups, keyGroup)
 	}
-	return []byte(cmdViper.GetString(viperKeyBackendMTLSCert))
+	return keyGroups
 }
-func (c serverConfig) BackendMTLSKey() []byte {
-	file := cmdViper.GetString(viperKeyBackendMTLSKeyFile)
-	if file != "" {
-		data, err := os.ReadFile(file)
-		if err != nil {
-			c.logger.Error("error reading mTLS key file", "file", file, "err", err)
-			os.Exit(200)
-		}
-		return data
+func (c serverConfig) CreationRules() []config.CreationRule {
+	var values []creationRuleValue
+	if err := cmdViper.UnmarshalKey(viperKeyCreationRules, &values); err != nil {
+		c.logger.Error("error reading creation rules", "key", viperKeyCreationRules, "err", err)
+		os.Exit(200)
+	}
+	rules := make([]config.CreationRule, 0, len(values))
+	for _, value := range values {
+		rules = append(rules, config.CreationRule{
+			PathRegex:     value.PathRegex,
+			AgePublicKeys: value.Age.PublicKeys,
+			VaultTransitKey: config.VaultTransitKey{
+				Mount: value.Vault.TransitKey.Mount,
+				Name:  value.Vault.TransitKey.Name,
+			},
+			EncryptedRegex: value.EncryptedRegex,
+		})
 	}
-	return []byte(cmdViper.GetString(viperKeyBackendMTLSKey))
+	return rules
+}
+func (c serverConfig) ShamirThreshold() int { return cmdViper.GetInt(viperKeyShamirThreshold) }
+func (c serverConfig) EncryptionStrategy() string {
+	return cmdViper.GetString(viperKeyEncryptionStrategy)
+}
+func (c serverConfig) AlwaysEncryptedKeys() []string {
+	return cmdViper.GetStringSlice(viperKeyAlwaysEncryptedKeys)
+}
+func (c serverConfig) EncryptedRegex() string {
+	return cmdViper.GetString(viperKeyEncryptedRegex)
+}
+func (c serverConfig) UnencryptedRegex() string {
+	return cmdViper.GetString(viperKeyUnencryptedRegex)
+}
+func (c serverConfig) UnencryptedSuffix() string {
+	return cmdViper.GetString(viperKeyUnencryptedSuffix)
+}
+func (c serverConfig) MACOnlyEncrypted() bool {
+	return cmdViper.GetBool(viperKeyMACOnlyEncrypted)
+}
+func (c serverConfig) ServerPort() string { return cmdViper.GetString(viperKeyServerPort) }
+func (c serverConfig) ServerShutdownTimeout() time.Duration {
+	return cmdViper.GetDuration(viperKeyServerShutdownTimeout)
+}
+func (c serverConfig) ServerReadTimeout() time.Duration {
+	return cmdViper.GetDuration(viperKeyServerReadTimeout)
+}
+func (c serverConfig) ServerWriteTimeout() time.Duration {
+	return cmdViper.GetDuration(viperKeyServerWriteTimeout)
+}
+func (c serverConfig) ServerMaxBodySize() int64 {
+	return cmdViper.GetInt64(viperKeyServerMaxBodySize)
+}
+func (c serverConfig) DecryptFailurePolicy() string {
+	return cmdViper.GetString(viperKeyServerDecryptFailurePolicy)
+}
+func (c serverConfig) MigrationMode() string {
+	return cmdViper.GetString(viperKeyServerMigrationMode)
+}
+func (c serverConfig) WriteGuard() bool {
+	return cmdViper.GetBool(viperKeyServerWriteGuard)
+}
+func (c serverConfig) MonitoringAddress() string {
+	return cmdViper.GetString(viperKeyMonitoringAddress)
+}
+func (c serverConfig) MonitoringPort() string  { return cmdViper.GetString(viperKeyMonitoringPort) }
+func (c serverConfig) CacheMaxSize() int64     { return cmdViper.GetInt64(viperKeyCacheMaxSize) }
+func (c serverConfig) CacheTTL() time.Duration { return cmdViper.GetDuration(viperKeyCacheTTL) }
+func (c serverConfig) DataKeyReuseWindow() time.Duration {
+	return cmdViper.GetDuration(viperKeyDataKeyReuseWindow)
+}
+func (c serverConfig) ServerTLSCertFile() string {
+	return cmdViper.GetString(viperKeyServerTLSCertFile)
+}
+func (c serverConfig) ServerTLSKeyFile() string {
+	return cmdViper.GetString(viperKeyServerTLSKeyFile)
+}
+func (c serverConfig) ServerTLSClientCAFile() string {
+	return cmdViper.GetString(viperKeyServerTLSClientCAFile)
+}
+func (c serverConfig) ServerTLSClientSubjects() []string {
+	return cmdViper.GetStringSlice(viperKeyServerTLSClientSubjects)
+}
+func (c serverConfig) AuthTokensFile() string { return cmdViper.GetString(viperKeyAuthTokensFile) }
+func (c serverConfig) AuthHtpasswdFile() string {
+	return cmdViper.GetString(viperKeyAuthHtpasswdFile)
+}
+func (c serverConfig) AuthJWTKeyFile() string  { return cmdViper.GetString(viperKeyAuthJWTKeyFile) }
+func (c serverConfig) AuthJWTIssuer() string   { return cmdViper.GetString(viperKeyAuthJWTIssuer) }
+func (c serverConfig) AuthJWTAudience() string { return cmdViper.GetString(viperKeyAuthJWTAudience) }
+func (c serverConfig) AuthJWTIdentityClaim() string {
+	return cmdViper.GetString(viperKeyAuthJWTIdentityClaim)
+}
+func (c serverConfig) AuthForwardCredentials() bool {
+	return cmdViper.GetBool(viperKeyAuthForwardCredentials)
+}
+func (c serverConfig) AuthPolicyFile() string { return cmdViper.GetString(viperKeyAuthPolicyFile) }
+func (c serverConfig) BackendType() string    { return cmdViper.GetString(viperKeyBackendType) }
+func (c serverConfig) BackendURL() string     { return cmdViper.GetString(viperKeyBackendURL) }
+func (c serverConfig) BackendLocalDirectory() string {
+	return cmdViper.GetString(viperKeyBackendLocalDirectory)
+}
+func (c serverConfig) BackendS3Bucket() string { return cmdViper.GetString(viperKeyBackendS3Bucket) }
+func (c serverConfig) BackendS3Prefix() string { return cmdViper.GetString(viperKeyBackendS3Prefix) }
+func (c serverConfig) BackendS3Region() string { return cmdViper.GetString(viperKeyBackendS3Region) }
+func (c serverConfig) BackendS3Endpoint() string {
+	return cmdViper.GetString(viperKeyBackendS3Endpoint)
+}
+func (c serverConfig) BackendS3PathStyle() bool { return cmdViper.GetBool(viperKeyBackendS3PathStyle) }
+func (c serverConfig) BackendS3AccessKeyID() string {
+	return cmdViper.GetString(viperKeyBackendS3AccessKeyID)
+}
+func (c serverConfig) BackendS3SecretAccessKey() string {
+	return cmdViper.GetString(viperKeyBackendS3SecretAccessKey)
+}
+func (c serverConfig) BackendPostgresDSN() string {
+	return cmdViper.GetString(viperKeyBackendPostgresDSN)
+}
+func (c serverConfig) BackendPostgresTable() string {
+	return cmdViper.GetString(viperKeyBackendPostgresTable)
+}
+func (c serverConfig) BackendMTLSCert() []byte {
+	return c.dataOrFile(viperKeyBackendMTLSCert, viperKeyBackendMTLSCertFile, "mTLS cert")
+}
+func (c serverConfig) BackendMTLSKey() []byte {
+	return c.dataOrFile(viperKeyBackendMTLSKey, viperKeyBackendMTLSKeyFile, "mTLS key")
+}
+func (c serverConfig) BackendUsername() string {
+	return cmdViper.GetString(viperKeyBackendUsername)
+}
+func (c serverConfig) BackendPassword() string {
+	return strings.TrimSpace(string(c.dataOrFile(viperKeyBackendPassword, viperKeyBackendPasswordFile, "backend password")))
+}
+func (c serverConfig) BackendToken() string {
+	return strings.TrimSpace(string(c.dataOrFile(viperKeyBackendToken, viperKeyBackendTokenFile, "backend token")))
 }
 func (c serverConfig) BackendLockMethod() string {
 	return cmdViper.GetString(viperKeyBackendLockMethod)
@@ -233,61 +797,426 @@ func (c serverConfig) BackendUnlockMethod() string {
 func (c serverConfig) BackendReadinessProbePath() string {
 	return cmdViper.GetString(viperKeyBackendReadinessProbePath)
 }
+func (c serverConfig) BackendRoutes() []config.Route {
+	var values []routeValue
+	if err := cmdViper.UnmarshalKey(viperKeyBackendRoutes, &values); err != nil {
+		c.logger.Error("error reading backend routes", "key", viperKeyBackendRoutes, "err", err)
+		os.Exit(200)
+	}
+	routes := make([]config.Route, 0, len(values))
+	for _, value := range values {
+		routes = append(routes, config.Route{
+			Name:         value.Name,
+			Host:         value.Host,
+			PathPrefix:   value.PathPrefix,
+			URL:          value.URL,
+			MTLSCert:     c.routeDataOrFile(value.MTLS.Cert, value.MTLS.CertFile, "route mTLS cert"),
+			MTLSKey:      c.routeDataOrFile(value.MTLS.Key, value.MTLS.KeyFile, "route mTLS key"),
+			LockMethod:   value.LockMethod,
+			UnlockMethod: value.UnlockMethod,
+			KeyGroup:     value.KeyGroup,
+		})
+	}
+	return routes
+}
+func (c serverConfig) AuditFile() string {
+	return cmdViper.GetString(viperKeyAuditFile)
+}
+func (c serverConfig) AuditSyslogNetwork() string {
+	return cmdViper.GetString(viperKeyAuditSyslogNetwork)
+}
+func (c serverConfig) AuditSyslogAddress() string {
+	return cmdViper.GetString(viperKeyAuditSyslogAddress)
+}
 func (c serverConfig) Logger() hclog.Logger { return c.logger }
+
+// dataOrFile returns the content of the file configured with fileKey or otherwise the data configured with dataKey
+func (c serverConfig) dataOrFile(dataKey, fileKey, name string) []byte {
+	file := cmdViper.GetString(fileKey)
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			c.logger.Error(fmt.Sprintf("error reading %s file", name), "file", file, "err", err)
+			os.Exit(200)
+		}
+		return data
+	}
+	return []byte(cmdViper.GetString(dataKey))
+}
+
+// routeDataOrFile returns the content of the file of a backend route or otherwise the data of the route
+func (c serverConfig) routeDataOrFile(data, file, name string) []byte {
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			c.logger.Error(fmt.Sprintf("error reading %s file", name), "file", file, "err", err)
+			os.Exit(200)
+		}
+		return content
+	}
+	if data == "" {
+		return nil
+	}
+	return []byte(data)
+}
 func (c serverConfig) String() string {
 	return fmt.Sprintf(
 		`---
 server:
   port: %s
+  shutdown_timeout: %s
+  read_timeout: %s
+  write_timeout: %s
+  max_body_size: %d
+  decrypt_failure_policy: %s
+  migration_mode: %s
+  write_guard: %t
+  tls:
+    cert_file: %s
+    key_file: %s
+    client_ca_file: %s
+    client_subjects: %s
+monitoring:
+  address: %s
+  port: %s
+cache:
+  max_size: %d
+  ttl: %s
+  data_key_reuse_window: %s
+auth:
+  tokens_file: %s
+  htpasswd_file: %s
+  jwt:
+    key_file: %s
+    issuer: %s
+    audience: %s
+    identity_claim: %s
+  policy_file: %s
+  forward_credentials: %t
+audit:
+  file: %s
+  syslog:
+    network: %s
+    address: %s
 backend:
+  type: %s
   url: %s
+  local:
+    directory: %s
+  s3:
+    bucket: %s
+    prefix: %s
+    region: %s
+    endpoint: %s
+    use_path_style: %t
+    access_key_id: %s
+    secret_access_key: %s
+  postgres:
+    dsn: %s
+    table: %s
   mtls:
     cert: %s
     key: %s
+  username: %s
+  password: %s
+  token: %s
   lock_method: %s
   unlock_method: %s
   readiness_probe:
     path: %s
+  routes:%s
 transform:
   age:
-    public_key: %s
+    public_keys: %s
     private_key: %s
   vault:
     address: %s
+    auth:
+      method: %s
+      mount: %s
+      role: %s
+      jwt: %s
+      jwt_file: %s
+      token: %s
     app_role:
       id: %s
       secret_id: %s
+    namespace: %s
+    tls:
+      ca_cert: %s
+      client_cert: %s
+      client_key: %s
+      server_name: %s
     transit:
       mount: %s
-      name: %s`,
+      name: %s
+  aws_kms:
+    endpoint: %s
+  gcp_kms:
+    endpoint: %s
+  shamir_threshold: %d
+  key_groups:%s
+  strategy: %s
+  sensitive:
+    always_encrypt: %s
+  encrypted_regex: %s
+  unencrypted_regex: %s
+  unencrypted_suffix: %s
+  mac_only_encrypted: %t
+  creation_rules:%s`,
 		c.presentedToStringValue(c.ServerPort()),
+		c.presentedToStringValue(c.ServerShutdownTimeout().String()),
+		c.presentedToStringValue(c.ServerReadTimeout().String()),
+		c.presentedToStringValue(c.ServerWriteTimeout().String()),
+		c.ServerMaxBodySize(),
+		c.presentedToStringValue(c.DecryptFailurePolicy()),
+		c.presentedToStringValue(c.MigrationMode()),
+		c.WriteGuard(),
+		c.presentedToStringValue(c.ServerTLSCertFile()),
+		c.presentedToStringValue(c.ServerTLSKeyFile()),
+		c.presentedToStringValue(c.ServerTLSClientCAFile()),
+		c.presentedToStringListValue(c.ServerTLSClientSubjects()),
+		c.presentedToStringValue(c.MonitoringAddress()),
+		c.presentedToStringValue(c.MonitoringPort()),
+		c.CacheMaxSize(),
+		c.presentedToStringValue(c.CacheTTL().String()),
+		c.presentedToStringValue(c.DataKeyReuseWindow().String()),
+		c.presentedToStringValue(c.AuthTokensFile()),
+		c.presentedToStringValue(c.AuthHtpasswdFile()),
+		c.presentedToStringValue(c.AuthJWTKeyFile()),
+		c.presentedToStringValue(c.AuthJWTIssuer()),
+		c.presentedToStringValue(c.AuthJWTAudience()),
+		c.presentedToStringValue(c.AuthJWTIdentityClaim()),
+		c.presentedToStringValue(c.AuthPolicyFile()),
+		c.AuthForwardCredentials(),
+		c.presentedToStringValue(c.AuditFile()),
+		c.presentedToStringValue(c.AuditSyslogNetwork()),
+		c.presentedToStringValue(c.AuditSyslogAddress()),
+		c.presentedToStringValue(c.BackendType()),
 		c.presentedToStringValue(c.BackendURL()),
+		c.presentedToStringValue(c.BackendLocalDirectory()),
+		c.presentedToStringValue(c.BackendS3Bucket()),
+		c.presentedToStringValue(c.BackendS3Prefix()),
+		c.presentedToStringValue(c.BackendS3Region()),
+		c.presentedToStringValue(c.BackendS3Endpoint()),
+		c.BackendS3PathStyle(),
+		c.presentedToStringValue(c.BackendS3AccessKeyID()),
+		c.hiddenToStringValue(c.BackendS3SecretAccessKey()),
+		c.hiddenToStringValue(c.BackendPostgresDSN()),
+		c.presentedToStringValue(c.BackendPostgresTable()),
 		c.hiddenToStringValue(string(c.BackendMTLSCert())),
 		c.hiddenToStringValue(string(c.BackendMTLSKey())),
+		c.presentedToStringValue(c.BackendUsername()),
+		c.hiddenToStringValue(c.BackendPassword()),
+		c.hiddenToStringValue(c.BackendToken()),
 		c.presentedToStringValue(c.BackendLockMethod()),
 		c.presentedToStringValue(c.BackendUnlockMethod()),
 		c.presentedToStringValue(c.BackendReadinessProbePath()),
-		c.presentedToStringValue(c.AgePublicKey()),
+		c.routesToStringValue(c.BackendRoutes()),
+		c.presentedToStringListValue(c.AgePublicKeys()),
 		c.hiddenToStringValue(c.AgePrivateKey()),
 		c.presentedToStringValue(c.VaultAddr()),
+		c.presentedToStringValue(c.VaultAuthMethod()),
+		c.presentedToStringValue(c.VaultAuthMount()),
+		c.presentedToStringValue(c.VaultAuthRole()),
+		c.hiddenToStringValue(c.VaultAuthJWT()),
+		c.presentedToStringValue(c.VaultAuthJWTFile()),
+		c.hiddenToStringValue(c.VaultToken()),
 		c.hiddenToStringValue(c.VaultAppRoleID()),
 		c.hiddenToStringValue(c.VaultAppRoleSecretID()),
+		c.presentedToStringValue(c.VaultNamespace()),
+		c.hiddenToStringValue(string(c.VaultCACert())),
+		c.hiddenToStringValue(string(c.VaultClientCert())),
+		c.hiddenToStringValue(string(c.VaultClientKey())),
+		c.presentedToStringValue(c.VaultTLSServerName()),
 		c.presentedToStringValue(c.VaultKeyMount()),
 		c.presentedToStringValue(c.VaultKeyName()),
+		c.presentedToStringValue(c.AWSKMSEndpoint()),
+		c.presentedToStringValue(c.GCPKMSEndpoint()),
+		c.ShamirThreshold(),
+		c.keyGroupsToStringValue(c.KeyGroups()),
+		c.presentedToStringValue(c.EncryptionStrategy()),
+		c.presentedToStringListValue(c.AlwaysEncryptedKeys()),
+		c.presentedToStringValue(c.EncryptedRegex()),
+		c.presentedToStringValue(c.UnencryptedRegex()),
+		c.presentedToStringValue(c.UnencryptedSuffix()),
+		c.MACOnlyEncrypted(),
+		c.creationRulesToStringValue(c.CreationRules()),
 	)
 }
+func (c serverConfig) keyGroupsToStringValue(keyGroups []config.KeyGroup) string {
+	if len(keyGroups) == 0 {
+		return " []"
+	}
+	var builder strings.Builder
+	for _, keyGroup := range keyGroups {
+		transitKeys := make([]string, 0, len(keyGroup.VaultTransitKeys))
+		for _, transitKey := range keyGroup.VaultTransitKeys {
+			transitKeys = append(transitKeys, fmt.Sprintf("%s/%s", transitKey.Mount, transitKey.Name))
+		}
+		awsKMSKeys := make([]string, 0, len(keyGroup.AWSKMSKeys))
+		for _, awsKMSKey := range keyGroup.AWSKMSKeys {
+			awsKMSKeys = append(awsKMSKeys, awsKMSKey.ARN)
+		}
+		fmt.Fprintf(&builder, `
+    - name: %s
+      age:
+        public_keys: %s
+      vault:
+        transit_keys: %s
+      aws_kms:
+        keys: %s
+      gcp_kms:
+        resource_ids: %s
+      azure_key_vault:
+        key_urls: %s
+      pgp:
+        fingerprints: %s`,
+			c.presentedToStringValue(keyGroup.Name),
+			c.presentedToStringListValue(keyGroup.AgePublicKeys),
+			c.presentedToStringListValue(transitKeys),
+			c.presentedToStringListValue(awsKMSKeys),
+			c.presentedToStringListValue(keyGroup.GCPKMSResourceIDs),
+			c.presentedToStringListValue(keyGroup.AzureKeyVaultKeyURLs),
+			c.presentedToStringListValue(keyGroup.PGPFingerprints),
+		)
+	}
+	return builder.String()
+}
+func (c serverConfig) creationRulesToStringValue(rules []config.CreationRule) string {
+	if len(rules) == 0 {
+		return " []"
+	}
+	var builder strings.Builder
+	for _, rule := range rules {
+		fmt.Fprintf(&builder, `
+    - path_regex: %s
+      age:
+        public_keys: %s
+      vault:
+        transit_key:
+          mount: %s
+          name: %s
+      encrypted_regex: %s`,
+			c.presentedToStringValue(rule.PathRegex),
+			c.presentedToStringListValue(rule.AgePublicKeys),
+			c.presentedToStringValue(rule.VaultTransitKey.Mount),
+			c.presentedToStringValue(rule.VaultTransitKey.Name),
+			c.presentedToStringValue(rule.EncryptedRegex),
+		)
+	}
+	return builder.String()
+}
+func (c serverConfig) routesToStringValue(routes []config.Route) string {
+	if len(routes) == 0 {
+		return " []"
+	}
+	var builder strings.Builder
+	for _, route := range routes {
+		fmt.Fprintf(&builder, `
+    - name: %s
+      host: %s
+      path_prefix: %s
+      url: %s
+      mtls:
+        cert: %s
+        key: %s
+      lock_method: %s
+      unlock_method: %s
+      key_group: %s`,
+			c.presentedToStringValue(route.Name),
+			c.presentedToStringValue(route.Host),
+			c.presentedToStringValue(route.PathPrefix),
+			c.presentedToStringValue(route.URL),
+			c.hiddenToStringValue(string(route.MTLSCert)),
+			c.hiddenToStringValue(string(route.MTLSKey)),
+			c.presentedToStringValue(route.LockMethod),
+			c.presentedToStringValue(route.UnlockMethod),
+			c.presentedToStringValue(route.KeyGroup),
+		)
+	}
+	return builder.String()
+}
 func (c serverConfig) presentedToStringValue(value string) string {
 	if len(value) == 0 {
 		return "\"\""
 	}
 	return fmt.Sprintf("\"%s\"", value)
 }
+func (c serverConfig) presentedToStringListValue(values []string) string {
+	presented := make([]string, 0, len(values))
+	for _, value := range values {
+		presented = append(presented, c.presentedToStringValue(value))
+	}
+	return fmt.Sprintf("[%s]", strings.Join(presented, ", "))
+}
 func (c serverConfig) hiddenToStringValue(value string) string {
 	if len(value) == 0 {
 		return "\"\""
 	}
 	return "\"*****\""
 }
+
+type keyGroupValue struct {
+	Name string `mapstructure:"name"`
+	Age  struct {
+		PublicKeys []string `mapstructure:"public_keys"`
+	} `mapstructure:"age"`
+	Vault struct {
+		TransitKeys []struct {
+			Mount string `mapstructure:"mount"`
+			Name  string `mapstructure:"name"`
+		} `mapstructure:"transit_keys"`
+	} `mapstructure:"vault"`
+	AWSKMS struct {
+		Keys []struct {
+			ARN     string            `mapstructure:"arn"`
+			Role    string            `mapstructure:"role"`
+			Profile string            `mapstructure:"profile"`
+			Context map[string]string `mapstructure:"context"`
+		} `mapstructure:"keys"`
+	} `mapstructure:"aws_kms"`
+	GCPKMS struct {
+		ResourceIDs []string `mapstructure:"resource_ids"`
+	} `mapstructure:"gcp_kms"`
+	AzureKeyVault struct {
+		KeyURLs []string `mapstructure:"key_urls"`
+	} `mapstructure:"azure_key_vault"`
+	PGP struct {
+		Fingerprints []string `mapstructure:"fingerprints"`
+	} `mapstructure:"pgp"`
+}
+
+type creationRuleValue struct {
+	PathRegex string `mapstructure:"path_regex"`
+	Age       struct {
+		PublicKeys []string `mapstructure:"public_keys"`
+	} `mapstructure:"age"`
+	Vault struct {
+		TransitKey struct {
+			Mount string `mapstructure:"mount"`
+			Name  string `mapstructure:"name"`
+		} `mapstructure:"transit_key"`
+	} `mapstructure:"vault"`
+	EncryptedRegex string `mapstructure:"encrypted_regex"`
+}
+
+type routeValue struct {
+	Name       string `mapstructure:"name"`
+	Host       string `mapstructure:"host"`
+	PathPrefix string `mapstructure:"path_prefix"`
+	URL        string `mapstructure:"url"`
+	MTLS       struct {
+		Cert     string `mapstructure:"cert"`
+		CertFile string `mapstructure:"cert_file"`
+		Key      string `mapstructure:"key"`
+		KeyFile  string `mapstructure:"key_file"`
+	} `mapstructure:"mtls"`
+	LockMethod   string `mapstructure:"lock_method"`
+	UnlockMethod string `mapstructure:"unlock_method"`
+	KeyGroup     string `mapstructure:"key_group"`
+}
+
 func newHCLogger(name string) hclog.Logger {
 	logOutput := io.Writer(os.Stderr)
 
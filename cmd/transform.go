@@ -0,0 +1,115 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
+)
+
+const (
+	cobraKeyEncryptPath string = "path"
+	viperKeyEncryptPath string = "encrypt.path"
+)
+
+// decryptCmd represents the decrypt command
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt [file]",
+	Short: "Decrypt a terraform state",
+	Long: `Decrypts a SOPS encrypted terraform state without a running service.
+
+The state is read from the file or from stdin if no file or "-" is given and
+the decrypted state is written to stdout. It uses the same keys as the start
+command.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runTransform(cmd, args, func(config config.TransformConfig, input []byte) ([]byte, error) {
+			var result []byte
+			err := transformer.New().FromSops(config, input, func(output []byte) error { result = output; return nil })
+			return result, err
+		})
+	},
+}
+
+// encryptCmd represents the encrypt command
+var encryptCmd = &cobra.Command{
+	Use:   "encrypt [file]",
+	Short: "Encrypt a terraform state",
+	Long: `Encrypts a terraform state with SOPS without a running service.
+
+The state is read from the file or from stdin if no file or "-" is given and
+the encrypted state is written to stdout. It uses the same keys as the start
+command, the path selects the creation rule like the path of a request.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runTransform(cmd, args, func(config config.TransformConfig, input []byte) ([]byte, error) {
+			var result []byte
+			err := transformer.New().ToSops(config, cmdViper.GetString(viperKeyEncryptPath), input, func(output []byte) { result = output })
+			return result, err
+		})
+	},
+}
+
+func initTransformCmds() {
+	for _, cmd := range []*cobra.Command{decryptCmd, encryptCmd} {
+		rootCmd.AddCommand(cmd)
+		registerTransformParameters(cmd)
+		registerLogParameters(cmd)
+	}
+	registerStringParameter(encryptCmd, cobraKeyEncryptPath, viperKeyEncryptPath, "state path to select the creation rule with", false)
+}
+
+func newTransformConfig(name string) (config.TransformConfig, error) {
+	c := serverConfig{
+		logger: newHCLogger(name),
+	}
+	return c, config.ValidateTransformConfig(c)
+}
+
+func runTransform(cmd *cobra.Command, args []string, transform func(config config.TransformConfig, input []byte) ([]byte, error)) {
+	config, err := newTransformConfig(cmd.Name())
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		_ = cmd.Usage()
+		os.Exit(200)
+	}
+	input, err := readInput(args)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	output, err := transform(config, input)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if _, err := os.Stdout.Write(output); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// readInput reads the file of the first argument or stdin
+func readInput(args []string) ([]byte, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(args[0])
+}
@@ -0,0 +1,128 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+// Record is the audit record of a single request. It never holds content of a terraform state except its serial and
+// lineage, which are not encrypted. The identity is only set, and the record authenticated, if the authorizer verified it.
+type Record struct {
+	Time              time.Time `json:"time"`
+	Method            string    `json:"method"`
+	Path              string    `json:"path"`
+	Route             string    `json:"route,omitempty"`
+	RemoteAddr        string    `json:"remote_addr"`
+	Identity          string    `json:"identity,omitempty"`
+	Authenticated     bool      `json:"authenticated"`
+	ClientCertSubject string    `json:"client_cert_subject,omitempty"`
+	Status            int       `json:"status"`
+	UpstreamStatus    int       `json:"upstream_status,omitempty"`
+	Serial            *uint64   `json:"serial,omitempty"`
+	Lineage           string    `json:"lineage,omitempty"`
+	KeyFingerprints   []string  `json:"key_fingerprints,omitempty"`
+}
+
+// SetState records the serial and lineage of the SOPS encrypted state. Encrypted values are not recorded.
+func (r *Record) SetState(state []byte) {
+	var header struct {
+		Serial  json.RawMessage `json:"serial"`
+		Lineage json.RawMessage `json:"lineage"`
+	}
+	if err := json.Unmarshal(state, &header); err != nil {
+		return
+	}
+	var serial uint64
+	if err := json.Unmarshal(header.Serial, &serial); err == nil {
+		r.Serial = &serial
+	}
+	var lineage string
+	if err := json.Unmarshal(header.Lineage, &lineage); err == nil && !strings.HasPrefix(lineage, "ENC[") {
+		r.Lineage = lineage
+	}
+}
+
+// Auditor writes the audit records to all sinks
+type Auditor interface {
+	// Audit writes the record, failures are logged and counted but do not fail the request
+	Audit(record Record)
+	Close() error
+}
+
+// sink writes a single JSON encoded audit record
+type sink interface {
+	write(record []byte) error
+	close() error
+	name() string
+}
+
+// New creates the Auditor of the configuration. It returns nil if no sink is configured.
+func New(config config.AuditConfig) (Auditor, error) {
+	var sinks []sink
+	if config.AuditFile() != "" {
+		file, err := newFileSink(config.AuditFile())
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, file)
+	}
+	if config.AuditSyslogAddress() != "" {
+		network := config.AuditSyslogNetwork()
+		if network == "" {
+			network = "udp"
+		}
+		sinks = append(sinks, newSyslogSink(network, config.AuditSyslogAddress()))
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return &auditor{sinks: sinks, logger: config.Logger().Named("audit")}, nil
+}
+
+type auditor struct {
+	sinks  []sink
+	logger hclog.Logger
+}
+
+func (a *auditor) Audit(record Record) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		a.logger.Error("Can not encode audit record", "err", err)
+		return
+	}
+	for _, sink := range a.sinks {
+		if err := sink.write(data); err != nil {
+			failureCounter.WithLabelValues(sink.name()).Inc()
+			a.logger.Error("Can not write audit record", "sink", sink.name(), "method", record.Method, "path", record.Path, "err", err)
+			continue
+		}
+		recordCounter.WithLabelValues(sink.name()).Inc()
+	}
+}
+
+func (a *auditor) Close() error {
+	var errs []error
+	for _, sink := range a.sinks {
+		errs = append(errs, sink.close())
+	}
+	return errors.Join(errs...)
+}
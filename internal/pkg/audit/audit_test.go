@@ -0,0 +1,161 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord_SetState(t *testing.T) {
+	tests := []struct {
+		name        string
+		state       string
+		wantSerial  *uint64
+		wantLineage string
+	}{
+		{
+			name:        "serial and lineage",
+			state:       `{"version":4,"serial":7,"lineage":"a1b2","resources":"ENC[AES256_GCM,data:secret]","sops":{}}`,
+			wantSerial:  uint64Ptr(7),
+			wantLineage: "a1b2",
+		},
+		{
+			name:       "encrypted lineage",
+			state:      `{"serial":3,"lineage":"ENC[AES256_GCM,data:abc]"}`,
+			wantSerial: uint64Ptr(3),
+		},
+		{
+			name:        "encrypted serial",
+			state:       `{"serial":"ENC[AES256_GCM,data:abc]","lineage":"a1b2"}`,
+			wantLineage: "a1b2",
+		},
+		{
+			name:  "no JSON",
+			state: "no JSON",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var record Record
+
+			record.SetState([]byte(tt.state))
+
+			assert.Equal(t, tt.wantSerial, record.Serial)
+			assert.Equal(t, tt.wantLineage, record.Lineage)
+		})
+	}
+}
+
+func TestNew_noSink(t *testing.T) {
+	auditor, err := New(testConfig{})
+
+	assert.NoError(t, err)
+	assert.Nil(t, auditor)
+}
+
+func TestNew_invalidFile(t *testing.T) {
+	_, err := New(testConfig{file: filepath.Join(t.TempDir(), "missing", "audit.log")})
+
+	assert.Error(t, err)
+}
+
+func TestAuditor_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditor, err := New(testConfig{file: path})
+	require.NoError(t, err)
+
+	auditor.Audit(Record{Method: "GET", Path: "/states/a", Identity: "alice", Authenticated: true, Status: 200})
+	auditor.Audit(Record{Method: "POST", Path: "/states/a", Status: 403})
+	require.NoError(t, auditor.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 2)
+	var first, second Record
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, "alice", first.Identity)
+	assert.True(t, first.Authenticated)
+	assert.Equal(t, 200, first.Status)
+	assert.Equal(t, "POST", second.Method)
+	assert.False(t, second.Authenticated)
+	assert.Equal(t, 403, second.Status)
+}
+
+func TestAuditor_syslog(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	auditor, err := New(testConfig{syslogAddress: listener.LocalAddr().String()})
+	require.NoError(t, err)
+	defer auditor.Close()
+
+	auditor.Audit(Record{Method: "GET", Path: "/states/a", Status: 200})
+
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buffer := make([]byte, 4096)
+	n, _, err := listener.ReadFrom(buffer)
+	require.NoError(t, err)
+	message := string(buffer[:n])
+	assert.Regexp(t, regexp.MustCompile(`^<134>1 \S+ \S+ terraform-sops-backend \d+ audit - \{`), message)
+	var record Record
+	require.NoError(t, json.Unmarshal([]byte(message[strings.Index(message, "{"):]), &record))
+	assert.Equal(t, "/states/a", record.Path)
+}
+
+func TestSyslogSink_message(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tcp := &syslogSink{network: "tcp", hostname: "host"}
+	udp := &syslogSink{network: "udp", hostname: "host"}
+
+	tcpMessage := string(tcp.message([]byte("{}"), now))
+	udpMessage := string(udp.message([]byte("{}"), now))
+
+	assert.Regexp(t, `^<134>1 2026-01-02T03:04:05Z host terraform-sops-backend \d+ audit - \{\}$`, udpMessage)
+	assert.Equal(t, len(udpMessage), len(tcpMessage)-len(strings.SplitN(tcpMessage, " ", 2)[0])-1)
+	assert.True(t, strings.HasSuffix(tcpMessage, " "+udpMessage))
+}
+
+func uint64Ptr(value uint64) *uint64 {
+	return &value
+}
+
+type testConfig struct {
+	file          string
+	syslogNetwork string
+	syslogAddress string
+}
+
+func (c testConfig) AuditFile() string          { return c.file }
+func (c testConfig) AuditSyslogNetwork() string { return c.syslogNetwork }
+func (c testConfig) AuditSyslogAddress() string { return c.syslogAddress }
+func (c testConfig) Logger() hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{Name: "unit-test", Level: hclog.Trace, Output: os.Stderr})
+}
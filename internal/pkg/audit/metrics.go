@@ -0,0 +1,37 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	recordCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_audit_record_counter",
+			Help: "Counter Vector of audit records written by sink",
+		},
+		[]string{"sink"},
+	)
+	failureCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_audit_failure_counter",
+			Help: "Counter Vector of audit records which could not be written by sink",
+		},
+		[]string{"sink"},
+	)
+)
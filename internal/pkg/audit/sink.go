@@ -0,0 +1,135 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// syslogPriority is the facility local0 with the severity info
+	syslogPriority = 16*8 + 6
+	syslogAppName  = "terraform-sops-backend"
+	syslogMsgID    = "audit"
+	syslogTimeout  = 5 * time.Second
+)
+
+// fileSink appends one record per line to a file
+type fileSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	file, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("can not open audit file: %w", err)
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (s *fileSink) write(record []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err := s.file.Write(append(append([]byte{}, record...), '\n'))
+	return err
+}
+
+func (s *fileSink) close() error {
+	return s.file.Close()
+}
+
+func (s *fileSink) name() string {
+	return "file"
+}
+
+// syslogSink sends every record as RFC 5424 message to a syslog server. Stream connections frame the messages by
+// octet counting (RFC 6587). The connection is established on the first record and again after a failure.
+type syslogSink struct {
+	mutex    sync.Mutex
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn
+}
+
+func newSyslogSink(network, address string) *syslogSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{network: network, address: address, hostname: hostname}
+}
+
+func (s *syslogSink) write(record []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	message := s.message(record, time.Now())
+	if err := s.send(message); err != nil {
+		// the server may have closed the connection, so the message is sent once more with a new connection
+		s.reset()
+		if err := s.send(message); err != nil {
+			s.reset()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogSink) message(record []byte, now time.Time) []byte {
+	message := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", syslogPriority, now.UTC().Format(time.RFC3339Nano), s.hostname, syslogAppName, os.Getpid(), syslogMsgID, record)
+	if s.network == "tcp" || s.network == "unix" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	return []byte(message)
+}
+
+func (s *syslogSink) send(message []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, syslogTimeout)
+		if err != nil {
+			return fmt.Errorf("can not connect to syslog server: %w", err)
+		}
+		s.conn = conn
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout)); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(message)
+	return err
+}
+
+func (s *syslogSink) reset() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *syslogSink) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reset()
+	return nil
+}
+
+func (s *syslogSink) name() string {
+	return "syslog"
+}
@@ -0,0 +1,145 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+// Permission to access a terraform state
+type Permission string
+
+const (
+	// PermissionRead allows to get the decrypted state
+	PermissionRead Permission = "read"
+	// PermissionWrite allows to post the state
+	PermissionWrite Permission = "write"
+	// PermissionLock allows to lock and unlock the state
+	PermissionLock Permission = "lock"
+)
+
+var (
+	// ErrUnauthenticated the request has no valid credentials
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden the identity of the request has not the permission for the path
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Authorizer authenticates requests and checks the permissions of their identity
+type Authorizer interface {
+	// Authorize returns the identity of the request or ErrUnauthenticated or ErrForbidden
+	Authorize(req *http.Request, permission Permission) (string, error)
+	// Challenge returns the WWW-Authenticate header value for unauthenticated requests
+	Challenge() string
+}
+
+// authenticator returns the identity of the credentials or ok false if the credentials are not valid
+type authenticator interface {
+	authenticate(username string, password string, bearer string) (identity string, ok bool)
+}
+
+// New creates the Authorizer of the configuration. It returns nil if no authentication method is configured.
+func New(config config.AuthConfig) (Authorizer, error) {
+	var (
+		authenticators []authenticator
+		basic          bool
+	)
+	if config.AuthTokensFile() != "" {
+		tokens, err := newTokenAuthenticator(config.AuthTokensFile())
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	if config.AuthHtpasswdFile() != "" {
+		htpasswd, err := newHtpasswdAuthenticator(config.AuthHtpasswdFile())
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, htpasswd)
+		basic = true
+	}
+	if config.AuthJWTKeyFile() != "" {
+		jwt, err := newJWTAuthenticator(config)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwt)
+	}
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	a := &authorizer{
+		authenticators: authenticators,
+		challenge:      `Bearer realm="terraform-sops-backend"`,
+	}
+	if basic {
+		a.challenge = `Basic realm="terraform-sops-backend"`
+	}
+	if config.AuthPolicyFile() != "" {
+		policy, err := loadPolicy(config.AuthPolicyFile())
+		if err != nil {
+			return nil, err
+		}
+		a.policy = policy
+	}
+	return a, nil
+}
+
+type authorizer struct {
+	authenticators []authenticator
+	policy         *policy
+	challenge      string
+}
+
+func (a *authorizer) Authorize(req *http.Request, permission Permission) (string, error) {
+	username, password, bearer := credentials(req)
+	if username == "" && password == "" && bearer == "" {
+		return "", ErrUnauthenticated
+	}
+	for _, authenticator := range a.authenticators {
+		identity, ok := authenticator.authenticate(username, password, bearer)
+		if !ok {
+			continue
+		}
+		if a.policy != nil && !a.policy.allows(identity, req.URL.Path, permission) {
+			return identity, fmt.Errorf("%w: %s has no %s permission for %s", ErrForbidden, identity, permission, req.URL.Path)
+		}
+		return identity, nil
+	}
+	return "", ErrUnauthenticated
+}
+
+func (a *authorizer) Challenge() string {
+	return a.challenge
+}
+
+// credentials returns the basic credentials or the bearer token of the Authorization header. The terraform HTTP
+// backend only sends basic credentials, so a password without a matching htpasswd entry is checked as token, too.
+func credentials(req *http.Request) (username string, password string, bearer string) {
+	if username, password, ok := req.BasicAuth(); ok {
+		return username, password, password
+	}
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return "", "", strings.TrimSpace(token)
+	}
+	return "", "", ""
+}
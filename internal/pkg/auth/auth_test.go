@@ -0,0 +1,299 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPolicy = `
+rules:
+  - identities: ["ci"]
+    paths: ["/states/team-a/**"]
+    permissions: ["read", "write", "lock"]
+  - identities: ["*"]
+    paths: ["/states/*/shared"]
+    permissions: ["read"]
+`
+
+func TestAuthorizer_Authorize(t *testing.T) {
+	directory := t.TempDir()
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("alice-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	shaHash := sha1.Sum([]byte("bob-password"))
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	config := testConfig{
+		tokensFile:   writeTestFile(t, directory, "tokens", "# static tokens\nci:ci-token\n"),
+		htpasswdFile: writeTestFile(t, directory, "htpasswd", "alice:"+string(bcryptHash)+"\nbob:{SHA}"+base64.StdEncoding.EncodeToString(shaHash[:])+"\n"),
+		jwtKeyFile:   writeTestFile(t, directory, "jwt.pem", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))),
+		jwtIssuer:    "https://issuer.test",
+		jwtAudience:  "terraform-sops-backend",
+		policyFile:   writeTestFile(t, directory, "policy.yaml", testPolicy),
+	}
+	authorizer, err := New(config)
+	require.NoError(t, err)
+	require.NotNil(t, authorizer)
+	assert.Equal(t, `Basic realm="terraform-sops-backend"`, authorizer.Challenge())
+
+	validJWT := newTestJWT(t, key, jwt.MapClaims{"sub": "ci", "iss": "https://issuer.test", "aud": "terraform-sops-backend", "exp": time.Now().Add(time.Hour).Unix()})
+	tests := []struct {
+		name         string
+		path         string
+		permission   Permission
+		setAuth      func(req *http.Request)
+		wantIdentity string
+		wantErr      error
+	}{
+		{
+			name:    "no credentials",
+			path:    "/states/team-a/app",
+			setAuth: func(req *http.Request) {},
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:         "static bearer token",
+			path:         "/states/team-a/app",
+			permission:   PermissionWrite,
+			setAuth:      func(req *http.Request) { req.Header.Set("Authorization", "Bearer ci-token") },
+			wantIdentity: "ci",
+		},
+		{
+			name:         "static token as basic password",
+			path:         "/states/team-a/app",
+			permission:   PermissionLock,
+			setAuth:      func(req *http.Request) { req.SetBasicAuth("token", "ci-token") },
+			wantIdentity: "ci",
+		},
+		{
+			name:    "unknown bearer token",
+			path:    "/states/team-a/app",
+			setAuth: func(req *http.Request) { req.Header.Set("Authorization", "Bearer unknown") },
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:         "htpasswd bcrypt",
+			path:         "/states/team-b/shared",
+			permission:   PermissionRead,
+			setAuth:      func(req *http.Request) { req.SetBasicAuth("alice", "alice-password") },
+			wantIdentity: "alice",
+		},
+		{
+			name:         "htpasswd SHA-1",
+			path:         "/states/team-b/shared",
+			permission:   PermissionRead,
+			setAuth:      func(req *http.Request) { req.SetBasicAuth("bob", "bob-password") },
+			wantIdentity: "bob",
+		},
+		{
+			name:    "htpasswd wrong password",
+			path:    "/states/team-b/shared",
+			setAuth: func(req *http.Request) { req.SetBasicAuth("alice", "wrong") },
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:         "policy denies write",
+			path:         "/states/team-b/shared",
+			permission:   PermissionWrite,
+			setAuth:      func(req *http.Request) { req.SetBasicAuth("alice", "alice-password") },
+			wantIdentity: "alice",
+			wantErr:      ErrForbidden,
+		},
+		{
+			name:         "policy denies path",
+			path:         "/states/team-a/app",
+			permission:   PermissionRead,
+			setAuth:      func(req *http.Request) { req.SetBasicAuth("alice", "alice-password") },
+			wantIdentity: "alice",
+			wantErr:      ErrForbidden,
+		},
+		{
+			name:         "JWT",
+			path:         "/states/team-a/nested/app",
+			permission:   PermissionRead,
+			setAuth:      func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+validJWT) },
+			wantIdentity: "ci",
+		},
+		{
+			name:       "JWT with wrong signature",
+			path:       "/states/team-a/app",
+			permission: PermissionRead,
+			setAuth: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+newTestJWT(t, otherKey, jwt.MapClaims{"sub": "ci", "iss": "https://issuer.test", "aud": "terraform-sops-backend", "exp": time.Now().Add(time.Hour).Unix()}))
+			},
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:       "expired JWT",
+			path:       "/states/team-a/app",
+			permission: PermissionRead,
+			setAuth: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+newTestJWT(t, key, jwt.MapClaims{"sub": "ci", "iss": "https://issuer.test", "aud": "terraform-sops-backend", "exp": time.Now().Add(-time.Hour).Unix()}))
+			},
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:       "JWT with wrong audience",
+			path:       "/states/team-a/app",
+			permission: PermissionRead,
+			setAuth: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+newTestJWT(t, key, jwt.MapClaims{"sub": "ci", "iss": "https://issuer.test", "aud": "other", "exp": time.Now().Add(time.Hour).Unix()}))
+			},
+			wantErr: ErrUnauthenticated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			tt.setAuth(req)
+
+			identity, err := authorizer.Authorize(req, tt.permission)
+
+			assert.Equal(t, tt.wantIdentity, identity)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	directory := t.TempDir()
+	tests := []struct {
+		name    string
+		config  testConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{
+			name:    "disabled",
+			wantNil: true,
+		},
+		{
+			name:   "tokens without policy",
+			config: testConfig{tokensFile: writeTestFile(t, directory, "tokens", "ci:ci-token\n")},
+		},
+		{
+			name:    "missing tokens file",
+			config:  testConfig{tokensFile: filepath.Join(directory, "missing")},
+			wantErr: true,
+		},
+		{
+			name:    "invalid tokens line",
+			config:  testConfig{tokensFile: writeTestFile(t, directory, "invalid-tokens", "ci-token\n")},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported htpasswd hash",
+			config:  testConfig{htpasswdFile: writeTestFile(t, directory, "htpasswd", "alice:$apr1$salt$hash\n")},
+			wantErr: true,
+		},
+		{
+			name:    "no public key",
+			config:  testConfig{jwtKeyFile: writeTestFile(t, directory, "jwt.pem", "no key")},
+			wantErr: true,
+		},
+		{
+			name: "unknown permission",
+			config: testConfig{
+				tokensFile: writeTestFile(t, directory, "tokens", "ci:ci-token\n"),
+				policyFile: writeTestFile(t, directory, "unknown-permission.yaml", "rules:\n  - identities: [ci]\n    paths: [/**]\n    permissions: [delete]\n"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorizer, err := New(tt.config)
+			assert.Equal(t, tt.wantErr, err != nil, "error %v", err)
+			assert.Equal(t, tt.wantNil || tt.wantErr, authorizer == nil)
+		})
+	}
+}
+
+func Test_matchPath(t *testing.T) {
+	tests := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"/states/app", "/states/app", true},
+		{"/states/*", "/states/app", true},
+		{"/states/*", "/states/team/app", false},
+		{"/states/**", "/states/team/app", true},
+		{"/states/**", "/states", true},
+		{"/states/**", "/statesx/app", false},
+		{"/states/*/shared/**", "/states/team/shared/app", true},
+		{"/states/*/shared/**", "/states/team/private/app", false},
+		{"/**", "/states/app", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchPath(tt.glob, tt.path), "%s %s", tt.glob, tt.path)
+	}
+}
+
+func newTestJWT(t *testing.T, key *ecdsa.PrivateKey, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+	require.NoError(t, err)
+	return token
+}
+
+func writeTestFile(t *testing.T, directory string, name string, data string) string {
+	file := filepath.Join(directory, name)
+	require.NoError(t, os.WriteFile(file, []byte(data), 0600))
+	return file
+}
+
+type testConfig struct {
+	tokensFile       string
+	htpasswdFile     string
+	jwtKeyFile       string
+	jwtIssuer        string
+	jwtAudience      string
+	jwtIdentityClaim string
+	policyFile       string
+}
+
+func (c testConfig) AuthTokensFile() string       { return c.tokensFile }
+func (c testConfig) AuthHtpasswdFile() string     { return c.htpasswdFile }
+func (c testConfig) AuthJWTKeyFile() string       { return c.jwtKeyFile }
+func (c testConfig) AuthJWTIssuer() string        { return c.jwtIssuer }
+func (c testConfig) AuthJWTAudience() string      { return c.jwtAudience }
+func (c testConfig) AuthJWTIdentityClaim() string { return c.jwtIdentityClaim }
+func (c testConfig) AuthPolicyFile() string       { return c.policyFile }
+func (c testConfig) AuthForwardCredentials() bool { return false }
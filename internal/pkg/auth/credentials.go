@@ -0,0 +1,107 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// tokenAuthenticator checks static bearer tokens. Each line of the tokens file is "<identity>:<token>".
+type tokenAuthenticator struct {
+	// tokens maps the SHA-256 hash of the token to the identity
+	tokens map[[sha256.Size]byte]string
+}
+
+func newTokenAuthenticator(file string) (*tokenAuthenticator, error) {
+	entries, err := readColonFile(file, "auth tokens")
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[[sha256.Size]byte]string, len(entries))
+	for identity, token := range entries {
+		tokens[sha256.Sum256([]byte(token))] = identity
+	}
+	return &tokenAuthenticator{tokens: tokens}, nil
+}
+
+func (a *tokenAuthenticator) authenticate(_ string, _ string, bearer string) (string, bool) {
+	if bearer == "" {
+		return "", false
+	}
+	identity, ok := a.tokens[sha256.Sum256([]byte(bearer))]
+	return identity, ok
+}
+
+// htpasswdAuthenticator checks basic credentials against a htpasswd file with bcrypt or SHA-1 hashes
+type htpasswdAuthenticator struct {
+	hashes map[string]string
+}
+
+func newHtpasswdAuthenticator(file string) (*htpasswdAuthenticator, error) {
+	hashes, err := readColonFile(file, "auth htpasswd")
+	if err != nil {
+		return nil, err
+	}
+	for username, hash := range hashes {
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("auth htpasswd file %s: unsupported hash of user %s, use bcrypt (htpasswd -B)", file, username)
+		}
+	}
+	return &htpasswdAuthenticator{hashes: hashes}, nil
+}
+
+func (a *htpasswdAuthenticator) authenticate(username string, password string, _ string) (string, bool) {
+	hash, ok := a.hashes[username]
+	if !ok || username == "" {
+		return "", false
+	}
+	if sha, found := strings.CutPrefix(hash, "{SHA}"); found {
+		sum := sha1.Sum([]byte(password))
+		return username, subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(sha)) == 1
+	}
+	return username, bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// readColonFile reads the "<key>:<value>" lines of the file. Empty lines and lines starting with # are ignored.
+func readColonFile(file string, name string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("can not read %s file: %w", name, err)
+	}
+	entries := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("%s file %s: line %d is not \"<name>:<value>\"", name, file, line)
+		}
+		entries[key] = value
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,126 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+const (
+	defaultJWTIdentityClaim = "sub"
+)
+
+// jwtAuthenticator verifies the signature of JWTs with the public keys of the key file
+type jwtAuthenticator struct {
+	keys          jwt.VerificationKeySet
+	parser        *jwt.Parser
+	identityClaim string
+}
+
+func newJWTAuthenticator(config config.AuthConfig) (*jwtAuthenticator, error) {
+	data, err := os.ReadFile(config.AuthJWTKeyFile())
+	if err != nil {
+		return nil, fmt.Errorf("can not read auth JWT key file: %w", err)
+	}
+	keys, methods, err := parsePublicKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("auth JWT key file %s: %w", config.AuthJWTKeyFile(), err)
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+	}
+	if config.AuthJWTIssuer() != "" {
+		options = append(options, jwt.WithIssuer(config.AuthJWTIssuer()))
+	}
+	if config.AuthJWTAudience() != "" {
+		options = append(options, jwt.WithAudience(config.AuthJWTAudience()))
+	}
+	identityClaim := config.AuthJWTIdentityClaim()
+	if identityClaim == "" {
+		identityClaim = defaultJWTIdentityClaim
+	}
+	return &jwtAuthenticator{
+		keys:          keys,
+		parser:        jwt.NewParser(options...),
+		identityClaim: identityClaim,
+	}, nil
+}
+
+func (a *jwtAuthenticator) authenticate(_ string, _ string, bearer string) (string, bool) {
+	if bearer == "" {
+		return "", false
+	}
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(bearer, claims, func(*jwt.Token) (any, error) { return a.keys, nil }); err != nil {
+		return "", false
+	}
+	identity, ok := claims[a.identityClaim].(string)
+	return identity, ok && identity != ""
+}
+
+// parsePublicKeys returns the PEM encoded public keys or certificates and the signing methods they can verify
+func parsePublicKeys(data []byte) (jwt.VerificationKeySet, []string, error) {
+	var (
+		keys    jwt.VerificationKeySet
+		methods []string
+	)
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		var (
+			key any
+			err error
+		)
+		switch block.Type {
+		case "CERTIFICATE":
+			var certificate *x509.Certificate
+			if certificate, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = certificate.PublicKey
+			}
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			return keys, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+		}
+		if err != nil {
+			return keys, nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey:
+			methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512")
+		case *ecdsa.PublicKey:
+			methods = append(methods, "ES256", "ES384", "ES512")
+		case ed25519.PublicKey:
+			methods = append(methods, "EdDSA")
+		default:
+			return keys, nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+		keys.Keys = append(keys.Keys, key)
+	}
+	if len(keys.Keys) == 0 {
+		return keys, nil, fmt.Errorf("no PEM encoded public key")
+	}
+	return keys, methods, nil
+}
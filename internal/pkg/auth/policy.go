@@ -0,0 +1,108 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	anyIdentity = "*"
+)
+
+// policy grants permissions on state paths to identities. Everything not granted by a rule is denied.
+type policy struct {
+	Rules []rule `yaml:"rules"`
+}
+
+// rule grants the permissions on the paths to the identities
+type rule struct {
+	// Identities are the names of the identities or "*" for every authenticated identity
+	Identities []string `yaml:"identities"`
+	// Paths are globs of the state paths, "*" matches within a path segment and a trailing "/**" matches all paths
+	// below
+	Paths []string `yaml:"paths"`
+	// Permissions are read, write and lock
+	Permissions []Permission `yaml:"permissions"`
+}
+
+func loadPolicy(file string) (*policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("can not read auth policy file: %w", err)
+	}
+	var p policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("can not parse auth policy file %s: %w", file, err)
+	}
+	for i, rule := range p.Rules {
+		if len(rule.Identities) == 0 || len(rule.Paths) == 0 || len(rule.Permissions) == 0 {
+			return nil, fmt.Errorf("auth policy file %s: rule %d requires identities, paths and permissions", file, i+1)
+		}
+		for _, permission := range rule.Permissions {
+			if permission != PermissionRead && permission != PermissionWrite && permission != PermissionLock {
+				return nil, fmt.Errorf("auth policy file %s: rule %d has unknown permission %q, expected one of [%s, %s, %s]", file, i+1, permission, PermissionRead, PermissionWrite, PermissionLock)
+			}
+		}
+		for _, glob := range rule.Paths {
+			if _, err := path.Match(strings.TrimSuffix(glob, "/**"), "/"); err != nil {
+				return nil, fmt.Errorf("auth policy file %s: rule %d has invalid path %q: %w", file, i+1, glob, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+func (p *policy) allows(identity string, statePath string, permission Permission) bool {
+	statePath = path.Clean("/" + statePath)
+	for _, rule := range p.Rules {
+		if !slices.Contains(rule.Permissions, permission) {
+			continue
+		}
+		if !slices.Contains(rule.Identities, identity) && !slices.Contains(rule.Identities, anyIdentity) {
+			continue
+		}
+		for _, glob := range rule.Paths {
+			if matchPath(glob, statePath) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPath matches the path with the glob. A trailing "/**" of the glob matches the prefix and all paths below.
+func matchPath(glob string, statePath string) bool {
+	if prefix, ok := strings.CutSuffix(glob, "/**"); ok {
+		if matched, _ := path.Match(prefix, statePath); matched {
+			return true
+		}
+		segments := strings.Count(prefix, "/")
+		parts := strings.SplitAfterN(statePath, "/", segments+2)
+		if len(parts) <= segments+1 {
+			return false
+		}
+		matched, _ := path.Match(prefix, strings.TrimSuffix(strings.Join(parts[:segments+1], ""), "/"))
+		return matched
+	}
+	matched, _ := path.Match(glob, statePath)
+	return matched
+}
@@ -16,6 +16,7 @@ package backend
 
 import (
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"time"
@@ -24,6 +25,7 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/storage"
 )
 
 // Client is sending requests
@@ -31,8 +33,23 @@ type Client interface {
 	Send(req *retryablehttp.Request) (*http.Response, error)
 }
 
-// New creates a Client
-func New(config config.ServerConfig) (client Client, err error) {
+// New creates a Client for the configured backend type
+func New(serverConfig config.ServerConfig) (client Client, err error) {
+	switch serverConfig.BackendType() {
+	case "", config.BackendTypeHTTP:
+		return newHTTPClient(serverConfig)
+	default:
+		stateStorage, err := storage.New(serverConfig)
+		if err != nil {
+			serverConfig.Logger().Named("backend").Error("error creating state storage", "type", serverConfig.BackendType(), "err", err)
+			return nil, err
+		}
+		return NewStorageClient(serverConfig, stateStorage), nil
+	}
+}
+
+// newHTTPClient creates a Client forwarding the requests to a terraform HTTP backend
+func newHTTPClient(config config.ServerConfig) (client Client, err error) {
 
 	var (
 		logger = config.Logger().Named("backend")
@@ -60,18 +77,61 @@ func New(config config.ServerConfig) (client Client, err error) {
 	httpClient.RetryWaitMax = time.Duration(60) * time.Second
 	httpClient.Logger = logger
 	return retryableHTTPClient{
-		client: httpClient,
+		client:        httpClient,
+		authorization: authorization(config),
 	}, nil
 }
 
+// authorization returns the Authorization header of the configured backend credentials or "" without credentials
+func authorization(config config.ServerConfig) string {
+	switch {
+	case config.BackendToken() != "":
+		return "Bearer " + config.BackendToken()
+	case config.BackendUsername() != "":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(config.BackendUsername()+":"+config.BackendPassword()))
+	default:
+		return ""
+	}
+}
+
+// retryableHTTPClient sends the requests to the terraform HTTP backend. The configured backend credentials replace
+// the credentials of a request.
 type retryableHTTPClient struct {
-	client *retryablehttp.Client
+	client        *retryablehttp.Client
+	authorization string
 }
 
 func (c retryableHTTPClient) Send(req *retryablehttp.Request) (*http.Response, error) {
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
 	timer := prometheus.NewTimer(requestDuration.WithLabelValues(req.Method, req.URL.Path))
 	defer timer.ObserveDuration()
 	resp, err := c.client.Do(req)
+	if err != nil {
+		return resp, err
+	}
 	responseStatusCounter.WithLabelValues(fmt.Sprintf("%vxx", resp.StatusCode/100), req.URL.Path).Inc()
 	return resp, err
 }
+
+// WithAuthorization returns a Client, which sends the Authorization header with every request without credentials,
+// e.g. to act on behalf of the client of an incoming request. Without Authorization header the client is returned.
+func WithAuthorization(client Client, authorization string) Client {
+	if authorization == "" {
+		return client
+	}
+	return authorizingClient{client: client, authorization: authorization}
+}
+
+type authorizingClient struct {
+	client        Client
+	authorization string
+}
+
+func (c authorizingClient) Send(req *retryablehttp.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return c.client.Send(req)
+}
@@ -0,0 +1,178 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+// ErrStateLocked is returned if the state is locked by someone else
+var ErrStateLocked = errors.New("state is locked")
+
+// LockInfo is the lock information of the terraform HTTP backend protocol
+type LockInfo struct {
+	ID        string
+	Operation string
+	Info      string
+	Who       string
+	Version   string
+	Created   time.Time
+	Path      string
+}
+
+// StateClient reads, writes and locks the terraform states of the backend with the terraform HTTP backend protocol
+// on behalf of the service itself
+type StateClient struct {
+	config    config.ServerConfig
+	client    Client
+	operation string
+	logger    hclog.Logger
+}
+
+// NewStateClient creates a StateClient, which locks the states for the operation
+func NewStateClient(config config.ServerConfig, client Client, operation string) StateClient {
+	return StateClient{
+		config:    config,
+		client:    client,
+		operation: operation,
+		logger:    config.Logger().Named(operation),
+	}
+}
+
+// Lock locks the state of the path
+func (c StateClient) Lock(path string) (*LockInfo, error) {
+	lock := &LockInfo{
+		ID:        uuid.NewString(),
+		Operation: c.operation,
+		Who:       who(),
+		Created:   time.Now().UTC(),
+		Path:      path,
+	}
+	body, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.send(c.config.BackendLockMethod(), path, body, nil); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Unlock releases the lock of the state of the path
+func (c StateClient) Unlock(path string, lock *LockInfo) error {
+	body, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return c.send(c.config.BackendUnlockMethod(), path, body, nil)
+}
+
+// Get returns the state of the path or nil if the backend has no state
+func (c StateClient) Get(path string) ([]byte, error) {
+	request, err := retryablehttp.NewRequest(http.MethodGet, c.backendURL(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.client.Send(request)
+	if err != nil {
+		return nil, fmt.Errorf("can not get state: %w", err)
+	}
+	body, err := readBody(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can not read state: %w", err)
+	}
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("can not get state, backend responded with status %d", response.StatusCode)
+	}
+	return body, nil
+}
+
+// Post writes the state of the path, which has to be locked with the lock
+func (c StateClient) Post(path string, lock *LockInfo, state []byte) error {
+	query := url.Values{}
+	query.Set("ID", lock.ID)
+	if err := c.send(http.MethodPost, path, state, query); err != nil {
+		return fmt.Errorf("can not write state: %w", err)
+	}
+	return nil
+}
+
+// send sends the body to the backend path and fails on all but successful responses
+func (c StateClient) send(method, path string, body []byte, query url.Values) error {
+	request, err := retryablehttp.NewRequest(method, c.backendURL(path), body)
+	if err != nil {
+		return err
+	}
+	request.URL.RawQuery = query.Encode()
+	if c.logger.IsDebug() {
+		c.logger.Debug("backend request", "method", method, "path", path)
+	}
+	response, err := c.client.Send(request)
+	if err != nil {
+		return err
+	}
+	if _, err := readBody(response.Body); err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusLocked || response.StatusCode == http.StatusConflict {
+		return fmt.Errorf("backend responded to %s with status %d: %w", method, response.StatusCode, ErrStateLocked)
+	}
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("backend responded to %s with status %d", method, response.StatusCode)
+	}
+	return nil
+}
+
+func (c StateClient) backendURL(path string) string {
+	return fmt.Sprintf("%s%s", c.config.BackendURL(), path)
+}
+
+func who() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s@%s (terraform-sops-backend)", name, host)
+}
+
+func readBody(body io.ReadCloser) ([]byte, error) {
+	defer body.Close()
+	buffer := bytes.NewBuffer(nil)
+	if _, err := io.Copy(buffer, body); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/storage"
+)
+
+// storageClient answers the requests of the terraform HTTP backend protocol from a storage
+type storageClient struct {
+	storage      storage.Storage
+	lockMethod   string
+	unlockMethod string
+	probePath    string
+	paths        *pathMutex
+	logger       hclog.Logger
+}
+
+// pathMutex serializes the requests changing the state or the lock of a path, otherwise a state could be written after
+// another request locked the path between checking the lock and writing the state. It serializes only the requests of
+// this process.
+type pathMutex struct {
+	mutex sync.Mutex
+	paths map[string]*pathMutexEntry
+}
+
+type pathMutexEntry struct {
+	mutex sync.Mutex
+	users int
+}
+
+// lock locks the path and returns the function to unlock it. Entries are removed once no request uses the path.
+func (m *pathMutex) lock(path string) func() {
+	m.mutex.Lock()
+	entry, ok := m.paths[path]
+	if !ok {
+		entry = &pathMutexEntry{}
+		m.paths[path] = entry
+	}
+	entry.users++
+	m.mutex.Unlock()
+
+	entry.mutex.Lock()
+	return func() {
+		entry.mutex.Unlock()
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		entry.users--
+		if entry.users == 0 {
+			delete(m.paths, path)
+		}
+	}
+}
+
+// NewStorageClient creates a Client, which serves the terraform states from the storage
+func NewStorageClient(config config.ServerConfig, storage storage.Storage) Client {
+	return storageClient{
+		storage:      storage,
+		lockMethod:   config.BackendLockMethod(),
+		unlockMethod: config.BackendUnlockMethod(),
+		probePath:    config.BackendReadinessProbePath(),
+		paths:        &pathMutex{paths: map[string]*pathMutexEntry{}},
+		logger:       config.Logger().Named("storage"),
+	}
+}
+
+func (c storageClient) Send(req *retryablehttp.Request) (*http.Response, error) {
+	timer := prometheus.NewTimer(requestDuration.WithLabelValues(req.Method, req.URL.Path))
+	defer timer.ObserveDuration()
+	resp, err := c.handle(req)
+	if err != nil {
+		return nil, err
+	}
+	responseStatusCounter.WithLabelValues(fmt.Sprintf("%vxx", resp.StatusCode/100), req.URL.Path).Inc()
+	return resp, nil
+}
+
+func (c storageClient) handle(req *retryablehttp.Request) (*http.Response, error) {
+	ctx := req.Context()
+	path := req.URL.Path
+	body, err := req.BodyBytes()
+	if err != nil {
+		return nil, err
+	}
+	if req.Method != http.MethodGet {
+		defer c.paths.lock(path)()
+	}
+	switch req.Method {
+	case http.MethodGet:
+		if path == c.probePath {
+			if err := c.storage.Ping(ctx); err != nil {
+				return nil, err
+			}
+			return response(http.StatusOK, nil), nil
+		}
+		state, err := c.storage.Get(ctx, path)
+		if errors.Is(err, storage.ErrNotFound) {
+			return response(http.StatusNotFound, nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return response(http.StatusOK, state), nil
+	case http.MethodPost:
+		if resp, err := c.checkLock(ctx, path, req.URL.Query().Get("ID")); resp != nil || err != nil {
+			return resp, err
+		}
+		if err := c.storage.Put(ctx, path, body); err != nil {
+			return nil, err
+		}
+		return response(http.StatusOK, nil), nil
+	case http.MethodDelete:
+		if resp, err := c.checkLock(ctx, path, req.URL.Query().Get("ID")); resp != nil || err != nil {
+			return resp, err
+		}
+		if err := c.storage.Delete(ctx, path); err != nil {
+			return nil, err
+		}
+		return response(http.StatusOK, nil), nil
+	case c.lockMethod:
+		lock, err := storage.NewLock(body)
+		if err != nil {
+			return response(http.StatusBadRequest, []byte(err.Error())), nil
+		}
+		current, err := c.storage.Lock(ctx, path, lock)
+		if errors.Is(err, storage.ErrLocked) {
+			c.logger.Debug("State is already locked", "path", path, "lock-id", current.ID)
+			return response(http.StatusLocked, current.Info), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return response(http.StatusOK, nil), nil
+	case c.unlockMethod:
+		var id string
+		if len(body) > 0 {
+			lock, err := storage.NewLock(body)
+			if err != nil {
+				return response(http.StatusBadRequest, []byte(err.Error())), nil
+			}
+			id = lock.ID
+		} else {
+			// terraform force-unlock sends no lock information, release the current lock
+			current, err := c.storage.CurrentLock(ctx, path)
+			if err != nil || current == nil {
+				return response(http.StatusOK, nil), err
+			}
+			id = current.ID
+		}
+		current, err := c.storage.Unlock(ctx, path, id)
+		if errors.Is(err, storage.ErrLocked) {
+			return response(http.StatusLocked, current.Info), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return response(http.StatusOK, nil), nil
+	default:
+		return response(http.StatusMethodNotAllowed, nil), nil
+	}
+}
+
+// checkLock returns a conflict response if the state is locked with another ID than the given one
+func (c storageClient) checkLock(ctx context.Context, path string, id string) (*http.Response, error) {
+	current, err := c.storage.CurrentLock(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.ID != id {
+		c.logger.Debug("State is locked by another lock", "path", path, "lock-id", current.ID, "request-id", id)
+		return response(http.StatusConflict, current.Info), nil
+	}
+	return nil, nil
+}
+
+func response(statusCode int, body []byte) *http.Response {
+	header := http.Header{}
+	if len(body) > 0 && statusCode != http.StatusBadRequest {
+		header.Set("Content-Type", "application/json")
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/storage"
+)
+
+func Test_storageClient_Send(t *testing.T) {
+	const (
+		lockA = `{"ID":"a","Operation":"OperationTypeApply"}`
+		lockB = `{"ID":"b","Operation":"OperationTypePlan"}`
+	)
+	type request struct {
+		method, path, body string
+		wantStatus         int
+		wantBody           string
+	}
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{
+			name: "state lifecycle",
+			requests: []request{
+				{method: http.MethodGet, path: "/states/test", wantStatus: http.StatusNotFound},
+				{method: http.MethodPost, path: "/states/test", body: "state-1", wantStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/states/test", wantStatus: http.StatusOK, wantBody: "state-1"},
+				{method: http.MethodDelete, path: "/states/test", wantStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/states/test", wantStatus: http.StatusNotFound},
+			},
+		},
+		{
+			name: "locking",
+			requests: []request{
+				{method: "LOCK", path: "/states/test", body: lockA, wantStatus: http.StatusOK},
+				{method: "LOCK", path: "/states/test", body: lockB, wantStatus: http.StatusLocked, wantBody: lockA},
+				{method: http.MethodPost, path: "/states/test?ID=b", body: "state-b", wantStatus: http.StatusConflict, wantBody: lockA},
+				{method: http.MethodPost, path: "/states/test?ID=a", body: "state-a", wantStatus: http.StatusOK},
+				{method: "UNLOCK", path: "/states/test", body: lockB, wantStatus: http.StatusLocked, wantBody: lockA},
+				{method: "UNLOCK", path: "/states/test", body: lockA, wantStatus: http.StatusOK},
+				{method: "LOCK", path: "/states/test", body: lockB, wantStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/states/test", wantStatus: http.StatusOK, wantBody: "state-a"},
+			},
+		},
+		{
+			name: "force unlock",
+			requests: []request{
+				{method: "LOCK", path: "/states/test", body: lockA, wantStatus: http.StatusOK},
+				{method: "UNLOCK", path: "/states/test", wantStatus: http.StatusOK},
+				{method: "LOCK", path: "/states/test", body: lockB, wantStatus: http.StatusOK},
+			},
+		},
+		{
+			name: "invalid lock information",
+			requests: []request{
+				{method: "LOCK", path: "/states/test", body: "{}", wantStatus: http.StatusBadRequest},
+			},
+		},
+		{
+			name: "readiness",
+			requests: []request{
+				{method: http.MethodGet, path: "/", wantStatus: http.StatusOK},
+			},
+		},
+		{
+			name: "unsupported method",
+			requests: []request{
+				{method: http.MethodPut, path: "/states/test", wantStatus: http.StatusMethodNotAllowed},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateStorage, err := storage.NewLocal(t.TempDir())
+			require.NoError(t, err)
+			client := NewStorageClient(&testConfig{
+				test:         t,
+				lockMethod:   "LOCK",
+				unlockMethod: "UNLOCK",
+				probePath:    "/",
+			}, stateStorage)
+
+			for _, r := range tt.requests {
+				req, err := retryablehttp.NewRequest(r.method, r.path, []byte(r.body))
+				require.NoError(t, err)
+				resp, err := client.Send(req)
+				require.NoError(t, err)
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, r.wantStatus, resp.StatusCode, "%s %s", r.method, r.path)
+				if r.wantBody != "" {
+					assert.Equal(t, r.wantBody, string(body), "%s %s", r.method, r.path)
+				}
+			}
+		})
+	}
+}
+
+func Test_storageClient_Send_serialized(t *testing.T) {
+	stateStorage, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	recorder := &recordingStorage{Storage: stateStorage, locking: make(chan struct{})}
+	client := NewStorageClient(&testConfig{
+		test:         t,
+		lockMethod:   "LOCK",
+		unlockMethod: "UNLOCK",
+		probePath:    "/",
+	}, recorder)
+
+	write := make(chan int)
+	go func() {
+		req, _ := retryablehttp.NewRequest(http.MethodPost, "/states/test", []byte("state"))
+		resp, err := client.Send(req)
+		assert.NoError(t, err)
+		write <- resp.StatusCode
+	}()
+	req, err := retryablehttp.NewRequest("LOCK", "/states/test", []byte(`{"ID":"a"}`))
+	require.NoError(t, err)
+	<-recorder.locking
+	resp, err := client.Send(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, <-write)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"current-lock", "put", "lock"}, recorder.calls)
+}
+
+// recordingStorage records the calls changing the state or the lock. CurrentLock leaves a concurrent call of Lock time
+// to acquire the lock between checking the lock and writing the state, unless the calls are serialized.
+type recordingStorage struct {
+	storage.Storage
+	mutex   sync.Mutex
+	calls   []string
+	locking chan struct{}
+}
+
+func (s *recordingStorage) record(call string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *recordingStorage) CurrentLock(ctx context.Context, path string) (*storage.Lock, error) {
+	s.record("current-lock")
+	current, err := s.Storage.CurrentLock(ctx, path)
+	close(s.locking)
+	time.Sleep(100 * time.Millisecond)
+	return current, err
+}
+
+func (s *recordingStorage) Put(ctx context.Context, path string, state []byte) error {
+	s.record("put")
+	return s.Storage.Put(ctx, path, state)
+}
+
+func (s *recordingStorage) Lock(ctx context.Context, path string, lock storage.Lock) (*storage.Lock, error) {
+	s.record("lock")
+	return s.Storage.Lock(ctx, path, lock)
+}
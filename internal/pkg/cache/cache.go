@@ -0,0 +1,127 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache holds sensitive values in memory. Values are stored with a version and are only returned for the same
+// version. The least recently used values are evicted if the size limit is exceeded. Evicted, expired and replaced
+// values are zeroed.
+type Cache struct {
+	name    string
+	maxSize int64
+	ttl     time.Duration
+	now     func() time.Time
+
+	mutex   sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type entry struct {
+	key     string
+	version string
+	value   []byte
+	expires time.Time
+}
+
+// New creates a Cache holding values up to the maximum size in bytes for the time to live. The name labels the
+// metrics of the cache.
+func New(name string, maxSize int64, ttl time.Duration) *Cache {
+	return &Cache{
+		name:    name,
+		maxSize: maxSize,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Get returns a copy of the value of the key if it has been stored with the version and has not expired
+func (c *Cache) Get(key, version string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		missCounter.WithLabelValues(c.name).Inc()
+		return nil, false
+	}
+	e := element.Value.(*entry)
+	if e.version != version || !c.now().Before(e.expires) {
+		c.remove(element)
+		missCounter.WithLabelValues(c.name).Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	hitCounter.WithLabelValues(c.name).Inc()
+	return append([]byte(nil), e.value...), true
+}
+
+// Put stores a copy of the value of the key with the version. Values larger than the maximum size are not stored.
+func (c *Cache) Put(key, version string, value []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.removeExpired()
+	if int64(len(value)) > c.maxSize {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&entry{
+		key:     key,
+		version: version,
+		value:   append([]byte(nil), value...),
+		expires: c.now().Add(c.ttl),
+	})
+	c.size += int64(len(value))
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Delete removes the value of the key
+func (c *Cache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *Cache) removeExpired() {
+	now := c.now()
+	for element := c.lru.Back(); element != nil; {
+		previous := element.Prev()
+		if !now.Before(element.Value.(*entry).expires) {
+			c.remove(element)
+		}
+		element = previous
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	e := c.lru.Remove(element).(*entry)
+	delete(c.entries, e.key)
+	c.size -= int64(len(e.value))
+	clear(e.value)
+	evictionCounter.WithLabelValues(c.name).Inc()
+}
@@ -0,0 +1,104 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_Get(t *testing.T) {
+	cache, now := newTestCache(t.Name(), 8, time.Minute)
+	cache.Put("a", "v1", []byte("1234"))
+
+	got, ok := cache.Get("a", "v1")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1234"), got)
+	got[0] = 'x'
+	got, _ = cache.Get("a", "v1")
+	assert.Equal(t, []byte("1234"), got, "returned values must be copies")
+
+	_, ok = cache.Get("b", "v1")
+	assert.False(t, ok, "unknown key")
+
+	*now = now.Add(time.Minute)
+	_, ok = cache.Get("a", "v1")
+	assert.False(t, ok, "expired value")
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(hitCounter.WithLabelValues(t.Name())))
+	assert.Equal(t, float64(2), testutil.ToFloat64(missCounter.WithLabelValues(t.Name())))
+}
+
+func TestCache_Get_otherVersion(t *testing.T) {
+	cache, _ := newTestCache(t.Name(), 8, time.Minute)
+	cache.Put("a", "v1", []byte("1234"))
+	stored := cache.entries["a"].Value.(*entry).value
+
+	_, ok := cache.Get("a", "v2")
+
+	assert.False(t, ok)
+	assert.Equal(t, []byte{0, 0, 0, 0}, stored, "stale value must be zeroed")
+	assert.Empty(t, cache.entries)
+	assert.Zero(t, cache.size)
+}
+
+func TestCache_Put(t *testing.T) {
+	cache, now := newTestCache(t.Name(), 8, time.Minute)
+	cache.Put("a", "v1", []byte("1234"))
+	a := cache.entries["a"].Value.(*entry).value
+	cache.Put("b", "v1", []byte("1234"))
+	*now = now.Add(time.Second)
+	_, _ = cache.Get("a", "v1")
+
+	cache.Put("c", "v1", []byte("12"))
+
+	_, ok := cache.Get("b", "v1")
+	assert.False(t, ok, "least recently used value must be evicted")
+	_, ok = cache.Get("a", "v1")
+	assert.True(t, ok)
+	assert.Equal(t, int64(6), cache.size)
+
+	cache.Put("d", "v1", []byte("123456789"))
+	_, ok = cache.Get("d", "v1")
+	assert.False(t, ok, "values larger than the maximum size are not stored")
+
+	*now = now.Add(time.Minute)
+	cache.Put("e", "v1", []byte("1"))
+	assert.Equal(t, []byte{0, 0, 0, 0}, a, "expired value must be zeroed")
+	assert.Len(t, cache.entries, 1)
+	assert.Equal(t, int64(1), cache.size)
+}
+
+func TestCache_Delete(t *testing.T) {
+	cache, _ := newTestCache(t.Name(), 8, time.Minute)
+	cache.Put("a", "v1", []byte("1234"))
+	stored := cache.entries["a"].Value.(*entry).value
+
+	cache.Delete("a")
+
+	_, ok := cache.Get("a", "v1")
+	assert.False(t, ok)
+	assert.Equal(t, []byte{0, 0, 0, 0}, stored)
+}
+
+func newTestCache(name string, maxSize int64, ttl time.Duration) (*Cache, *time.Time) {
+	now := time.Now()
+	cache := New(name, maxSize, ttl)
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
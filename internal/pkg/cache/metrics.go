@@ -0,0 +1,44 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	hitCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_cache_hit_counter",
+			Help: "Counter Vector of values found in the cache",
+		},
+		[]string{"cache"},
+	)
+	missCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_cache_miss_counter",
+			Help: "Counter Vector of values not found in the cache or stored with another version",
+		},
+		[]string{"cache"},
+	)
+	evictionCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_cache_eviction_counter",
+			Help: "Counter Vector of values removed and zeroed by the cache",
+		},
+		[]string{"cache"},
+	)
+)
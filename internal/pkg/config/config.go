@@ -16,13 +16,69 @@ package config
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 )
 
+const (
+	// EncryptionStrategyAll encrypts the whole state except the unencrypted field selection
+	EncryptionStrategyAll = "all"
+	// EncryptionStrategySensitive encrypts only the values terraform marks as sensitive
+	EncryptionStrategySensitive = "sensitive"
+)
+
+const (
+	// VaultAuthMethodAppRole authenticates with a Vault AppRole
+	VaultAuthMethodAppRole = "approle"
+	// VaultAuthMethodToken authenticates with a static Vault token
+	VaultAuthMethodToken = "token"
+	// VaultAuthMethodKubernetes authenticates with a Kubernetes service account token
+	VaultAuthMethodKubernetes = "kubernetes"
+	// VaultAuthMethodJWT authenticates with a JWT/OIDC token
+	VaultAuthMethodJWT = "jwt"
+)
+
+const (
+	// BackendTypeHTTP forwards the terraform states to a terraform HTTP backend
+	BackendTypeHTTP = "http"
+	// BackendTypeLocal stores the terraform states in a local directory
+	BackendTypeLocal = "local"
+	// BackendTypeS3 stores the terraform states in a S3 compatible object storage
+	BackendTypeS3 = "s3"
+	// BackendTypePostgres stores the terraform states in a PostgreSQL database
+	BackendTypePostgres = "postgres"
+)
+
+const (
+	// DecryptFailurePolicyPassthrough answers with the unchanged backend response if the state can not be decrypted
+	DecryptFailurePolicyPassthrough = "passthrough"
+	// DecryptFailurePolicyPassthroughIfPlaintext answers with the unchanged backend response only if the state has
+	// no SOPS metadata
+	DecryptFailurePolicyPassthroughIfPlaintext = "passthrough-only-if-plaintext"
+	// DecryptFailurePolicyFail answers with 502 Bad Gateway if the state can not be decrypted
+	DecryptFailurePolicyFail = "fail"
+)
+
+const (
+	// MigrationModeOff answers with plaintext states without migrating them
+	MigrationModeOff = "off"
+	// MigrationModeDetect reports plaintext states read by GET requests without migrating them
+	MigrationModeDetect = "detect"
+	// MigrationModeEncrypt encrypts plaintext states read by GET requests and writes them back under a lock
+	MigrationModeEncrypt = "encrypt"
+)
+
+var (
+	postgresTableRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
 // AgeConfig provides keys to handle AGE de-/encryption
 type AgeConfig interface {
-	AgePublicKey() string
+	AgePublicKeys() []string
 	AgePrivateKey() string
 }
 
@@ -31,24 +87,183 @@ type VaultConfig interface {
 	VaultAddr() string
 	VaultKeyMount() string
 	VaultKeyName() string
+	VaultAuthMethod() string
+	VaultAuthMount() string
+	VaultAuthRole() string
+	VaultAuthJWT() string
+	VaultAuthJWTFile() string
+	VaultToken() string
 	VaultAppRoleID() string
 	VaultAppRoleSecretID() string
+	VaultNamespace() string
+	VaultCACert() []byte
+	VaultClientCert() []byte
+	VaultClientKey() []byte
+	VaultTLSServerName() string
 	Logger() hclog.Logger
 }
 
+// KMSConfig provides the endpoints of the cloud key management services, e.g. of local emulators.
+// Without an endpoint the default endpoint of the service is used.
+type KMSConfig interface {
+	AWSKMSEndpoint() string
+	GCPKMSEndpoint() string
+}
+
+// KeyGroup describes the master keys of a single SOPS key group
+type KeyGroup struct {
+	Name                 string
+	AgePublicKeys        []string
+	VaultTransitKeys     []VaultTransitKey
+	AWSKMSKeys           []AWSKMSKey
+	GCPKMSResourceIDs    []string
+	AzureKeyVaultKeyURLs []string
+	PGPFingerprints      []string
+}
+
+// AWSKMSKey references an AWS KMS key
+type AWSKMSKey struct {
+	ARN     string
+	Role    string
+	Profile string
+	Context map[string]string
+}
+
+// VaultTransitKey references a key of a Vault transit engine
+type VaultTransitKey struct {
+	Mount string
+	Name  string
+}
+
+// KeyGroupConfig provides the SOPS key groups and the Shamir threshold.
+// Without any configured key group the AGE and Vault keys form a single group.
+type KeyGroupConfig interface {
+	KeyGroups() []KeyGroup
+	ShamirThreshold() int
+}
+
+// FieldSelectionConfig provides the selection of the state values to encrypt.
+// Without any selection the default unencrypted regex of the transformer applies.
+// The sensitive encryption strategy derives the selection from the state itself
+// and additionally encrypts the always encrypted keys.
+type FieldSelectionConfig interface {
+	EncryptionStrategy() string
+	AlwaysEncryptedKeys() []string
+	EncryptedRegex() string
+	UnencryptedRegex() string
+	UnencryptedSuffix() string
+	MACOnlyEncrypted() bool
+}
+
+// CreationRule selects the keys and the encrypted values of the states whose path matches the path regex. The AGE
+// public keys and the Vault transit key of a rule replace all keys of the transform config, a rule without keys uses
+// the keys of the transform config. The encrypted regex of a rule replaces the field selection.
+type CreationRule struct {
+	PathRegex       string
+	AgePublicKeys   []string
+	VaultTransitKey VaultTransitKey
+	EncryptedRegex  string
+}
+
+// CreationRuleConfig provides the creation rules like the creation_rules of a .sops.yaml file.
+// The first rule whose path regex matches the path of a state applies, without matching rule the
+// keys and the field selection of the transform config apply.
+type CreationRuleConfig interface {
+	CreationRules() []CreationRule
+}
+
 // TransformConfig provides transform configuration data
 type TransformConfig interface {
 	AgeConfig
 	VaultConfig
+	KMSConfig
+	KeyGroupConfig
+	FieldSelectionConfig
+	CreationRuleConfig
+}
+
+// StorageConfig provides access to the storage of the terraform states if the service itself is the state store
+type StorageConfig interface {
+	BackendType() string
+	BackendLocalDirectory() string
+	BackendS3Bucket() string
+	BackendS3Prefix() string
+	BackendS3Region() string
+	BackendS3Endpoint() string
+	BackendS3PathStyle() bool
+	BackendS3AccessKeyID() string
+	BackendS3SecretAccessKey() string
+	BackendPostgresDSN() string
+	BackendPostgresTable() string
+	Logger() hclog.Logger
+}
+
+// ServerTLSConfig provides the TLS termination of the terraform SOPS backend server
+type ServerTLSConfig interface {
+	ServerTLSCertFile() string
+	ServerTLSKeyFile() string
+	ServerTLSClientCAFile() string
+	ServerTLSClientSubjects() []string
+}
+
+// MonitoringConfig provides the listener of the monitoring server
+type MonitoringConfig interface {
+	MonitoringAddress() string
+	MonitoringPort() string
+}
+
+// CacheConfig provides the in-memory cache of decrypted terraform states and data keys
+type CacheConfig interface {
+	CacheMaxSize() int64
+	CacheTTL() time.Duration
+	DataKeyReuseWindow() time.Duration
+}
+
+// AuthConfig provides the authentication and authorization of requests to the terraform SOPS backend server
+type AuthConfig interface {
+	AuthTokensFile() string
+	AuthHtpasswdFile() string
+	AuthJWTKeyFile() string
+	AuthJWTIssuer() string
+	AuthJWTAudience() string
+	AuthJWTIdentityClaim() string
+	AuthPolicyFile() string
+	AuthForwardCredentials() bool
+}
+
+// AuditConfig provides the sinks of the audit records of the requests to the terraform SOPS backend server.
+// Without any sink no audit records are written.
+type AuditConfig interface {
+	AuditFile() string
+	AuditSyslogNetwork() string
+	AuditSyslogAddress() string
+	Logger() hclog.Logger
 }
 
 // ServerConfig provides configuration to a terraform SOPS backend server
 type ServerConfig interface {
 	TransformConfig
+	StorageConfig
+	ServerTLSConfig
+	MonitoringConfig
+	CacheConfig
+	AuthConfig
+	AuditConfig
 	ServerPort() string
+	ServerShutdownTimeout() time.Duration
+	ServerReadTimeout() time.Duration
+	ServerWriteTimeout() time.Duration
+	ServerMaxBodySize() int64
+	DecryptFailurePolicy() string
+	MigrationMode() string
+	WriteGuard() bool
 	BackendURL() string
+	BackendRoutes() []Route
 	BackendMTLSCert() []byte
 	BackendMTLSKey() []byte
+	BackendUsername() string
+	BackendPassword() string
+	BackendToken() string
 	BackendLockMethod() string
 	BackendUnlockMethod() string
 	BackendReadinessProbePath() string
@@ -56,25 +271,364 @@ type ServerConfig interface {
 	String() string
 }
 
+// Route forwards the requests matching its host and path prefix to an upstream terraform HTTP backend. The path
+// prefix is removed from the path appended to the upstream URL. Unset mTLS material and lock methods default to the
+// ones of the backend. A key group restricts the encryption to the key group of that name, otherwise all key groups
+// are used.
+type Route struct {
+	Name         string
+	Host         string
+	PathPrefix   string
+	URL          string
+	MTLSCert     []byte
+	MTLSKey      []byte
+	LockMethod   string
+	UnlockMethod string
+	KeyGroup     string
+}
+
 // ValidateServerConfig returns with error if the config is not valid
 func ValidateServerConfig(config ServerConfig) error {
-	if config.AgePublicKey() == "" {
-		return fmt.Errorf("AGE public key required")
+	if err := ValidateTransformConfig(config); err != nil {
+		return err
+	}
+	if err := validateStorageConfig(config); err != nil {
+		return err
 	}
-	if config.BackendURL() == "" {
-		return fmt.Errorf("backend URL required")
+	if err := validateServerTLSConfig(config); err != nil {
+		return err
 	}
-	if config.VaultAddr() == "" && config.AgePrivateKey() == "" {
-		return fmt.Errorf("vault address or AGE private key required")
+	if err := validateCacheConfig(config); err != nil {
+		return err
 	}
-	if config.VaultAddr() != "" && config.VaultAppRoleID() == "" {
-		return fmt.Errorf("vault AppRole ID required")
+	if err := validateAuthConfig(config); err != nil {
+		return err
 	}
-	if config.VaultAddr() != "" && config.VaultAppRoleSecretID() == "" {
-		return fmt.Errorf("vault AppRole secret ID required")
+	if err := validateAuditConfig(config); err != nil {
+		return err
+	}
+	if config.ServerMaxBodySize() < 0 {
+		return fmt.Errorf("configuration failure, max body size %d must not be negative", config.ServerMaxBodySize())
+	}
+	switch config.DecryptFailurePolicy() {
+	case "", DecryptFailurePolicyPassthrough, DecryptFailurePolicyPassthroughIfPlaintext, DecryptFailurePolicyFail:
+	default:
+		return fmt.Errorf("unknown decrypt failure policy %q, expected one of [%s, %s, %s]", config.DecryptFailurePolicy(), DecryptFailurePolicyPassthrough, DecryptFailurePolicyPassthroughIfPlaintext, DecryptFailurePolicyFail)
+	}
+	switch config.MigrationMode() {
+	case "", MigrationModeOff, MigrationModeDetect, MigrationModeEncrypt:
+	default:
+		return fmt.Errorf("unknown migration mode %q, expected one of [%s, %s, %s]", config.MigrationMode(), MigrationModeOff, MigrationModeDetect, MigrationModeEncrypt)
 	}
 	if (len(config.BackendMTLSCert()) > 0 || len(config.BackendMTLSKey()) > 0) && (len(config.BackendMTLSCert()) == 0 || len(config.BackendMTLSKey()) == 0) {
 		return fmt.Errorf("backend MTLS certificate (len %d) or key(len %d) is empty", len(config.BackendMTLSCert()), len(config.BackendMTLSKey()))
 	}
+	if config.BackendToken() != "" && (config.BackendUsername() != "" || config.BackendPassword() != "") {
+		return fmt.Errorf("backend token and backend username or password can not be combined")
+	}
+	if config.BackendPassword() != "" && config.BackendUsername() == "" {
+		return fmt.Errorf("backend password requires the backend username")
+	}
+	return nil
+}
+
+// ValidateTransformConfig returns with error if the config is not valid to en- and decrypt terraform states
+func ValidateTransformConfig(config TransformConfig) error {
+	if len(config.AgePublicKeys()) == 0 && len(config.KeyGroups()) == 0 {
+		return fmt.Errorf("AGE public key or key groups required")
+	}
+	if err := validateKeyGroupConfig(config); err != nil {
+		return err
+	}
+	if err := validateFieldSelectionConfig(config); err != nil {
+		return err
+	}
+	if err := validateCreationRules(config); err != nil {
+		return err
+	}
+	if config.VaultAddr() == "" && config.AgePrivateKey() == "" && !hasKMSOrPGPKeys(config.KeyGroups()) {
+		return fmt.Errorf("vault address, AGE private key or key groups with KMS or PGP keys required")
+	}
+	if config.VaultAddr() != "" {
+		if err := validateVaultAuthConfig(config); err != nil {
+			return err
+		}
+	}
+	if (len(config.VaultClientCert()) > 0 || len(config.VaultClientKey()) > 0) && (len(config.VaultClientCert()) == 0 || len(config.VaultClientKey()) == 0) {
+		return fmt.Errorf("vault TLS client certificate (len %d) or key(len %d) is empty", len(config.VaultClientCert()), len(config.VaultClientKey()))
+	}
+	if err := validateKMSEndpoint("AWS KMS", config.AWSKMSEndpoint()); err != nil {
+		return err
+	}
+	return validateKMSEndpoint("GCP KMS", config.GCPKMSEndpoint())
+}
+
+func validateKMSEndpoint(name, endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	if endpointURL, err := url.Parse(endpoint); err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+		return fmt.Errorf("%s endpoint %q must be an http or https URL", name, endpoint)
+	}
+	return nil
+}
+
+func validateCacheConfig(config CacheConfig) error {
+	if config.CacheMaxSize() < 0 {
+		return fmt.Errorf("configuration failure, cache max size %d must not be negative", config.CacheMaxSize())
+	}
+	if config.CacheMaxSize() > 0 && config.CacheTTL() <= 0 {
+		return fmt.Errorf("configuration failure, cache ttl %s must be positive", config.CacheTTL())
+	}
+	if config.DataKeyReuseWindow() < 0 {
+		return fmt.Errorf("configuration failure, data key reuse window %s must not be negative", config.DataKeyReuseWindow())
+	}
+	return nil
+}
+
+func validateServerTLSConfig(config ServerTLSConfig) error {
+	if (config.ServerTLSCertFile() == "") != (config.ServerTLSKeyFile() == "") {
+		return fmt.Errorf("server TLS certificate file and key file have to be set together")
+	}
+	if config.ServerTLSClientCAFile() != "" && config.ServerTLSCertFile() == "" {
+		return fmt.Errorf("server TLS client CA file requires the server TLS certificate and key file")
+	}
+	if len(config.ServerTLSClientSubjects()) > 0 && config.ServerTLSClientCAFile() == "" {
+		return fmt.Errorf("server TLS client subjects require the server TLS client CA file")
+	}
+	return nil
+}
+
+func validateAuthConfig(config AuthConfig) error {
+	if config.AuthJWTKeyFile() == "" && (config.AuthJWTIssuer() != "" || config.AuthJWTAudience() != "") {
+		return fmt.Errorf("auth JWT issuer and audience require the auth JWT key file")
+	}
+	if config.AuthPolicyFile() != "" && config.AuthTokensFile() == "" && config.AuthHtpasswdFile() == "" && config.AuthJWTKeyFile() == "" {
+		return fmt.Errorf("auth policy file requires an auth tokens file, htpasswd file or JWT key file")
+	}
+	if config.AuthForwardCredentials() && config.AuthTokensFile() == "" && config.AuthHtpasswdFile() == "" && config.AuthJWTKeyFile() == "" {
+		return fmt.Errorf("auth forward credentials requires an auth tokens file, htpasswd file or JWT key file")
+	}
+	return nil
+}
+
+func validateAuditConfig(config AuditConfig) error {
+	if config.AuditSyslogAddress() == "" {
+		return nil
+	}
+	switch config.AuditSyslogNetwork() {
+	case "", "udp", "tcp", "unix", "unixgram":
+		return nil
+	default:
+		return fmt.Errorf("unknown audit syslog network %q, expected one of [udp, tcp, unix, unixgram]", config.AuditSyslogNetwork())
+	}
+}
+
+func validateStorageConfig(config ServerConfig) error {
+	if config.BackendType() != "" && config.BackendType() != BackendTypeHTTP && len(config.BackendRoutes()) > 0 {
+		return fmt.Errorf("backend routes require the backend type %q", BackendTypeHTTP)
+	}
+	switch config.BackendType() {
+	case "", BackendTypeHTTP:
+		if config.BackendURL() == "" && len(config.BackendRoutes()) == 0 {
+			return fmt.Errorf("backend URL or backend routes required")
+		}
+		if config.BackendURL() != "" && len(config.BackendRoutes()) > 0 {
+			return fmt.Errorf("backend URL and backend routes can not be combined, requests without matching route are rejected")
+		}
+		if err := validateRoutes(config); err != nil {
+			return err
+		}
+	case BackendTypeLocal:
+		if config.BackendLocalDirectory() == "" {
+			return fmt.Errorf("backend local directory required")
+		}
+	case BackendTypeS3:
+		if config.BackendS3Bucket() == "" {
+			return fmt.Errorf("backend S3 bucket required")
+		}
+		if (config.BackendS3AccessKeyID() == "") != (config.BackendS3SecretAccessKey() == "") {
+			return fmt.Errorf("backend S3 access key ID and secret access key have to be set together")
+		}
+	case BackendTypePostgres:
+		if config.BackendPostgresDSN() == "" {
+			return fmt.Errorf("backend PostgreSQL DSN required")
+		}
+		if !postgresTableRegex.MatchString(config.BackendPostgresTable()) {
+			return fmt.Errorf("invalid backend PostgreSQL table name %q", config.BackendPostgresTable())
+		}
+	default:
+		return fmt.Errorf("unknown backend type %q, expected one of [%s, %s, %s, %s]", config.BackendType(), BackendTypeHTTP, BackendTypeLocal, BackendTypeS3, BackendTypePostgres)
+	}
+	if config.BackendType() != "" && config.BackendType() != BackendTypeHTTP {
+		return validateStorageLockMethods(config)
+	}
+	return nil
+}
+
+// validateStorageLockMethods rejects lock methods, which the storage can not tell apart from the state requests
+func validateStorageLockMethods(config ServerConfig) error {
+	for _, method := range []string{config.BackendLockMethod(), config.BackendUnlockMethod()} {
+		switch method {
+		case "", http.MethodGet, http.MethodPost, http.MethodDelete:
+			return fmt.Errorf("backend lock method %q is not supported by the backend type %q, lock and unlock methods have to differ from GET, POST and DELETE", method, config.BackendType())
+		}
+	}
+	if config.BackendLockMethod() == config.BackendUnlockMethod() {
+		return fmt.Errorf("backend lock and unlock method %q have to differ", config.BackendLockMethod())
+	}
+	return nil
+}
+
+func validateRoutes(config ServerConfig) error {
+	keyGroups := map[string]bool{}
+	for _, keyGroup := range config.KeyGroups() {
+		keyGroups[keyGroup.Name] = true
+	}
+	names := map[string]bool{}
+	for i, route := range config.BackendRoutes() {
+		if route.Name == "" {
+			return fmt.Errorf("backend route %d requires a name", i)
+		}
+		if names[route.Name] {
+			return fmt.Errorf("backend route %d (%s) has a duplicate name", i, route.Name)
+		}
+		names[route.Name] = true
+		if route.URL == "" {
+			return fmt.Errorf("backend route %d (%s) requires an URL", i, route.Name)
+		}
+		if route.PathPrefix != "" && route.PathPrefix[0] != '/' {
+			return fmt.Errorf("backend route %d (%s) path prefix %q has to start with /", i, route.Name, route.PathPrefix)
+		}
+		if (len(route.MTLSCert) > 0) != (len(route.MTLSKey) > 0) {
+			return fmt.Errorf("backend route %d (%s) MTLS certificate (len %d) or key(len %d) is empty", i, route.Name, len(route.MTLSCert), len(route.MTLSKey))
+		}
+		if route.KeyGroup != "" && !keyGroups[route.KeyGroup] {
+			return fmt.Errorf("backend route %d (%s) uses the unknown key group %q", i, route.Name, route.KeyGroup)
+		}
+	}
+	return nil
+}
+
+func validateVaultAuthConfig(config VaultConfig) error {
+	switch config.VaultAuthMethod() {
+	case "", VaultAuthMethodAppRole:
+		if config.VaultAppRoleID() == "" {
+			return fmt.Errorf("vault AppRole ID required")
+		}
+		if config.VaultAppRoleSecretID() == "" {
+			return fmt.Errorf("vault AppRole secret ID required")
+		}
+	case VaultAuthMethodToken:
+		if config.VaultToken() == "" {
+			return fmt.Errorf("vault token required")
+		}
+	case VaultAuthMethodKubernetes:
+		if config.VaultAuthRole() == "" {
+			return fmt.Errorf("vault Kubernetes auth role required")
+		}
+	case VaultAuthMethodJWT:
+		if config.VaultAuthJWT() == "" && config.VaultAuthJWTFile() == "" {
+			return fmt.Errorf("vault JWT or JWT file required")
+		}
+	default:
+		return fmt.Errorf("unknown vault auth method %q, expected one of [%s, %s, %s, %s]", config.VaultAuthMethod(), VaultAuthMethodAppRole, VaultAuthMethodToken, VaultAuthMethodKubernetes, VaultAuthMethodJWT)
+	}
+	return nil
+}
+
+func validateKeyGroupConfig(config TransformConfig) error {
+	keyGroups := config.KeyGroups()
+	numberOfGroups := len(keyGroups)
+	if numberOfGroups == 0 {
+		numberOfGroups = 1
+	}
+	if config.ShamirThreshold() < 0 || config.ShamirThreshold() > numberOfGroups {
+		return fmt.Errorf("shamir threshold %d must be between 0 and the number of key groups %d", config.ShamirThreshold(), numberOfGroups)
+	}
+	if numberOfGroups > 1 && config.ShamirThreshold() == 1 {
+		return fmt.Errorf("shamir threshold must be 0 or at least 2 for %d key groups", numberOfGroups)
+	}
+	for i, keyGroup := range keyGroups {
+		if len(keyGroup.AgePublicKeys) == 0 && len(keyGroup.VaultTransitKeys) == 0 && !hasKMSOrPGPKeys([]KeyGroup{keyGroup}) {
+			return fmt.Errorf("key group %d (%s) requires at least one AGE public key, Vault transit key, KMS key or PGP fingerprint", i, keyGroup.Name)
+		}
+		if len(keyGroup.VaultTransitKeys) > 0 && config.VaultAddr() == "" {
+			return fmt.Errorf("key group %d (%s) uses Vault transit keys but no vault address is configured", i, keyGroup.Name)
+		}
+		for _, vaultTransitKey := range keyGroup.VaultTransitKeys {
+			if vaultTransitKey.Mount == "" || vaultTransitKey.Name == "" {
+				return fmt.Errorf("key group %d (%s) requires mount and name for each Vault transit key", i, keyGroup.Name)
+			}
+		}
+		for _, awsKMSKey := range keyGroup.AWSKMSKeys {
+			if awsKMSKey.ARN == "" {
+				return fmt.Errorf("key group %d (%s) requires an ARN for each AWS KMS key", i, keyGroup.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// hasKMSOrPGPKeys returns true if a key group has keys, which decrypt the data key without AGE or Vault
+func hasKMSOrPGPKeys(keyGroups []KeyGroup) bool {
+	for _, keyGroup := range keyGroups {
+		if len(keyGroup.AWSKMSKeys) > 0 || len(keyGroup.GCPKMSResourceIDs) > 0 || len(keyGroup.AzureKeyVaultKeyURLs) > 0 || len(keyGroup.PGPFingerprints) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func validateCreationRules(config TransformConfig) error {
+	for i, rule := range config.CreationRules() {
+		if rule.PathRegex == "" {
+			return fmt.Errorf("creation rule %d requires a path regex", i)
+		}
+		if _, err := regexp.Compile(rule.PathRegex); err != nil {
+			return fmt.Errorf("creation rule %d (%s) has an invalid path regex: %w", i, rule.PathRegex, err)
+		}
+		if _, err := regexp.Compile(rule.EncryptedRegex); err != nil {
+			return fmt.Errorf("creation rule %d (%s) has an invalid encrypted regex: %w", i, rule.PathRegex, err)
+		}
+		hasVaultTransitKey := rule.VaultTransitKey.Mount != "" || rule.VaultTransitKey.Name != ""
+		if hasVaultTransitKey && (rule.VaultTransitKey.Mount == "" || rule.VaultTransitKey.Name == "") {
+			return fmt.Errorf("creation rule %d (%s) requires mount and name of the Vault transit key", i, rule.PathRegex)
+		}
+		if hasVaultTransitKey && config.VaultAddr() == "" {
+			return fmt.Errorf("creation rule %d (%s) uses a Vault transit key but no vault address is configured", i, rule.PathRegex)
+		}
+	}
+	return nil
+}
+
+func validateFieldSelectionConfig(config FieldSelectionConfig) error {
+	switch config.EncryptionStrategy() {
+	case "", EncryptionStrategyAll:
+		if len(config.AlwaysEncryptedKeys()) > 0 {
+			return fmt.Errorf("always encrypted keys require the %q encryption strategy", EncryptionStrategySensitive)
+		}
+	case EncryptionStrategySensitive:
+		if config.EncryptedRegex() != "" || config.UnencryptedRegex() != "" || config.UnencryptedSuffix() != "" {
+			return fmt.Errorf("the %q encryption strategy can not be combined with encrypted regex, unencrypted regex or unencrypted suffix", EncryptionStrategySensitive)
+		}
+	default:
+		return fmt.Errorf("unknown encryption strategy %q, expected one of [%s, %s]", config.EncryptionStrategy(), EncryptionStrategyAll, EncryptionStrategySensitive)
+	}
+	selections := 0
+	for _, selection := range []string{config.EncryptedRegex(), config.UnencryptedRegex(), config.UnencryptedSuffix()} {
+		if selection != "" {
+			selections++
+		}
+	}
+	if selections > 1 {
+		return fmt.Errorf("only one of encrypted regex, unencrypted regex or unencrypted suffix can be used")
+	}
+	if _, err := regexp.Compile(config.EncryptedRegex()); err != nil {
+		return fmt.Errorf("invalid encrypted regex: %w", err)
+	}
+	if _, err := regexp.Compile(config.UnencryptedRegex()); err != nil {
+		return fmt.Errorf("invalid unencrypted regex: %w", err)
+	}
 	return nil
 }
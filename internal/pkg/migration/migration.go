@@ -0,0 +1,153 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
+)
+
+// Status of the migration of a single terraform state
+type Status string
+
+const (
+	// StatusMigrated the plaintext state has been encrypted and written back
+	StatusMigrated Status = "migrated"
+	// StatusDryRun the plaintext state can be encrypted but has not been written back
+	StatusDryRun Status = "dry-run"
+	// StatusEncrypted the state is already encrypted
+	StatusEncrypted Status = "encrypted"
+	// StatusSkipped the backend has no state for the path
+	StatusSkipped Status = "skipped"
+	// StatusLocked the state is locked by someone else
+	StatusLocked Status = "locked"
+	// StatusFailed the state could not be migrated
+	StatusFailed Status = "failed"
+
+	lockOperation = "migrate"
+)
+
+// Result reports the migration of a single terraform state
+type Result struct {
+	Path   string
+	Status Status
+	Err    error
+}
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: %s (%s)", r.Path, r.Status, r.Err)
+	}
+	return fmt.Sprintf("%s: %s", r.Path, r.Status)
+}
+
+// Migrator encrypts the plaintext terraform states of the backend. The path of a state selects its creation rule, the
+// backend path addresses it at the backend.
+type Migrator interface {
+	Migrate(path, backendPath string) Result
+}
+
+// New Migrator using the given server config. A dry run reads and encrypts the plaintext states, but neither locks
+// nor writes them.
+func New(config config.ServerConfig, backendClient backend.Client, transformer transformer.SOPSTransformer, dryRun bool) Migrator {
+	return &migrator{
+		config:      config,
+		states:      backend.NewStateClient(config, backendClient, lockOperation),
+		transformer: transformer,
+		dryRun:      dryRun,
+	}
+}
+
+type migrator struct {
+	config      config.ServerConfig
+	states      backend.StateClient
+	transformer transformer.SOPSTransformer
+	dryRun      bool
+}
+
+func (m migrator) Migrate(path, backendPath string) (result Result) {
+	result = Result{Path: path}
+	var lock *backend.LockInfo
+	if !m.dryRun {
+		var err error
+		if lock, err = m.states.Lock(backendPath); err != nil {
+			return failed(result, fmt.Errorf("can not lock state: %w", err))
+		}
+		defer func() {
+			if err := m.states.Unlock(backendPath, lock); err != nil && result.Err == nil {
+				result = failed(result, fmt.Errorf("can not unlock state: %w", err))
+			}
+		}()
+	}
+
+	// the state is read again under the lock, as it may have been written since it has been detected
+	state, err := m.states.Get(backendPath)
+	if err != nil {
+		return failed(result, err)
+	}
+	if len(state) == 0 {
+		result.Status = StatusSkipped
+		return result
+	}
+	if !IsPlaintext(state) {
+		result.Status = StatusEncrypted
+		return result
+	}
+
+	var encrypted []byte
+	if err := m.transformer.ToSops(m.config, path, state, func(result []byte) { encrypted = result }); err != nil {
+		return failed(result, fmt.Errorf("can not encrypt state: %w", err))
+	}
+	if err := transformer.DetectLeaks(state, encrypted); err != nil {
+		transformer.AlertPlaintextLeak(lockOperation)
+		return failed(result, err)
+	}
+	if m.dryRun {
+		result.Status = StatusDryRun
+		return result
+	}
+
+	if err := m.states.Post(backendPath, lock, encrypted); err != nil {
+		return failed(result, err)
+	}
+	result.Status = StatusMigrated
+	return result
+}
+
+// IsPlaintext returns true if the state is a JSON object without SOPS metadata. Only the SOPS metadata is copied, not
+// the values of the state.
+func IsPlaintext(state []byte) bool {
+	var object struct {
+		Sops json.RawMessage `json:"sops"`
+	}
+	if err := json.Unmarshal(state, &object); err != nil {
+		return false
+	}
+	return object.Sops == nil
+}
+
+func failed(result Result, err error) Result {
+	result.Status = StatusFailed
+	if errors.Is(err, backend.ErrStateLocked) {
+		result.Status = StatusLocked
+	}
+	result.Err = err
+	return result
+}
@@ -0,0 +1,299 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
+)
+
+const (
+	plaintextState = `{"version":4,"serial":1}`
+	encryptedState = `{"version":4,"serial":1,"sops":{}}`
+)
+
+func Test_migrator_Migrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		state       string
+		dryRun      bool
+		locked      bool
+		encryptErr  error
+		wantStatus  Status
+		wantState   string
+		wantMethods []string
+	}{
+		{
+			name:        "migrate",
+			state:       plaintextState,
+			wantStatus:  StatusMigrated,
+			wantState:   "encrypted:" + plaintextState,
+			wantMethods: []string{"LOCK", http.MethodGet, http.MethodPost, "UNLOCK"},
+		},
+		{
+			name:        "dry run",
+			state:       plaintextState,
+			dryRun:      true,
+			wantStatus:  StatusDryRun,
+			wantState:   plaintextState,
+			wantMethods: []string{http.MethodGet},
+		},
+		{
+			name:        "already encrypted",
+			state:       encryptedState,
+			wantStatus:  StatusEncrypted,
+			wantState:   encryptedState,
+			wantMethods: []string{"LOCK", http.MethodGet, "UNLOCK"},
+		},
+		{
+			name:        "no state",
+			wantStatus:  StatusSkipped,
+			wantMethods: []string{"LOCK", http.MethodGet, "UNLOCK"},
+		},
+		{
+			name:        "locked state",
+			state:       plaintextState,
+			locked:      true,
+			wantStatus:  StatusLocked,
+			wantState:   plaintextState,
+			wantMethods: []string{"LOCK"},
+		},
+		{
+			name:        "encrypt failure",
+			state:       plaintextState,
+			encryptErr:  fmt.Errorf("no key"),
+			wantStatus:  StatusFailed,
+			wantState:   plaintextState,
+			wantMethods: []string{"LOCK", http.MethodGet, "UNLOCK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendClient := &testBackend{states: map[string]string{}, locked: tt.locked}
+			if tt.state != "" {
+				backendClient.states["/states/test"] = tt.state
+			}
+			transformer := &testTransformer{encryptErr: tt.encryptErr}
+			migrator := New(testConfig{}, backendClient, transformer, tt.dryRun)
+
+			got := migrator.Migrate("/team-a/states/test", "/states/test")
+
+			assert.Equal(t, "/team-a/states/test", got.Path)
+			assert.Equal(t, tt.wantStatus, got.Status)
+			assert.Equal(t, tt.wantStatus == StatusFailed || tt.wantStatus == StatusLocked, got.Err != nil)
+			assert.Equal(t, tt.wantMethods, backendClient.methods)
+			assert.Equal(t, tt.wantState, backendClient.states["/states/test"])
+			if tt.wantStatus == StatusMigrated {
+				assert.Equal(t, backendClient.lockID, backendClient.postID)
+				assert.NotEmpty(t, backendClient.postID)
+			}
+			for _, path := range transformer.paths {
+				assert.Equal(t, "/team-a/states/test", path, "creation rules match the path instead of the backend path")
+			}
+		})
+	}
+}
+
+func Test_migrator_Migrate_leak(t *testing.T) {
+	const state = `{"version":4,"serial":1,"lineage":"test","outputs":{"password":{"value":"very-secret-password","type":"string","sensitive":true}},"resources":[]}`
+	tests := []struct {
+		name        string
+		dryRun      bool
+		wantMethods []string
+	}{
+		{
+			name:        "migrate",
+			wantMethods: []string{"LOCK", http.MethodGet, "UNLOCK"},
+		},
+		{
+			name:        "dry run",
+			dryRun:      true,
+			wantMethods: []string{http.MethodGet},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := age.GenerateX25519Identity()
+			require.NoError(t, err)
+			backendClient := &testBackend{states: map[string]string{"/states/test": state}}
+			// the encrypted regex leaves the sensitive output unencrypted
+			leakConfig := leakTestConfig{agePublicKey: identity.Recipient().String(), encryptedRegex: "^lineage$"}
+			leakAlerts := plaintextLeakAlerts(t, lockOperation)
+
+			got := New(leakConfig, backendClient, transformer.New(), tt.dryRun).Migrate("/states/test", "/states/test")
+
+			assert.Equal(t, StatusFailed, got.Status)
+			assert.ErrorIs(t, got.Err, transformer.ErrPlaintextLeak)
+			assert.Equal(t, tt.wantMethods, backendClient.methods, "the leaking state is not posted")
+			assert.Equal(t, state, backendClient.states["/states/test"])
+			assert.Equal(t, leakAlerts+1, plaintextLeakAlerts(t, lockOperation))
+		})
+	}
+}
+
+func TestIsPlaintext(t *testing.T) {
+	assert.True(t, IsPlaintext([]byte(plaintextState)))
+	assert.False(t, IsPlaintext([]byte(encryptedState)))
+	assert.False(t, IsPlaintext([]byte("no JSON")))
+	assert.False(t, IsPlaintext([]byte(`["no object"]`)))
+}
+
+type testConfig struct {
+	config.ServerConfig
+}
+
+func (testConfig) BackendURL() string          { return "https://backend.test" }
+func (testConfig) BackendLockMethod() string   { return "LOCK" }
+func (testConfig) BackendUnlockMethod() string { return "UNLOCK" }
+func (testConfig) Logger() hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{Name: "unit-test", Level: hclog.Trace, Output: os.Stderr})
+}
+
+// leakTestConfig encrypts with the SOPS transformer, the AGE public key and the encrypted regex
+type leakTestConfig struct {
+	testConfig
+	agePublicKey   string
+	encryptedRegex string
+}
+
+func (c leakTestConfig) AgePublicKeys() []string            { return []string{c.agePublicKey} }
+func (leakTestConfig) AgePrivateKey() string                { return "" }
+func (leakTestConfig) VaultAddr() string                    { return "" }
+func (leakTestConfig) VaultAuthMethod() string              { return "" }
+func (leakTestConfig) VaultAuthMount() string               { return "" }
+func (leakTestConfig) VaultAuthRole() string                { return "" }
+func (leakTestConfig) VaultAuthJWT() string                 { return "" }
+func (leakTestConfig) VaultAuthJWTFile() string             { return "" }
+func (leakTestConfig) VaultToken() string                   { return "" }
+func (leakTestConfig) VaultAppRoleID() string               { return "" }
+func (leakTestConfig) VaultAppRoleSecretID() string         { return "" }
+func (leakTestConfig) VaultNamespace() string               { return "" }
+func (leakTestConfig) VaultCACert() []byte                  { return nil }
+func (leakTestConfig) VaultClientCert() []byte              { return nil }
+func (leakTestConfig) VaultClientKey() []byte               { return nil }
+func (leakTestConfig) VaultTLSServerName() string           { return "" }
+func (leakTestConfig) VaultKeyMount() string                { return "" }
+func (leakTestConfig) VaultKeyName() string                 { return "" }
+func (leakTestConfig) AWSKMSEndpoint() string               { return "" }
+func (leakTestConfig) GCPKMSEndpoint() string               { return "" }
+func (leakTestConfig) KeyGroups() []config.KeyGroup         { return nil }
+func (leakTestConfig) ShamirThreshold() int                 { return 0 }
+func (leakTestConfig) CreationRules() []config.CreationRule { return nil }
+func (leakTestConfig) EncryptionStrategy() string           { return config.EncryptionStrategyAll }
+func (leakTestConfig) AlwaysEncryptedKeys() []string        { return nil }
+func (c leakTestConfig) EncryptedRegex() string             { return c.encryptedRegex }
+func (leakTestConfig) UnencryptedRegex() string             { return "" }
+func (leakTestConfig) UnencryptedSuffix() string            { return "" }
+func (leakTestConfig) MACOnlyEncrypted() bool               { return false }
+
+// plaintextLeakAlerts returns the plaintext leak alerts raised so far for the method
+func plaintextLeakAlerts(t *testing.T, method string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "service_plaintext_leak_counter" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "method" && label.GetValue() == method {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// testTransformer prefixes a state with "encrypted:" and records the paths of the encrypted states
+type testTransformer struct {
+	encryptErr error
+	paths      []string
+}
+
+func (t *testTransformer) ToSops(_ config.TransformConfig, path string, input []byte, handler func(result []byte)) error {
+	t.paths = append(t.paths, path)
+	if t.encryptErr != nil {
+		return t.encryptErr
+	}
+	handler([]byte("encrypted:" + string(input)))
+	return nil
+}
+
+func (t *testTransformer) FromSops(_ config.TransformConfig, _ []byte, _ func(result []byte) error) error {
+	return fmt.Errorf("unexpected FromSops call")
+}
+
+// testBackend is an in memory terraform HTTP backend
+type testBackend struct {
+	states  map[string]string
+	locked  bool
+	lockID  string
+	postID  string
+	methods []string
+}
+
+func (b *testBackend) Send(req *retryablehttp.Request) (*http.Response, error) {
+	b.methods = append(b.methods, req.Method)
+	body, _ := req.BodyBytes()
+	path := req.URL.Path
+	switch req.Method {
+	case "LOCK":
+		if b.locked {
+			return response(http.StatusLocked, ""), nil
+		}
+		var lock backend.LockInfo
+		if err := json.Unmarshal(body, &lock); err != nil {
+			return response(http.StatusBadRequest, ""), nil
+		}
+		b.locked = true
+		b.lockID = lock.ID
+	case "UNLOCK":
+		b.locked = false
+	case http.MethodGet:
+		state, ok := b.states[path]
+		if !ok {
+			return response(http.StatusNotFound, ""), nil
+		}
+		return response(http.StatusOK, state), nil
+	case http.MethodPost:
+		b.postID = req.URL.Query().Get("ID")
+		b.states[path] = string(body)
+	}
+	return response(http.StatusOK, ""), nil
+}
+
+func response(statusCode int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Body:       io.NopCloser(bytes.NewBufferString(body)),
+		Header:     http.Header{},
+	}
+}
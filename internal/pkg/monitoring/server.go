@@ -16,10 +16,14 @@ package monitoring
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -27,41 +31,101 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
 )
 
 // Server interface to handle a monitoring server
 type Server interface {
-	Start()
+	// Start serves requests until the server is shut down
+	Start() error
+	// MarkShuttingDown lets the readiness probe fail, so no new requests are routed to the service
+	MarkShuttingDown()
+	// Shutdown stops accepting requests and waits for the in-flight requests until the context is done
+	Shutdown(ctx context.Context) error
+}
+
+const (
+	readinessStatusOK           = "ok"
+	readinessStatusFailed       = "failed"
+	readinessStatusShuttingDown = "shutting down"
+
+	checkStatusOK      = "ok"
+	checkStatusFailed  = "failed"
+	checkStatusSkipped = "skipped"
+
+	// maxErrorBodySize limits the part of an unsuccessful backend response reported by the readiness probe
+	maxErrorBodySize = 1024
+)
+
+// readinessResult is the JSON body of the readiness probe
+type readinessResult struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// checkResult is the status of a single readiness check
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newCheckResult(err error) checkResult {
+	switch {
+	case err == nil:
+		return checkResult{Status: checkStatusOK}
+	case errors.Is(err, transformer.ErrNotConfigured):
+		return checkResult{Status: checkStatusSkipped}
+	default:
+		return checkResult{Status: checkStatusFailed, Error: err.Error()}
+	}
 }
 
 // NewMonitoringServer Server using the given server config
-func NewMonitoringServer(config config.ServerConfig, backend backend.Client) Server {
-	return &server{
+func NewMonitoringServer(config config.ServerConfig, backend backend.Client, checker transformer.Checker) Server {
+	s := &server{
 		config:        config,
 		backend:       backend,
+		checker:       checker,
 		requestLogger: config.Logger().Named("frontend"),
+		shuttingDown:  &atomic.Bool{},
 	}
+	monitoringMux := http.NewServeMux()
+	monitoringMux.Handle("/metrics", promhttp.Handler())
+	monitoringMux.HandleFunc("/liveness", s.newLivenessRequestHandler())
+	monitoringMux.HandleFunc("/readiness", s.newReadinessRequestHandler())
+	s.httpServer = &http.Server{
+		Addr:         net.JoinHostPort(config.MonitoringAddress(), config.MonitoringPort()),
+		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  10 * time.Second,
+		Handler:      monitoringMux,
+	}
+	return s
 }
 
 type server struct {
 	config        config.ServerConfig
 	backend       backend.Client
+	checker       transformer.Checker
 	requestLogger hclog.Logger
+	httpServer    *http.Server
+	shuttingDown  *atomic.Bool
 }
 
-func (s server) Start() {
-	monitoringMux := http.NewServeMux()
-	monitoringMux.Handle("/metrics", promhttp.Handler())
-	monitoringMux.HandleFunc("/liveness", s.newLivenessRequestHandler())
-	monitoringMux.HandleFunc("/readiness", s.newReadinessRequestHandler())
-	adminSrv := &http.Server{
-		Addr:         fmt.Sprintf(":%s", "2112"),
-		WriteTimeout: 10 * time.Second,
-		ReadTimeout:  10 * time.Second,
-		Handler:      monitoringMux,
+func (s server) Start() error {
+	s.config.Logger().Info("Start monitoring service", "address", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
-	s.config.Logger().Info("Start monitoring service", "port", "2112")
-	log.Fatal(adminSrv.ListenAndServe())
+	return nil
+}
+
+func (s server) MarkShuttingDown() {
+	s.shuttingDown.Store(true)
+}
+
+func (s server) Shutdown(ctx context.Context) error {
+	s.config.Logger().Info("Shutdown monitoring service")
+	return s.httpServer.Shutdown(ctx)
 }
 
 func (s server) newLivenessRequestHandler() func(http.ResponseWriter, *http.Request) {
@@ -80,7 +144,7 @@ func (s server) newLivenessRequestHandler() func(http.ResponseWriter, *http.Requ
 
 func (s server) newReadinessRequestHandler() func(http.ResponseWriter, *http.Request) {
 	return func(responseWriter http.ResponseWriter, incomingRequest *http.Request) {
-		statusCode := http.StatusInternalServerError
+		statusCode := http.StatusServiceUnavailable
 		defer func() {
 			if flusher, ok := responseWriter.(http.Flusher); ok {
 				s.requestLogger.Trace("Flush response writer")
@@ -88,30 +152,66 @@ func (s server) newReadinessRequestHandler() func(http.ResponseWriter, *http.Req
 			}
 			probeRequestCounter.WithLabelValues("readiness", fmt.Sprint(statusCode)).Inc()
 		}()
-		backendRequest, err := retryablehttp.NewRequest(http.MethodGet, fmt.Sprintf("%s%s", s.config.BackendURL(), "/-/readiness"), []byte{})
-		if err != nil {
-			http.Error(responseWriter, err.Error(), statusCode)
-			return
-		}
-		backendResponse, err := s.backend.Send(backendRequest)
-		if err != nil {
-			http.Error(responseWriter, err.Error(), statusCode)
-			return
+		result := readinessResult{Status: readinessStatusShuttingDown}
+		if !s.shuttingDown.Load() {
+			result = s.checkReadiness(incomingRequest.Context())
 		}
-		responseBody, err := readBody(backendResponse.Body)
-		if err != nil {
-			http.Error(responseWriter, err.Error(), statusCode)
-			return
+		if result.Status == readinessStatusOK {
+			statusCode = http.StatusOK
 		}
-		statusCode = backendResponse.StatusCode
+		responseWriter.Header().Set("Content-Type", "application/json")
 		responseWriter.WriteHeader(statusCode)
-		responseWriter.Write(responseBody)
+		if err := json.NewEncoder(responseWriter).Encode(result); err != nil {
+			s.requestLogger.Error("Can not write readiness response", "err", err)
+		}
+	}
+}
+
+// checkReadiness runs all checks. The service is ready if no check failed.
+func (s server) checkReadiness(ctx context.Context) readinessResult {
+	result := readinessResult{
+		Status: readinessStatusOK,
+		Checks: map[string]checkResult{
+			"backend": newCheckResult(s.checkBackend(ctx)),
+			"age":     newCheckResult(s.checker.CheckAge(s.config)),
+			"vault":   newCheckResult(s.checker.CheckVault(ctx, s.config)),
+		},
+	}
+	for name, check := range result.Checks {
+		if check.Status == checkStatusFailed {
+			s.requestLogger.Warn("Readiness check failed", "check", name, "err", check.Error)
+			result.Status = readinessStatusFailed
+		}
+	}
+	return result
+}
+
+// checkBackend requests the readiness probe path of the backend. The upstream backends of backend routes are not
+// checked.
+func (s server) checkBackend(ctx context.Context) error {
+	if len(s.config.BackendRoutes()) > 0 {
+		return transformer.ErrNotConfigured
+	}
+	backendRequest, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s%s", s.config.BackendURL(), s.config.BackendReadinessProbePath()), []byte{})
+	if err != nil {
+		return err
+	}
+	backendResponse, err := s.backend.Send(backendRequest)
+	if err != nil {
+		return err
+	}
+	defer backendResponse.Body.Close()
+	if backendResponse.StatusCode < 200 || backendResponse.StatusCode > 299 {
+		responseBody, _ := readBody(backendResponse.Body)
+		return fmt.Errorf("backend answered with status code %d: %s", backendResponse.StatusCode, responseBody)
 	}
+	return nil
 }
 
-func readBody(body io.ReadCloser) ([]byte, error) {
+// readBody reads at most maxErrorBodySize bytes of the body
+func readBody(body io.Reader) ([]byte, error) {
 	buffer := bytes.NewBuffer(nil)
-	if _, err := io.Copy(buffer, body); err != nil {
+	if _, err := io.Copy(buffer, io.LimitReader(body, maxErrorBodySize)); err != nil {
 		return nil, err
 	}
 	return buffer.Bytes(), nil
@@ -0,0 +1,188 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
+)
+
+func Test_server_readiness(t *testing.T) {
+	tests := []struct {
+		name              string
+		shuttingDown      bool
+		backendStatusCode int
+		routes            []config.Route
+		ageErr            error
+		vaultErr          error
+		wantStatusCode    int
+		wantResult        readinessResult
+		wantBackendCalls  int
+	}{
+		{
+			name:              "ready",
+			backendStatusCode: http.StatusOK,
+			wantStatusCode:    http.StatusOK,
+			wantResult: readinessResult{Status: "ok", Checks: map[string]checkResult{
+				"backend": {Status: "ok"},
+				"age":     {Status: "ok"},
+				"vault":   {Status: "ok"},
+			}},
+			wantBackendCalls: 1,
+		},
+		{
+			name:              "ready without vault",
+			backendStatusCode: http.StatusOK,
+			vaultErr:          transformer.ErrNotConfigured,
+			wantStatusCode:    http.StatusOK,
+			wantResult: readinessResult{Status: "ok", Checks: map[string]checkResult{
+				"backend": {Status: "ok"},
+				"age":     {Status: "ok"},
+				"vault":   {Status: "skipped"},
+			}},
+			wantBackendCalls: 1,
+		},
+		{
+			name:              "backend not ready",
+			backendStatusCode: http.StatusBadGateway,
+			wantStatusCode:    http.StatusServiceUnavailable,
+			wantResult: readinessResult{Status: "failed", Checks: map[string]checkResult{
+				"backend": {Status: "failed", Error: "backend answered with status code 502: not ready"},
+				"age":     {Status: "ok"},
+				"vault":   {Status: "ok"},
+			}},
+			wantBackendCalls: 1,
+		},
+		{
+			name:              "vault login failed",
+			backendStatusCode: http.StatusOK,
+			vaultErr:          errors.New("vault login failed"),
+			wantStatusCode:    http.StatusServiceUnavailable,
+			wantResult: readinessResult{Status: "failed", Checks: map[string]checkResult{
+				"backend": {Status: "ok"},
+				"age":     {Status: "ok"},
+				"vault":   {Status: "failed", Error: "vault login failed"},
+			}},
+			wantBackendCalls: 1,
+		},
+		{
+			name:              "invalid age key",
+			backendStatusCode: http.StatusOK,
+			ageErr:            errors.New("can not parse AGE private key"),
+			wantStatusCode:    http.StatusServiceUnavailable,
+			wantResult: readinessResult{Status: "failed", Checks: map[string]checkResult{
+				"backend": {Status: "ok"},
+				"age":     {Status: "failed", Error: "can not parse AGE private key"},
+				"vault":   {Status: "ok"},
+			}},
+			wantBackendCalls: 1,
+		},
+		{
+			name:           "backend routes",
+			routes:         []config.Route{{Name: "team-a", PathPrefix: "/team-a", URL: "https://team-a.test"}},
+			wantStatusCode: http.StatusOK,
+			wantResult: readinessResult{Status: "ok", Checks: map[string]checkResult{
+				"backend": {Status: "skipped"},
+				"age":     {Status: "ok"},
+				"vault":   {Status: "ok"},
+			}},
+		},
+		{
+			name:           "shutting down",
+			shuttingDown:   true,
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantResult:     readinessResult{Status: "shutting down"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &testBackend{statusCode: tt.backendStatusCode}
+			s := NewMonitoringServer(testConfig{routes: tt.routes}, backend, testChecker{ageErr: tt.ageErr, vaultErr: tt.vaultErr})
+			if tt.shuttingDown {
+				s.MarkShuttingDown()
+			}
+			recorder := httptest.NewRecorder()
+
+			s.(*server).newReadinessRequestHandler()(recorder, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+
+			var gotResult readinessResult
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &gotResult))
+			assert.Equal(t, tt.wantStatusCode, recorder.Code)
+			assert.Equal(t, tt.wantResult, gotResult)
+			assert.Equal(t, tt.wantBackendCalls, len(backend.paths))
+			for _, path := range backend.paths {
+				assert.Equal(t, "https://backend.test/-/ready", path)
+			}
+		})
+	}
+}
+
+type testConfig struct {
+	config.ServerConfig
+	routes []config.Route
+}
+
+func (testConfig) BackendURL() string                { return "https://backend.test" }
+func (testConfig) BackendReadinessProbePath() string { return "/-/ready" }
+func (testConfig) MonitoringAddress() string         { return "127.0.0.1" }
+func (testConfig) MonitoringPort() string            { return "2112" }
+func (c testConfig) BackendRoutes() []config.Route {
+	return c.routes
+}
+func (testConfig) Logger() hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{Name: "unit-test", Level: hclog.Trace, Output: os.Stderr})
+}
+
+type testChecker struct {
+	ageErr   error
+	vaultErr error
+}
+
+func (c testChecker) CheckAge(_ config.TransformConfig) error { return c.ageErr }
+func (c testChecker) CheckVault(_ context.Context, _ config.TransformConfig) error {
+	return c.vaultErr
+}
+
+type testBackend struct {
+	statusCode int
+	paths      []string
+}
+
+func (b *testBackend) Send(req *retryablehttp.Request) (*http.Response, error) {
+	b.paths = append(b.paths, req.URL.String())
+	body := "ready"
+	if b.statusCode != http.StatusOK {
+		body = "not ready"
+	}
+	return &http.Response{
+		StatusCode: b.statusCode,
+		Body:       io.NopCloser(bytes.NewBufferString(body)),
+		Header:     http.Header{},
+	}, nil
+}
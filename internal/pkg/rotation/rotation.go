@@ -0,0 +1,131 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rotation
+
+import (
+	"fmt"
+
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
+)
+
+// Status of the rotation of a single terraform state
+type Status string
+
+const (
+	// StatusRotated the state has been re-encrypted with the current master keys
+	StatusRotated Status = "rotated"
+	// StatusDryRun the state can be re-encrypted but has not been written back
+	StatusDryRun Status = "dry-run"
+	// StatusSkipped the backend has no state for the path
+	StatusSkipped Status = "skipped"
+	// StatusFailed the state could not be re-encrypted
+	StatusFailed Status = "failed"
+
+	lockOperation = "rotate"
+)
+
+// Result reports the rotation of a single terraform state
+type Result struct {
+	Path   string
+	Status Status
+	Err    error
+}
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: %s (%s)", r.Path, r.Status, r.Err)
+	}
+	return fmt.Sprintf("%s: %s", r.Path, r.Status)
+}
+
+// Rotator re-encrypts the terraform states of the backend with the currently configured master keys. The path of a
+// state selects its creation rule, the backend path addresses it at the backend.
+type Rotator interface {
+	Rotate(path, backendPath string) Result
+}
+
+// New Rotator using the given server config. A dry run reads, decrypts and encrypts the states, but neither locks
+// nor writes them.
+func New(config config.ServerConfig, backendClient backend.Client, transformer transformer.SOPSTransformer, dryRun bool) Rotator {
+	return &rotator{
+		config:      config,
+		states:      backend.NewStateClient(config, backendClient, lockOperation),
+		transformer: transformer,
+		dryRun:      dryRun,
+	}
+}
+
+type rotator struct {
+	config      config.ServerConfig
+	states      backend.StateClient
+	transformer transformer.SOPSTransformer
+	dryRun      bool
+}
+
+func (r rotator) Rotate(path, backendPath string) (result Result) {
+	result = Result{Path: path}
+	var lock *backend.LockInfo
+	if !r.dryRun {
+		var err error
+		if lock, err = r.states.Lock(backendPath); err != nil {
+			return failed(result, fmt.Errorf("can not lock state: %w", err))
+		}
+		defer func() {
+			if err := r.states.Unlock(backendPath, lock); err != nil && result.Err == nil {
+				result = failed(result, fmt.Errorf("can not unlock state: %w", err))
+			}
+		}()
+	}
+
+	state, err := r.states.Get(backendPath)
+	if err != nil {
+		return failed(result, err)
+	}
+	if len(state) == 0 {
+		result.Status = StatusSkipped
+		return result
+	}
+
+	var plain []byte
+	if err := r.transformer.FromSops(r.config, state, func(result []byte) error { plain = result; return nil }); err != nil {
+		return failed(result, fmt.Errorf("can not decrypt state: %w", err))
+	}
+	var encrypted []byte
+	if err := r.transformer.ToSops(r.config, path, plain, func(result []byte) { encrypted = result }); err != nil {
+		return failed(result, fmt.Errorf("can not encrypt state: %w", err))
+	}
+	if err := transformer.DetectLeaks(plain, encrypted); err != nil {
+		transformer.AlertPlaintextLeak(lockOperation)
+		return failed(result, err)
+	}
+	if r.dryRun {
+		result.Status = StatusDryRun
+		return result
+	}
+
+	if err := r.states.Post(backendPath, lock, encrypted); err != nil {
+		return failed(result, err)
+	}
+	result.Status = StatusRotated
+	return result
+}
+
+func failed(result Result, err error) Result {
+	result.Status = StatusFailed
+	result.Err = err
+	return result
+}
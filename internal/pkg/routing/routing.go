@@ -0,0 +1,162 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+// ErrNoRoute is returned if no route matches the host and path of a request
+var ErrNoRoute = errors.New("no backend route matches the request")
+
+// Route forwards requests to an upstream terraform HTTP backend
+type Route struct {
+	// Name of the route, empty for the backend of the server config
+	Name string
+	// Config is the server config with the backend URL, mTLS material, lock methods and key groups of the route
+	Config config.ServerConfig
+	// Backend sends the requests of the route
+	Backend    backend.Client
+	host       string
+	pathPrefix string
+}
+
+// UpstreamPath returns the path of the request at the upstream backend without the path prefix of the route
+func (r Route) UpstreamPath(path string) string {
+	return strings.TrimPrefix(path, r.pathPrefix)
+}
+
+func (r Route) matches(host, path string) bool {
+	if r.host != "" && !strings.EqualFold(r.host, hostname(host)) {
+		return false
+	}
+	return r.pathPrefix == "" || path == r.pathPrefix || strings.HasPrefix(path, r.pathPrefix+"/")
+}
+
+// Table selects the route of a request. The zero Table has no routes.
+type Table struct {
+	routes       []Route
+	defaultRoute Route
+}
+
+// New creates the routing table of the configured backend routes. Without backend routes every request takes the
+// backend of the server config.
+func New(serverConfig config.ServerConfig, defaultBackend backend.Client) (Table, error) {
+	table := Table{defaultRoute: Route{Config: serverConfig, Backend: defaultBackend}}
+	for _, route := range serverConfig.BackendRoutes() {
+		routeConfig := routeConfig{ServerConfig: serverConfig, route: route}
+		routeBackend, err := backend.New(routeConfig)
+		if err != nil {
+			return Table{}, err
+		}
+		table.routes = append(table.routes, Route{
+			Name:       route.Name,
+			Config:     routeConfig,
+			Backend:    routeBackend,
+			host:       route.Host,
+			pathPrefix: strings.TrimSuffix(route.PathPrefix, "/"),
+		})
+	}
+	return table, nil
+}
+
+// Enabled returns true if the table has backend routes
+func (t Table) Enabled() bool {
+	return len(t.routes) > 0
+}
+
+// Match returns the first route, which matches the host and the path. Routes with a host do not match an empty host.
+func (t Table) Match(host, path string) (Route, error) {
+	if !t.Enabled() {
+		return t.defaultRoute, nil
+	}
+	for _, route := range t.routes {
+		if route.matches(host, path) {
+			return route, nil
+		}
+	}
+	return Route{}, ErrNoRoute
+}
+
+// hostname removes the port of the host header
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return host
+}
+
+// routeConfig replaces the backend and key groups of the server config with the ones of the route
+type routeConfig struct {
+	config.ServerConfig
+	route config.Route
+}
+
+func (c routeConfig) BackendURL() string { return c.route.URL }
+
+func (c routeConfig) BackendRoutes() []config.Route { return nil }
+
+func (c routeConfig) BackendMTLSCert() []byte {
+	if len(c.route.MTLSCert) > 0 {
+		return c.route.MTLSCert
+	}
+	return c.ServerConfig.BackendMTLSCert()
+}
+
+func (c routeConfig) BackendMTLSKey() []byte {
+	if len(c.route.MTLSKey) > 0 {
+		return c.route.MTLSKey
+	}
+	return c.ServerConfig.BackendMTLSKey()
+}
+
+func (c routeConfig) BackendLockMethod() string {
+	if c.route.LockMethod != "" {
+		return c.route.LockMethod
+	}
+	return c.ServerConfig.BackendLockMethod()
+}
+
+func (c routeConfig) BackendUnlockMethod() string {
+	if c.route.UnlockMethod != "" {
+		return c.route.UnlockMethod
+	}
+	return c.ServerConfig.BackendUnlockMethod()
+}
+
+func (c routeConfig) KeyGroups() []config.KeyGroup {
+	if c.route.KeyGroup == "" {
+		return c.ServerConfig.KeyGroups()
+	}
+	for _, keyGroup := range c.ServerConfig.KeyGroups() {
+		if keyGroup.Name == c.route.KeyGroup {
+			return []config.KeyGroup{keyGroup}
+		}
+	}
+	return nil
+}
+
+// ShamirThreshold is not applicable to the single key group of a route
+func (c routeConfig) ShamirThreshold() int {
+	if c.route.KeyGroup == "" {
+		return c.ServerConfig.ShamirThreshold()
+	}
+	return 0
+}
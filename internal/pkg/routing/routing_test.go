@@ -0,0 +1,134 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+func TestTable_Match(t *testing.T) {
+	table, err := New(testConfig{routes: []config.Route{
+		{Name: "host", Host: "team-a.example.com", URL: "https://team-a.test"},
+		{Name: "host-and-prefix", Host: "team-b.example.com", PathPrefix: "/states", URL: "https://team-b.test"},
+		{Name: "prefix", PathPrefix: "/team-c/", URL: "https://team-c.test"},
+	}}, nil)
+	require.NoError(t, err)
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		wantName string
+		wantPath string
+		wantErr  error
+	}{
+		{name: "host", host: "team-a.example.com", path: "/states/a", wantName: "host", wantPath: "/states/a"},
+		{name: "host with port", host: "Team-A.example.com:8080", path: "/states/a", wantName: "host", wantPath: "/states/a"},
+		{name: "host and prefix", host: "team-b.example.com", path: "/states/b", wantName: "host-and-prefix", wantPath: "/b"},
+		{name: "host without prefix", host: "team-b.example.com", path: "/other/b", wantErr: ErrNoRoute},
+		{name: "prefix", path: "/team-c/states/c", wantName: "prefix", wantPath: "/states/c"},
+		{name: "prefix is the path", path: "/team-c", wantName: "prefix", wantPath: ""},
+		{name: "prefix is no path segment", path: "/team-cd/states/c", wantErr: ErrNoRoute},
+		{name: "first match", host: "team-a.example.com", path: "/team-c/states/c", wantName: "host", wantPath: "/team-c/states/c"},
+		{name: "no match", host: "other.example.com", path: "/states/a", wantErr: ErrNoRoute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := table.Match(tt.host, tt.path)
+
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "error %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantName, got.Name)
+			assert.Equal(t, tt.wantPath, got.UpstreamPath(tt.path))
+			assert.NotNil(t, got.Backend)
+		})
+	}
+}
+
+func TestTable_Match_disabled(t *testing.T) {
+	serverConfig := testConfig{}
+	table, err := New(serverConfig, nil)
+	require.NoError(t, err)
+
+	got, err := table.Match("team-a.example.com", "/states/a")
+
+	require.NoError(t, err)
+	assert.False(t, table.Enabled())
+	assert.Equal(t, serverConfig, got.Config)
+	assert.Equal(t, "/states/a", got.UpstreamPath("/states/a"))
+}
+
+func Test_routeConfig(t *testing.T) {
+	keyGroups := []config.KeyGroup{{Name: "a"}, {Name: "b"}}
+	serverConfig := testConfig{keyGroups: keyGroups, mtlsCert: []byte("cert"), mtlsKey: []byte("key")}
+
+	defaults := routeConfig{ServerConfig: serverConfig, route: config.Route{Name: "defaults", URL: "https://team-a.test"}}
+	assert.Equal(t, "https://team-a.test", defaults.BackendURL())
+	assert.Equal(t, []byte("cert"), defaults.BackendMTLSCert())
+	assert.Equal(t, []byte("key"), defaults.BackendMTLSKey())
+	assert.Equal(t, "LOCK", defaults.BackendLockMethod())
+	assert.Equal(t, "UNLOCK", defaults.BackendUnlockMethod())
+	assert.Equal(t, keyGroups, defaults.KeyGroups())
+	assert.Equal(t, 2, defaults.ShamirThreshold())
+	assert.Empty(t, defaults.BackendRoutes())
+
+	overrides := routeConfig{ServerConfig: serverConfig, route: config.Route{
+		Name:         "overrides",
+		URL:          "https://team-b.test",
+		MTLSCert:     []byte("route cert"),
+		MTLSKey:      []byte("route key"),
+		LockMethod:   "PUT",
+		UnlockMethod: "DELETE",
+		KeyGroup:     "b",
+	}}
+	assert.Equal(t, []byte("route cert"), overrides.BackendMTLSCert())
+	assert.Equal(t, []byte("route key"), overrides.BackendMTLSKey())
+	assert.Equal(t, "PUT", overrides.BackendLockMethod())
+	assert.Equal(t, "DELETE", overrides.BackendUnlockMethod())
+	assert.Equal(t, []config.KeyGroup{{Name: "b"}}, overrides.KeyGroups())
+	assert.Equal(t, 0, overrides.ShamirThreshold())
+}
+
+type testConfig struct {
+	config.ServerConfig
+	routes    []config.Route
+	keyGroups []config.KeyGroup
+	mtlsCert  []byte
+	mtlsKey   []byte
+}
+
+func (c testConfig) BackendRoutes() []config.Route { return c.routes }
+func (c testConfig) BackendType() string           { return config.BackendTypeHTTP }
+func (c testConfig) BackendMTLSCert() []byte       { return c.mtlsCert }
+func (c testConfig) BackendMTLSKey() []byte        { return c.mtlsKey }
+func (c testConfig) BackendUsername() string       { return "" }
+func (c testConfig) BackendPassword() string       { return "" }
+func (c testConfig) BackendToken() string          { return "" }
+func (c testConfig) BackendLockMethod() string     { return "LOCK" }
+func (c testConfig) BackendUnlockMethod() string   { return "UNLOCK" }
+func (c testConfig) KeyGroups() []config.KeyGroup  { return c.keyGroups }
+func (c testConfig) ShamirThreshold() int          { return 2 }
+func (c testConfig) Logger() hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{Name: "unit-test", Level: hclog.Trace, Output: os.Stderr})
+}
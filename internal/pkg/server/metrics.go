@@ -35,4 +35,32 @@ var (
 		},
 		[]string{"group", "path"},
 	)
+	authFailureCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_auth_failure_counter",
+			Help: "Counter Vector of rejected requests by reason",
+		},
+		[]string{"reason"},
+	)
+	decryptOutcomeCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_decrypt_outcome_counter",
+			Help: "Counter Vector of terraform states read from the backend by decrypt outcome",
+		},
+		[]string{"outcome"},
+	)
+	migrationCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_migration_counter",
+			Help: "Counter Vector of plaintext terraform states read from the backend by migration status",
+		},
+		[]string{"status"},
+	)
+	writeGuardCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_write_guard_counter",
+			Help: "Counter Vector of terraform states written by POST requests by write guard outcome",
+		},
+		[]string{"outcome"},
+	)
 )
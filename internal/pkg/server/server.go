@@ -16,35 +16,93 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/audit"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/auth"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/cache"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/migration"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/routing"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
 )
 
 // Server interface to handle a terraform SOPS backend server
 type Server interface {
-	Start()
+	// Start serves requests until the server is shut down
+	Start() error
+	// Shutdown stops accepting requests and waits for the in-flight requests until the context is done
+	Shutdown(ctx context.Context) error
 }
 
-// New Server using the given server config
-func New(config config.ServerConfig, backend backend.Client, transformer transformer.SOPSTransformer) Server {
-	return &server{
+// New Server using the given server config. Without authorizer every request is forwarded to the backend. With backend
+// routes every request is forwarded to the upstream backend of its route instead. Without auditor no audit records
+// are written.
+func New(config config.ServerConfig, backend backend.Client, routes routing.Table, transformer transformer.SOPSTransformer, authorizer auth.Authorizer, auditor audit.Auditor) Server {
+	s := &server{
 		config:        config,
 		backend:       backend,
+		routes:        routes,
 		transformer:   transformer,
+		authorizer:    authorizer,
+		auditor:       auditor,
+		migrator:      newMigrator(config, backend, transformer),
+		stateCache:    newStateCache(config),
 		requestLogger: config.Logger().Named("frontend"),
 	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.newRequestHandler())
+	s.httpServer = &http.Server{
+		Addr:         fmt.Sprintf(":%s", config.ServerPort()),
+		Handler:      mux,
+		ReadTimeout:  config.ServerReadTimeout(),
+		WriteTimeout: config.ServerWriteTimeout(),
+		ErrorLog:     config.Logger().Named("frontend").StandardLogger(&hclog.StandardLoggerOptions{InferLevels: true}),
+	}
+	return s
 }
 
+// newMigrator returns the migrator of the migration mode or nil if plaintext states are not migrated
+func newMigrator(serverConfig config.ServerConfig, backend backend.Client, transformer transformer.SOPSTransformer) migration.Migrator {
+	switch serverConfig.MigrationMode() {
+	case config.MigrationModeDetect:
+		return migration.New(serverConfig, backend, transformer, true)
+	case config.MigrationModeEncrypt:
+		return migration.New(serverConfig, backend, transformer, false)
+	default:
+		return nil
+	}
+}
+
+// newStateCache returns the cache of decrypted states or nil if the cache is disabled
+func newStateCache(serverConfig config.ServerConfig) *cache.Cache {
+	if serverConfig.CacheMaxSize() == 0 {
+		return nil
+	}
+	return cache.New("state", serverConfig.CacheMaxSize(), serverConfig.CacheTTL())
+}
+
+const (
+	decryptOutcomeDecrypted            = "decrypted"
+	decryptOutcomeMigrated             = "migrated"
+	decryptOutcomePassthrough          = "passthrough"
+	decryptOutcomePassthroughPlaintext = "passthrough_plaintext"
+	decryptOutcomeRejected             = "rejected"
+)
+
 const (
 	methodGet    = "GET"
 	methodPost   = "POST"
@@ -53,7 +111,9 @@ const (
 )
 
 var (
-	ignoredRequestHeaders  = ignoredHeaders{}
+	ignoredRequestHeaders = ignoredHeaders{
+		forceWriteHeader: 0,
+	}
 	ignoredResponseHeaders = ignoredHeaders{
 		"Content-Length": 0,
 	}
@@ -63,23 +123,75 @@ var (
 		methodLock:   0,
 		methodUnlock: 0,
 	}
+	requiredPermissions = map[string]auth.Permission{
+		methodGet:    auth.PermissionRead,
+		methodPost:   auth.PermissionWrite,
+		methodLock:   auth.PermissionLock,
+		methodUnlock: auth.PermissionLock,
+	}
 )
 
+// errBodyTooLarge is returned if a body exceeds the maximum body size
+var errBodyTooLarge = errors.New("body exceeds the maximum body size")
+
 type ignoredHeaders map[string]int
 type supportedMethods map[string]int
 
 type server struct {
 	config        config.ServerConfig
 	backend       backend.Client
+	routes        routing.Table
+	route         *routing.Route
 	transformer   transformer.SOPSTransformer
+	authorizer    auth.Authorizer
+	auditor       audit.Auditor
+	migrator      migration.Migrator
+	stateCache    *cache.Cache
 	requestLogger hclog.Logger
+	httpServer    *http.Server
 }
 
-func (s server) Start() {
-	http.HandleFunc("/", s.newRequestHandler())
+func (s server) Start() error {
 	s.config.Logger().Trace("Used configuration", "config", s.config.String())
-	s.config.Logger().Info("Start service", "port", s.config.ServerPort(), "vault_addr", s.config.VaultAddr(), "has_private_age_key", len(s.config.AgePrivateKey()) > 0)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", s.config.ServerPort()), nil))
+	s.config.Logger().Info("Start service", "port", s.config.ServerPort(), "vault_addr", s.config.VaultAddr(), "has_private_age_key", len(s.config.AgePrivateKey()) > 0, "tls", s.config.ServerTLSCertFile() != "", "mtls", s.config.ServerTLSClientCAFile() != "")
+	if s.config.ServerTLSCertFile() != "" {
+		tlsConfig, err := newTLSConfig(s.config, s.config.Logger().Named("tls"))
+		if err != nil {
+			return err
+		}
+		s.httpServer.TLSConfig = tlsConfig
+	}
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(listener)
+}
+
+// serve handles the requests of the listener until the server is shut down
+func (s server) serve(listener net.Listener) error {
+	var err error
+	if s.httpServer.TLSConfig == nil {
+		err = s.httpServer.Serve(listener)
+	} else {
+		// the certificate is provided by the TLS configuration
+		err = s.httpServer.ServeTLS(listener, "", "")
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s server) Shutdown(ctx context.Context) error {
+	s.config.Logger().Info("Shutdown service, wait for in-flight requests")
+	err := s.httpServer.Shutdown(ctx)
+	if s.auditor != nil {
+		if closeErr := s.auditor.Close(); closeErr != nil {
+			s.config.Logger().Error("Can not close audit sinks", "err", closeErr)
+		}
+	}
+	return err
 }
 
 func (s server) newRequestHandler() func(http.ResponseWriter, *http.Request) {
@@ -96,28 +208,173 @@ func (s server) newRequestHandler() func(http.ResponseWriter, *http.Request) {
 			s.requestLogger.Debug("incoming request", "method", incomingRequest.Method, "uri", buildIncomingURI(incomingRequest.URL))
 		}
 
+		record := newAuditRecord(incomingRequest)
+		if s.auditor != nil {
+			auditWriter := &auditResponseWriter{ResponseWriter: responseWriter}
+			responseWriter = auditWriter
+			defer func() {
+				record.Status = auditWriter.status()
+				s.auditor.Audit(record)
+			}()
+		}
+
 		if !isSupportedRequestMethod(incomingRequest.Method) {
 			s.writeErrorResponse(responseWriter, "Method Not Allowed", http.StatusMethodNotAllowed, incomingRequest.Method, incomingRequest.URL.Path, nil, "Method Not Allowed")
 			return
 		}
 
-		backendRequest, err := s.buildBackendRequest(incomingRequest)
+		if s.authorizer != nil {
+			identity, ok := s.authorize(responseWriter, incomingRequest)
+			if identity != "" {
+				record.Identity, record.Authenticated = identity, true
+			}
+			if !ok {
+				return
+			}
+		}
+
+		routed, err := s.routed(incomingRequest)
 		if err != nil {
-			s.writeErrorResponse(responseWriter, err.Error(), http.StatusInternalServerError, incomingRequest.Method, incomingRequest.URL.Path, err, "Can not build backend request")
+			s.writeErrorResponse(responseWriter, fmt.Sprintf("Not Found: %s", err), http.StatusNotFound, incomingRequest.Method, incomingRequest.URL.Path, err, "No backend route")
 			return
 		}
+		if routed.route != nil {
+			record.Route = routed.route.Name
+		}
 
-		backendResponse, err := s.backend.Send(backendRequest)
+		backendRequest, err := routed.buildBackendRequest(incomingRequest)
+		if errors.Is(err, errBodyTooLarge) {
+			routed.writeErrorResponse(responseWriter, "Request Entity Too Large", http.StatusRequestEntityTooLarge, incomingRequest.Method, incomingRequest.URL.Path, err, "Request body too large")
+			return
+		}
+		if errors.Is(err, transformer.ErrPlaintextLeak) {
+			routed.writeErrorResponse(responseWriter, fmt.Sprintf("Internal Server Error: %s", err), http.StatusInternalServerError, incomingRequest.Method, incomingRequest.URL.Path, err, "Encrypted state leaks sensitive values")
+			return
+		}
+		if errors.Is(err, errStateConflict) {
+			routed.writeErrorResponse(responseWriter, fmt.Sprintf("Conflict: %s", err), http.StatusConflict, incomingRequest.Method, incomingRequest.URL.Path, err, "State conflicts with the stored state")
+			return
+		}
+		if err != nil {
+			routed.writeErrorResponse(responseWriter, err.Error(), http.StatusInternalServerError, incomingRequest.Method, incomingRequest.URL.Path, err, "Can not build backend request")
+			return
+		}
+
+		if incomingRequest.Method == methodPost && routed.auditor != nil {
+			routed.auditState(&record, backendRequest)
+		}
+
+		backendResponse, err := routed.backend.Send(backendRequest)
 		if err != nil {
-			s.writeErrorResponse(responseWriter, err.Error(), http.StatusInternalServerError, incomingRequest.Method, incomingRequest.URL.Path, err, "Can not perform backend request")
+			routed.writeErrorResponse(responseWriter, err.Error(), http.StatusInternalServerError, incomingRequest.Method, incomingRequest.URL.Path, err, "Can not perform backend request")
 			return
 		}
-		s.writeResponse(responseWriter, backendResponse, incomingRequest.Method, incomingRequest.URL.Path)
+		record.UpstreamStatus = backendResponse.StatusCode
+		if incomingRequest.Method == methodPost && routed.stateCache != nil {
+			// the cached state is outdated, it is removed right away instead of on the next GET request
+			routed.stateCache.Delete(routed.stateCacheKey(incomingRequest.URL.Path))
+		}
+		routed.writeResponse(responseWriter, backendResponse, incomingRequest.Method, incomingRequest.URL.Path)
+	}
+}
+
+// authorize checks the credentials of the request and writes the error response if the request is not allowed. The
+// credentials are removed from the request, as they are meant for this service and not for the backend, unless they
+// have to be forwarded to the backend as well. The identity is returned for authenticated requests, even if they are
+// forbidden.
+func (s server) authorize(responseWriter http.ResponseWriter, incomingRequest *http.Request) (string, bool) {
+	identity, err := s.authorizer.Authorize(incomingRequest, requiredPermissions[incomingRequest.Method])
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		authFailureCounter.WithLabelValues("unauthenticated").Inc()
+		responseWriter.Header().Set("WWW-Authenticate", s.authorizer.Challenge())
+		s.writeErrorResponse(responseWriter, "Unauthorized", http.StatusUnauthorized, incomingRequest.Method, incomingRequest.URL.Path, err, "Unauthenticated request")
+		return "", false
+	}
+	if err != nil {
+		authFailureCounter.WithLabelValues("forbidden").Inc()
+		s.writeErrorResponse(responseWriter, "Forbidden", http.StatusForbidden, incomingRequest.Method, incomingRequest.URL.Path, err, "Forbidden request")
+		return identity, false
+	}
+	if s.requestLogger.IsDebug() {
+		s.requestLogger.Debug("authorized incoming request", "identity", identity, "method", incomingRequest.Method, "path", incomingRequest.URL.Path)
+	}
+	if !s.config.AuthForwardCredentials() {
+		incomingRequest.Header.Del("Authorization")
+	}
+	return identity, true
+}
+
+// newAuditRecord returns the audit record of the request. The record is unauthenticated and has no identity until the
+// authorizer verified the credentials of the request, as unverified credentials may claim any identity.
+func newAuditRecord(incomingRequest *http.Request) audit.Record {
+	record := audit.Record{
+		Time:       time.Now().UTC(),
+		Method:     incomingRequest.Method,
+		Path:       incomingRequest.URL.Path,
+		RemoteAddr: incomingRequest.RemoteAddr,
+	}
+	if incomingRequest.TLS != nil {
+		if len(incomingRequest.TLS.VerifiedChains) > 0 && len(incomingRequest.TLS.VerifiedChains[0]) > 0 {
+			record.ClientCertSubject = incomingRequest.TLS.VerifiedChains[0][0].Subject.String()
+		} else if len(incomingRequest.TLS.PeerCertificates) > 0 {
+			record.ClientCertSubject = incomingRequest.TLS.PeerCertificates[0].Subject.String()
+		}
+	}
+	return record
+}
+
+// auditState records the serial, lineage and key fingerprints of the encrypted state of the backend request
+func (s server) auditState(record *audit.Record, backendRequest *retryablehttp.Request) {
+	state, err := backendRequest.BodyBytes()
+	if err != nil || len(state) == 0 {
+		return
+	}
+	record.SetState(state)
+	fingerprints, err := transformer.KeyFingerprints(state)
+	if err != nil {
+		s.requestLogger.Debug("Can not read key fingerprints of state", "path", record.Path, "error", err)
+		return
+	}
+	record.KeyFingerprints = fingerprints
+}
+
+// routed returns the server for the route of the request. Without backend routes the server itself is returned. The
+// migrator of the returned server acts with the credentials of the request, as the backend may require them.
+func (s server) routed(incomingRequest *http.Request) (server, error) {
+	if s.routes.Enabled() {
+		route, err := s.routes.Match(incomingRequest.Host, incomingRequest.URL.Path)
+		if err != nil {
+			return s, fmt.Errorf("%w: host %q, path %q", err, incomingRequest.Host, incomingRequest.URL.Path)
+		}
+		s.config = route.Config
+		s.backend = route.Backend
+		s.route = &route
+		s.requestLogger = s.requestLogger.With("route", route.Name)
+	}
+	if s.migrator != nil && incomingRequest.Method == methodGet {
+		s.migrator = newMigrator(s.config, backend.WithAuthorization(s.backend, incomingRequest.Header.Get("Authorization")), s.transformer)
 	}
+	return s, nil
+}
+
+// upstreamPath returns the path of the request at the backend
+func (s server) upstreamPath(incomingPath string) string {
+	if s.route == nil {
+		return incomingPath
+	}
+	return s.route.UpstreamPath(incomingPath)
+}
+
+// stateCacheKey returns the key of the state of the path in the state cache, which differs between routes
+func (s server) stateCacheKey(incomingPath string) string {
+	if s.route == nil {
+		return incomingPath
+	}
+	return s.route.Name + "|" + incomingPath
 }
 
 func (s server) buildBackendRequest(incomingRequest *http.Request) (*retryablehttp.Request, error) {
-	body, err := readBody(incomingRequest.Body)
+	body, err := readBody(incomingRequest.Body, incomingRequest.ContentLength, s.config.ServerMaxBodySize())
 	if err != nil {
 		return nil, err
 	}
@@ -127,11 +384,19 @@ func (s server) buildBackendRequest(incomingRequest *http.Request) (*retryableht
 	} else if method == methodUnlock {
 		method = s.config.BackendUnlockMethod()
 	} else if method == methodPost && len(body) > 0 {
-		if err := s.transformer.ToSops(s.config, body, func(result []byte) { body = result }); err != nil {
+		if err := s.guardWrite(incomingRequest, body); err != nil {
+			return nil, err
+		}
+		plaintext := body
+		if err := s.transformer.ToSops(s.config, incomingRequest.URL.Path, body, func(result []byte) { body = result }); err != nil {
+			return nil, err
+		}
+		if err := transformer.DetectLeaks(plaintext, body); err != nil {
+			transformer.AlertPlaintextLeak(methodPost)
 			return nil, err
 		}
 	}
-	backendRequest, err := retryablehttp.NewRequest(method, fmt.Sprintf("%s%s", s.config.BackendURL(), incomingRequest.URL.Path), body)
+	backendRequest, err := retryablehttp.NewRequest(method, fmt.Sprintf("%s%s", s.config.BackendURL(), s.upstreamPath(incomingRequest.URL.Path)), body)
 	if err != nil {
 		return nil, err
 	}
@@ -147,25 +412,173 @@ func (s server) writeResponse(responseWriter http.ResponseWriter, backendRespons
 			flusher.Flush()
 		}
 	}()
-	copyHeader(backendResponse.Header, responseWriter.Header(), ignoredResponseHeaders)
-	responseBody, err := readBody(backendResponse.Body)
+	defer backendResponse.Body.Close()
+	if requestMethod != methodGet {
+		// only terraform states need to be transformed, all other bodies are passed on without buffering
+		s.writeResponseHeader(responseWriter, backendResponse, incomingPath)
+		if _, err := io.Copy(responseWriter, backendResponse.Body); err != nil {
+			s.requestLogger.Warn("Can not pass on backend response body", "method", requestMethod, "error", err)
+		}
+		return
+	}
+	responseBody, err := readBody(backendResponse.Body, backendResponse.ContentLength, s.config.ServerMaxBodySize())
 	if err != nil {
-		s.writeErrorResponse(responseWriter, err.Error(), http.StatusInternalServerError, requestMethod, incomingPath, err, "Can not read backend response body")
+		code := http.StatusInternalServerError
+		if errors.Is(err, errBodyTooLarge) {
+			code = http.StatusBadGateway
+		}
+		copyHeader(backendResponse.Header, responseWriter.Header(), ignoredResponseHeaders)
+		s.writeErrorResponse(responseWriter, err.Error(), code, requestMethod, incomingPath, err, "Can not read backend response body")
 		return
 	}
-	if requestMethod == methodGet && len(responseBody) > 0 {
-		s.requestLogger.Trace("Decrypt response body with", "length", len(responseBody))
-		if err := s.transformer.FromSops(s.config, responseBody, func(result []byte) error { responseBody = result; return nil }); err != nil {
-			s.requestLogger.Warn("Can not decrypt body. Leave body unchanged", "error", err)
+	if len(responseBody) > 0 {
+		version := s.stateVersion(backendResponse, responseBody)
+		cachedState, cached := s.cachedState(incomingPath, version)
+		if cached {
+			responseBody = cachedState
+		} else {
+			s.requestLogger.Trace("Decrypt response body with", "length", len(responseBody))
+			err = s.transformer.FromSops(s.config, responseBody, func(result []byte) error { responseBody = result; return nil })
+		}
+		if err != nil && backendResponse.StatusCode/100 == 2 {
+			s.flagPlaintextState(responseBody, incomingPath)
+		}
+		switch {
+		case backendResponse.StatusCode/100 != 2:
+			// error responses of the backend are no terraform states
+			if err != nil {
+				s.requestLogger.Debug("Can not decrypt body of unsuccessful response. Leave body unchanged", "status-code", backendResponse.StatusCode, "error", err)
+			}
+		case err != nil && s.migrate(responseBody, incomingPath):
+			decryptOutcomeCounter.WithLabelValues(decryptOutcomeMigrated).Inc()
+		case err != nil:
+			if !s.passDecryptFailure(responseBody, incomingPath, err) {
+				s.writeDecryptFailureResponse(responseWriter, incomingPath, err)
+				return
+			}
+		default:
+			decryptOutcomeCounter.WithLabelValues(decryptOutcomeDecrypted).Inc()
+			if !cached && version != "" {
+				s.stateCache.Put(s.stateCacheKey(incomingPath), version, responseBody)
+			}
 		}
 		s.requestLogger.Trace("Decrypted response body with", "length", len(responseBody))
 	}
+	s.writeResponseHeader(responseWriter, backendResponse, incomingPath)
+	responseWriter.Write(responseBody)
+}
+
+func (s server) writeResponseHeader(responseWriter http.ResponseWriter, backendResponse *http.Response, incomingPath string) {
 	if backendResponse.StatusCode/100 != 2 {
 		s.requestLogger.Warn("Unexpected backendResponse", "status-code", backendResponse.StatusCode)
 	}
+	copyHeader(backendResponse.Header, responseWriter.Header(), ignoredResponseHeaders)
 	s.incResponseStatusCounter(backendResponse.StatusCode, incomingPath)
 	responseWriter.WriteHeader(backendResponse.StatusCode)
-	responseWriter.Write(responseBody)
+}
+
+// stateVersion identifies a successfully read encrypted state by the ETag of the backend response and the SOPS last
+// modified time and MAC, which change with every encryption. An empty version is returned for states, which are not
+// cached.
+func (s server) stateVersion(backendResponse *http.Response, state []byte) string {
+	if s.stateCache == nil || backendResponse.StatusCode/100 != 2 {
+		return ""
+	}
+	var metadata struct {
+		Sops *struct {
+			LastModified string `json:"lastmodified"`
+			MAC          string `json:"mac"`
+		} `json:"sops"`
+	}
+	if err := json.Unmarshal(state, &metadata); err != nil || metadata.Sops == nil || metadata.Sops.MAC == "" {
+		return ""
+	}
+	return strings.Join([]string{backendResponse.Header.Get("ETag"), metadata.Sops.LastModified, metadata.Sops.MAC}, "|")
+}
+
+// cachedState returns the decrypted state of the path if the cache holds the version
+func (s server) cachedState(incomingPath string, version string) ([]byte, bool) {
+	if version == "" {
+		return nil, false
+	}
+	state, ok := s.stateCache.Get(s.stateCacheKey(incomingPath), version)
+	if ok && s.requestLogger.IsDebug() {
+		s.requestLogger.Debug("Use cached decrypted state", "path", incomingPath)
+	}
+	return state, ok
+}
+
+// flagPlaintextState raises the plaintext leak alert for a terraform state, which is stored without SOPS meta
+// information in the backend
+func (s server) flagPlaintextState(state []byte, incomingPath string) {
+	if !migration.IsPlaintext(state) {
+		return
+	}
+	transformer.AlertPlaintextLeak(methodGet)
+	s.requestLogger.Error("Terraform state is stored unencrypted in the backend", "path", incomingPath)
+}
+
+// passDecryptFailure decides with the decrypt failure policy whether the state, which can not be decrypted, is
+// answered unchanged
+func (s server) passDecryptFailure(state []byte, incomingPath string, err error) bool {
+	plaintext := migration.IsPlaintext(state)
+	policy := s.config.DecryptFailurePolicy()
+	if policy == config.DecryptFailurePolicyFail || (policy == config.DecryptFailurePolicyPassthroughIfPlaintext && !plaintext) {
+		decryptOutcomeCounter.WithLabelValues(decryptOutcomeRejected).Inc()
+		return false
+	}
+	if plaintext {
+		decryptOutcomeCounter.WithLabelValues(decryptOutcomePassthroughPlaintext).Inc()
+		s.requestLogger.Warn("Terraform state is not encrypted. Leave body unchanged", "path", incomingPath)
+	} else {
+		decryptOutcomeCounter.WithLabelValues(decryptOutcomePassthrough).Inc()
+		s.requestLogger.Warn("Can not decrypt body. Leave body unchanged", "path", incomingPath, "error", err)
+	}
+	return true
+}
+
+// decryptFailure is the body of the response to a terraform state, which can not be decrypted
+type decryptFailure struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Path    string `json:"path"`
+}
+
+func (s server) writeDecryptFailureResponse(responseWriter http.ResponseWriter, incomingPath string, err error) {
+	s.requestLogger.Error("Can not decrypt terraform state", "path", incomingPath, "error", err)
+	s.incResponseStatusCounter(http.StatusBadGateway, incomingPath)
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.WriteHeader(http.StatusBadGateway)
+	if err := json.NewEncoder(responseWriter).Encode(decryptFailure{
+		Error:   "decrypt_failed",
+		Message: err.Error(),
+		Path:    incomingPath,
+	}); err != nil {
+		s.requestLogger.Error("Can not write decrypt failure response", "error", err)
+	}
+}
+
+// migrate migrates the plaintext state of the path with the migration mode and returns true if the state has been
+// written back encrypted
+func (s server) migrate(state []byte, incomingPath string) bool {
+	if s.migrator == nil || !migration.IsPlaintext(state) {
+		return false
+	}
+	result := s.migrator.Migrate(incomingPath, s.upstreamPath(incomingPath))
+	migrationCounter.WithLabelValues(string(result.Status)).Inc()
+	switch result.Status {
+	case migration.StatusMigrated:
+		s.requestLogger.Info("Migrated plaintext terraform state", "path", incomingPath)
+	case migration.StatusDryRun:
+		s.requestLogger.Info("Detected plaintext terraform state", "path", incomingPath)
+	case migration.StatusLocked, migration.StatusFailed:
+		if errors.Is(result.Err, transformer.ErrPlaintextLeak) {
+			s.requestLogger.Error("Encrypted terraform state leaks sensitive values, leave plaintext state unchanged", "path", incomingPath, "error", result.Err)
+			break
+		}
+		s.requestLogger.Warn("Can not migrate plaintext terraform state", "path", incomingPath, "status", result.Status, "error", result.Err)
+	}
+	return result.Status == migration.StatusMigrated
 }
 
 func (s server) writeErrorResponse(responseWriter http.ResponseWriter, error string, code int, incomingRequestMethod string, incomingPath string, err error, logMessage string) {
@@ -191,11 +604,26 @@ func (s server) incResponseStatusCounter(code int, path string) {
 	responseStatusCounter.WithLabelValues(fmt.Sprintf("%vxx", code/100), path).Inc()
 }
 
-func readBody(body io.ReadCloser) ([]byte, error) {
+// readBody reads the whole body, which must not exceed the limit (limit 0 = unlimited). A known content length is
+// used to allocate the buffer once instead of growing it while reading.
+func readBody(body io.Reader, contentLength int64, limit int64) ([]byte, error) {
+	if limit > 0 {
+		if contentLength > limit {
+			return nil, errBodyTooLarge
+		}
+		body = io.LimitReader(body, limit+1)
+	}
 	buffer := bytes.NewBuffer(nil)
+	if contentLength > 0 {
+		// the buffer needs room for the final read, which detects the end of the body
+		buffer.Grow(int(contentLength) + bytes.MinRead)
+	}
 	if _, err := io.Copy(buffer, body); err != nil {
 		return nil, err
 	}
+	if limit > 0 && int64(buffer.Len()) > limit {
+		return nil, errBodyTooLarge
+	}
 	return buffer.Bytes(), nil
 }
 
@@ -227,3 +655,36 @@ func buildIncomingURI(url *url.URL) (result string) {
 	}
 	return
 }
+
+// auditResponseWriter keeps the status code of the response for the audit record
+type auditResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *auditResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *auditResponseWriter) status() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+	return w.statusCode
+}
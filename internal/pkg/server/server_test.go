@@ -16,19 +16,34 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"filippo.io/age"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/audit"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/auth"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/backend"
 	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/migration"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/routing"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/transformer"
 )
 
 func Test_server_buildBackendRequest(t *testing.T) {
@@ -199,6 +214,331 @@ func Test_server_writeResponse(t *testing.T) {
 	}
 }
 
+func Test_server_writeResponse_decryptFailurePolicy(t *testing.T) {
+	const (
+		plaintextState = `{"version":4,"serial":1,"lineage":"test","outputs":{}}`
+		encryptedState = `{"version":4,"serial":1,"lineage":"test","outputs":{},"sops":{"version":"3.9.0"}}`
+	)
+	tests := []struct {
+		name              string
+		policy            string
+		backendBody       string
+		backendStatusCode int
+		wantStatusCode    int
+		wantOutcome       string
+		wantLeakAlert     bool
+	}{
+		{
+			name:              "passthrough plaintext",
+			policy:            config.DecryptFailurePolicyPassthrough,
+			backendBody:       plaintextState,
+			backendStatusCode: http.StatusOK,
+			wantStatusCode:    http.StatusOK,
+			wantOutcome:       decryptOutcomePassthroughPlaintext,
+			wantLeakAlert:     true,
+		},
+		{
+			name:              "passthrough encrypted",
+			policy:            config.DecryptFailurePolicyPassthrough,
+			backendBody:       encryptedState,
+			backendStatusCode: http.StatusOK,
+			wantStatusCode:    http.StatusOK,
+			wantOutcome:       decryptOutcomePassthrough,
+		},
+		{
+			name:              "passthrough only if plaintext with plaintext",
+			policy:            config.DecryptFailurePolicyPassthroughIfPlaintext,
+			backendBody:       plaintextState,
+			backendStatusCode: http.StatusOK,
+			wantStatusCode:    http.StatusOK,
+			wantOutcome:       decryptOutcomePassthroughPlaintext,
+			wantLeakAlert:     true,
+		},
+		{
+			name:              "passthrough only if plaintext with encrypted",
+			policy:            config.DecryptFailurePolicyPassthroughIfPlaintext,
+			backendBody:       encryptedState,
+			backendStatusCode: http.StatusOK,
+			wantStatusCode:    http.StatusBadGateway,
+			wantOutcome:       decryptOutcomeRejected,
+		},
+		{
+			name:              "passthrough only if plaintext with no JSON",
+			policy:            config.DecryptFailurePolicyPassthroughIfPlaintext,
+			backendBody:       "no JSON",
+			backendStatusCode: http.StatusOK,
+			wantStatusCode:    http.StatusBadGateway,
+			wantOutcome:       decryptOutcomeRejected,
+		},
+		{
+			name:              "fail with plaintext",
+			policy:            config.DecryptFailurePolicyFail,
+			backendBody:       plaintextState,
+			backendStatusCode: http.StatusOK,
+			wantStatusCode:    http.StatusBadGateway,
+			wantOutcome:       decryptOutcomeRejected,
+			wantLeakAlert:     true,
+		},
+		{
+			name:              "fail ignores unsuccessful response",
+			policy:            config.DecryptFailurePolicyFail,
+			backendBody:       "Not Found",
+			backendStatusCode: http.StatusNotFound,
+			wantStatusCode:    http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := randConfig(t, false).(*simpleTestServerConfig)
+			serverConfig.decryptFailurePolicy = tt.policy
+			responseWriter := &simpleResponseWriter{}
+			backendResponse := randResponse(tt.backendStatusCode)
+			backendResponse.responseBody = tt.backendBody
+			s := server{
+				config:        serverConfig,
+				transformer:   randAllowFromSopsTransformer(t, fmt.Errorf("no key could decrypt the data key")),
+				requestLogger: serverConfig.Logger().Named("frontend"),
+			}
+			outcomes := map[string]float64{}
+			for _, outcome := range []string{decryptOutcomePassthrough, decryptOutcomePassthroughPlaintext, decryptOutcomeRejected} {
+				outcomes[outcome] = testutil.ToFloat64(decryptOutcomeCounter.WithLabelValues(outcome))
+			}
+			leakAlerts := plaintextLeakAlerts(t, methodGet)
+
+			s.writeResponse(responseWriter, backendResponse.build(), methodGet, "/test")
+
+			assert.Equal(t, tt.wantStatusCode, responseWriter.statusCode)
+			if tt.wantStatusCode == http.StatusBadGateway {
+				assert.Equal(t, "application/json", responseWriter.header.Get("Content-Type"))
+				assert.JSONEq(t, `{"error":"decrypt_failed","message":"no key could decrypt the data key","path":"/test"}`, responseWriter.body.String())
+			} else {
+				assert.Equal(t, tt.backendBody, responseWriter.body.String())
+			}
+			for outcome, before := range outcomes {
+				want := before
+				if outcome == tt.wantOutcome {
+					want++
+				}
+				assert.Equal(t, want, testutil.ToFloat64(decryptOutcomeCounter.WithLabelValues(outcome)), outcome)
+			}
+			if tt.wantLeakAlert {
+				leakAlerts++
+			}
+			assert.Equal(t, leakAlerts, plaintextLeakAlerts(t, methodGet))
+		})
+	}
+}
+
+func Test_server_writeResponse_migration(t *testing.T) {
+	const plaintextState = `{"version":4,"serial":1,"lineage":"test","outputs":{}}`
+	tests := []struct {
+		name           string
+		policy         string
+		status         migration.Status
+		wantStatusCode int
+		wantOutcome    string
+	}{
+		{
+			name:           "migrated",
+			policy:         config.DecryptFailurePolicyFail,
+			status:         migration.StatusMigrated,
+			wantStatusCode: http.StatusOK,
+			wantOutcome:    decryptOutcomeMigrated,
+		},
+		{
+			name:           "dry run applies policy",
+			policy:         config.DecryptFailurePolicyPassthrough,
+			status:         migration.StatusDryRun,
+			wantStatusCode: http.StatusOK,
+			wantOutcome:    decryptOutcomePassthroughPlaintext,
+		},
+		{
+			name:           "locked applies policy",
+			policy:         config.DecryptFailurePolicyFail,
+			status:         migration.StatusLocked,
+			wantStatusCode: http.StatusBadGateway,
+			wantOutcome:    decryptOutcomeRejected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := randConfig(t, false).(*simpleTestServerConfig)
+			serverConfig.decryptFailurePolicy = tt.policy
+			responseWriter := &simpleResponseWriter{}
+			backendResponse := randResponse(http.StatusOK)
+			backendResponse.responseBody = plaintextState
+			migrator := &testMigrator{status: tt.status}
+			s := server{
+				config:        serverConfig,
+				transformer:   randAllowFromSopsTransformer(t, fmt.Errorf("no key could decrypt the data key")),
+				migrator:      migrator,
+				requestLogger: serverConfig.Logger().Named("frontend"),
+			}
+			outcomeBefore := testutil.ToFloat64(decryptOutcomeCounter.WithLabelValues(tt.wantOutcome))
+			migrationBefore := testutil.ToFloat64(migrationCounter.WithLabelValues(string(tt.status)))
+
+			s.writeResponse(responseWriter, backendResponse.build(), methodGet, "/test")
+
+			assert.Equal(t, tt.wantStatusCode, responseWriter.statusCode)
+			assert.Equal(t, []string{"/test"}, migrator.paths)
+			assert.Equal(t, []string{"/test"}, migrator.backendPaths)
+			if tt.wantStatusCode == http.StatusOK {
+				assert.Equal(t, plaintextState, responseWriter.body.String())
+			}
+			assert.Equal(t, outcomeBefore+1, testutil.ToFloat64(decryptOutcomeCounter.WithLabelValues(tt.wantOutcome)))
+			assert.Equal(t, migrationBefore+1, testutil.ToFloat64(migrationCounter.WithLabelValues(string(tt.status))))
+		})
+	}
+}
+
+func Test_server_newRequestHandler_migrationCredentials(t *testing.T) {
+	const plaintextState = `{"version":4,"serial":1,"lineage":"test","outputs":{}}`
+	states := map[string]string{"/states/test": plaintextState}
+	var methods []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "terraform" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		methods = append(methods, r.Method)
+		body, _ := io.ReadAll(r.Body)
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte(states[r.URL.Path]))
+		case http.MethodPost:
+			states[r.URL.Path] = string(body)
+		}
+	}))
+	defer upstream.Close()
+	serverConfig := randConfig(t, false).(*simpleTestServerConfig)
+	serverConfig.backendURL = upstream.URL
+	serverConfig.migrationMode = config.MigrationModeEncrypt
+	serverConfig.decryptFailurePolicy = config.DecryptFailurePolicyFail
+	backendClient, err := backend.New(routedTestServerConfig{simpleTestServerConfig: serverConfig})
+	require.NoError(t, err)
+	transformer := &migrationTestTransformer{}
+	s := server{
+		config:        serverConfig,
+		backend:       backendClient,
+		transformer:   transformer,
+		migrator:      newMigrator(serverConfig, backendClient, transformer),
+		requestLogger: serverConfig.Logger().Named("frontend"),
+	}
+	incomingRequest := httptest.NewRequest(http.MethodGet, "/states/test", nil)
+	incomingRequest.SetBasicAuth("terraform", "secret")
+	recorder := httptest.NewRecorder()
+
+	s.newRequestHandler()(recorder, incomingRequest)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, plaintextState, recorder.Body.String())
+	assert.Equal(t, []string{http.MethodGet, serverConfig.backendLockMethod, http.MethodGet, http.MethodPost, serverConfig.backendUnlockMethod}, methods)
+	assert.Equal(t, "encrypted:"+plaintextState, states["/states/test"])
+}
+
+func Test_server_newRequestHandler_migrationRoute(t *testing.T) {
+	const plaintextState = `{"version":4,"serial":1,"lineage":"test","outputs":{}}`
+	states := map[string]string{"/states/test": plaintextState}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte(states[r.URL.Path]))
+		case http.MethodPost:
+			states[r.URL.Path] = string(body)
+		}
+	}))
+	defer upstream.Close()
+	serverConfig := randConfig(t, false).(*simpleTestServerConfig)
+	serverConfig.migrationMode = config.MigrationModeEncrypt
+	serverConfig.decryptFailurePolicy = config.DecryptFailurePolicyFail
+	routes, err := routing.New(routedTestServerConfig{simpleTestServerConfig: serverConfig, routes: []config.Route{
+		{Name: "team-a", PathPrefix: "/team-a", URL: upstream.URL},
+	}}, nil)
+	require.NoError(t, err)
+	transformer := &migrationTestTransformer{}
+	s := server{
+		config:        serverConfig,
+		routes:        routes,
+		transformer:   transformer,
+		migrator:      newMigrator(serverConfig, nil, transformer),
+		requestLogger: serverConfig.Logger().Named("frontend"),
+	}
+	recorder := httptest.NewRecorder()
+
+	s.newRequestHandler()(recorder, httptest.NewRequest(http.MethodGet, "/team-a/states/test", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "encrypted:"+plaintextState, states["/states/test"])
+	assert.Equal(t, []string{"/team-a/states/test"}, transformer.paths, "creation rules match the incoming path")
+}
+
+func Test_server_newRequestHandler_migrationLeak(t *testing.T) {
+	const plaintextState = `{"version":4,"serial":1,"lineage":"test","outputs":{"password":{"value":"very-secret-password","type":"string","sensitive":true}}}`
+	var methods []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(plaintextState))
+		}
+	}))
+	defer upstream.Close()
+	serverConfig := randConfig(t, false).(*simpleTestServerConfig)
+	serverConfig.backendURL = upstream.URL
+	serverConfig.migrationMode = config.MigrationModeEncrypt
+	serverConfig.decryptFailurePolicy = config.DecryptFailurePolicyPassthroughIfPlaintext
+	backendClient, err := backend.New(routedTestServerConfig{simpleTestServerConfig: serverConfig})
+	require.NoError(t, err)
+	// the test transformer leaves the whole state unencrypted
+	transformer := &migrationTestTransformer{}
+	s := server{
+		config:        serverConfig,
+		backend:       backendClient,
+		transformer:   transformer,
+		migrator:      newMigrator(serverConfig, backendClient, transformer),
+		requestLogger: serverConfig.Logger().Named("frontend"),
+	}
+	failed := testutil.ToFloat64(migrationCounter.WithLabelValues(string(migration.StatusFailed)))
+	leakAlerts := plaintextLeakAlerts(t, "migrate")
+	recorder := httptest.NewRecorder()
+
+	s.newRequestHandler()(recorder, httptest.NewRequest(http.MethodGet, "/states/test", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, plaintextState, recorder.Body.String())
+	assert.NotContains(t, methods, http.MethodPost, "the leaking state is not written back")
+	assert.Equal(t, failed+1, testutil.ToFloat64(migrationCounter.WithLabelValues(string(migration.StatusFailed))))
+	assert.Equal(t, leakAlerts+1, plaintextLeakAlerts(t, "migrate"))
+}
+
+// migrationTestTransformer can not decrypt plaintext states and prefixes the encrypted states with "encrypted:". It
+// records the paths of the encrypted states.
+type migrationTestTransformer struct {
+	paths []string
+}
+
+func (t *migrationTestTransformer) ToSops(_ config.TransformConfig, path string, input []byte, handler func(result []byte)) error {
+	t.paths = append(t.paths, path)
+	handler([]byte("encrypted:" + string(input)))
+	return nil
+}
+
+func (*migrationTestTransformer) FromSops(_ config.TransformConfig, _ []byte, _ func(result []byte) error) error {
+	return fmt.Errorf("no SOPS metadata")
+}
+
+type testMigrator struct {
+	status       migration.Status
+	paths        []string
+	backendPaths []string
+}
+
+func (m *testMigrator) Migrate(path, backendPath string) migration.Result {
+	m.paths = append(m.paths, path)
+	m.backendPaths = append(m.backendPaths, backendPath)
+	return migration.Result{Path: path, Status: m.status}
+}
+
 func Test_server_newRequestHandler(t *testing.T) {
 	tests := []struct {
 		name                        string
@@ -272,11 +612,528 @@ func Test_server_newRequestHandler(t *testing.T) {
 	}
 }
 
+func Test_server_newRequestHandler_auth(t *testing.T) {
+	tests := []struct {
+		name                  string
+		authErr               error
+		forwardCredentials    bool
+		expectsBackend        bool
+		expectedStatusCode    int
+		expectedAuthenticate  string
+		expectedAuthorization string
+	}{
+		{
+			name:               "authorized",
+			expectsBackend:     true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:                  "authorized with forwarded credentials",
+			forwardCredentials:    true,
+			expectsBackend:        true,
+			expectedStatusCode:    http.StatusOK,
+			expectedAuthorization: "Bearer token",
+		},
+		{
+			name:                 "unauthenticated",
+			authErr:              auth.ErrUnauthenticated,
+			expectedStatusCode:   http.StatusUnauthorized,
+			expectedAuthenticate: `Basic realm="test"`,
+		},
+		{
+			name:               "forbidden",
+			authErr:            fmt.Errorf("%w: test", auth.ErrForbidden),
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := randConfig(t, false)
+			config.(*simpleTestServerConfig).forwardCredentials = tt.forwardCredentials
+			responseWriter := &simpleResponseWriter{}
+			incomingRequest := randRequestBuilder(methodLock, false).buildRequest()
+			incomingRequest.Header.Set("Authorization", "Bearer token")
+			backendClient := &recordingTestBackendClient{responseBuilder: randResponse(http.StatusOK)}
+			authorizer := &simpleTestAuthorizer{err: tt.authErr}
+			s := server{
+				config:        config,
+				transformer:   randAllowNothingTransformer(t),
+				backend:       backendClient,
+				authorizer:    authorizer,
+				requestLogger: config.Logger().Named("frontend"),
+			}
+			s.newRequestHandler()(responseWriter, incomingRequest)
+
+			assert.Equal(t, tt.expectedStatusCode, responseWriter.statusCode)
+			assert.Equal(t, auth.PermissionLock, authorizer.permission)
+			assert.Equal(t, tt.expectedAuthenticate, responseWriter.Header().Get("WWW-Authenticate"))
+			if assert.Equal(t, tt.expectsBackend, backendClient.request != nil) && tt.expectsBackend {
+				assert.Equal(t, tt.expectedAuthorization, backendClient.request.Header.Get("Authorization"))
+			}
+		})
+	}
+}
+
+func Test_server_writeResponse_stateCache(t *testing.T) {
+	const encryptedState = `{"version":4,"sops":{"lastmodified":"2026-01-01T00:00:00Z","mac":"ENC[mac]"}}`
+	serverConfig := randConfig(t, false).(*simpleTestServerConfig)
+	serverConfig.cacheMaxSize = 1024
+	transformer := randAllowFromSopsTransformer(t, nil)
+	s := server{
+		config:        serverConfig,
+		transformer:   transformer,
+		stateCache:    newStateCache(serverConfig),
+		requestLogger: serverConfig.Logger().Named("frontend"),
+	}
+	get := func(etag string) *simpleResponseWriter {
+		responseWriter := &simpleResponseWriter{}
+		backendResponse := randResponse(http.StatusOK)
+		backendResponse.responseBody = encryptedState
+		response := backendResponse.build()
+		response.Header.Set("ETag", etag)
+		s.writeResponse(responseWriter, response, methodGet, "/test")
+		return responseWriter
+	}
+
+	assert.Equal(t, string(transformer.output), get(`"1"`).body.String())
+	transformer.allowFromSops = false
+	assert.Equal(t, string(transformer.output), get(`"1"`).body.String(), "cached state expected")
+
+	transformer.allowFromSops = true
+	transformer.output = []byte("other state")
+	assert.Equal(t, "other state", get(`"2"`).body.String(), "state with other ETag must be decrypted")
+
+	s.stateCache.Delete("/test")
+	transformer.output = []byte("deleted state")
+	assert.Equal(t, "deleted state", get(`"2"`).body.String(), "deleted state must be decrypted")
+}
+
+func Test_server_newRequestHandler_maxBodySize(t *testing.T) {
+	tests := []struct {
+		name               string
+		incomingMethod     string
+		requestBody        string
+		knownLength        bool
+		backendBody        string
+		expectsBackend     bool
+		expectedStatusCode int
+	}{
+		{
+			name:               "request within limit",
+			incomingMethod:     methodPost,
+			requestBody:        strings.Repeat("a", 16),
+			knownLength:        true,
+			expectsBackend:     true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "request with known length too large",
+			incomingMethod:     methodPost,
+			requestBody:        strings.Repeat("a", 17),
+			knownLength:        true,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:               "request with unknown length too large",
+			incomingMethod:     methodPost,
+			requestBody:        strings.Repeat("a", 17),
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:               "backend response too large",
+			incomingMethod:     methodGet,
+			backendBody:        strings.Repeat("a", 17),
+			expectsBackend:     true,
+			expectedStatusCode: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := randConfig(t, false).(*simpleTestServerConfig)
+			serverConfig.maxBodySize = 16
+			responseWriter := &simpleResponseWriter{}
+			incomingRequest := randRequestBuilder(tt.incomingMethod, false).buildRequest()
+			incomingRequest.Body = io.NopCloser(strings.NewReader(tt.requestBody))
+			incomingRequest.ContentLength = -1
+			if tt.knownLength {
+				incomingRequest.ContentLength = int64(len(tt.requestBody))
+			}
+			backendResponse := randResponse(http.StatusOK)
+			backendResponse.responseBody = tt.backendBody
+			backendClient := &recordingTestBackendClient{responseBuilder: backendResponse}
+			transformer := randAllowToSopsTransformer(t, nil)
+			transformer.output = []byte(tt.requestBody)
+			s := server{
+				config:        serverConfig,
+				transformer:   transformer,
+				backend:       backendClient,
+				requestLogger: serverConfig.Logger().Named("frontend"),
+			}
+			s.newRequestHandler()(responseWriter, incomingRequest)
+
+			assert.Equal(t, tt.expectedStatusCode, responseWriter.statusCode)
+			assert.Equal(t, tt.expectsBackend, backendClient.request != nil)
+		})
+	}
+}
+
+// Benchmark_server_newRequestHandler measures the memory used by the service itself to pass on a large terraform
+// state. The transformer hands back the state unchanged, so the SOPS transformation is not part of the results.
+func Benchmark_server_newRequestHandler(b *testing.B) {
+	state := generateState(b, 20000)
+	b.Logf("state size %d bytes", len(state))
+	serverConfig := &simpleTestServerConfig{
+		backendURL:           randBackendURL(false),
+		decryptFailurePolicy: config.DecryptFailurePolicyPassthrough,
+		migrationMode:        config.MigrationModeOff,
+		maxBodySize:          64 << 20,
+	}
+	transformer := &simpleTestTransformer{allowToSops: true, allowFromSops: true, output: state}
+	for _, method := range []string{methodGet, methodPost} {
+		b.Run(method, func(b *testing.B) {
+			s := server{
+				config:        serverConfig,
+				transformer:   transformer,
+				backend:       stateTestBackendClient{state: state},
+				requestLogger: hclog.NewNullLogger(),
+			}
+			handler := s.newRequestHandler()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(state)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var body io.Reader = http.NoBody
+				if method == methodPost {
+					body = bytes.NewReader(state)
+				}
+				handler(discardResponseWriter{header: http.Header{}}, httptest.NewRequest(method, "/states/benchmark", body))
+			}
+		})
+	}
+}
+
+// Benchmark_server_newRequestHandler_transformer measures the memory used to pass on a large terraform state including
+// its encryption and decryption with AGE keys. Most of it is used by SOPS, which compiles the unencrypted regex for
+// every value of the state.
+func Benchmark_server_newRequestHandler_transformer(b *testing.B) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(b, err)
+	state := generateState(b, 2000)
+	serverConfig := transformTestServerConfig{
+		simpleTestServerConfig: &simpleTestServerConfig{
+			backendURL:           randBackendURL(false),
+			decryptFailurePolicy: config.DecryptFailurePolicyFail,
+			migrationMode:        config.MigrationModeOff,
+			maxBodySize:          64 << 20,
+		},
+		agePublicKey:  identity.Recipient().String(),
+		agePrivateKey: identity.String(),
+	}
+	sopsTransformer := transformer.New()
+	var encrypted []byte
+	require.NoError(b, sopsTransformer.ToSops(serverConfig, "/states/benchmark", state, func(result []byte) { encrypted = result }))
+	b.Logf("state size %d bytes, encrypted %d bytes", len(state), len(encrypted))
+	for _, method := range []string{methodGet, methodPost} {
+		b.Run(method, func(b *testing.B) {
+			s := server{
+				config:        serverConfig,
+				transformer:   sopsTransformer,
+				backend:       stateTestBackendClient{state: encrypted},
+				requestLogger: hclog.NewNullLogger(),
+			}
+			handler := s.newRequestHandler()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(state)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var body io.Reader = http.NoBody
+				if method == methodPost {
+					body = bytes.NewReader(state)
+				}
+				handler(discardResponseWriter{header: http.Header{}}, httptest.NewRequest(method, "/states/benchmark", body))
+			}
+		})
+	}
+}
+
+// transformTestServerConfig adds the AGE keys to the simpleTestServerConfig to encrypt with the SOPS transformer
+type transformTestServerConfig struct {
+	*simpleTestServerConfig
+	agePublicKey  string
+	agePrivateKey string
+}
+
+func (c transformTestServerConfig) AgePublicKeys() []string              { return []string{c.agePublicKey} }
+func (c transformTestServerConfig) AgePrivateKey() string                { return c.agePrivateKey }
+func (c transformTestServerConfig) VaultAddr() string                    { return "" }
+func (c transformTestServerConfig) VaultAuthMethod() string              { return "" }
+func (c transformTestServerConfig) VaultAuthMount() string               { return "" }
+func (c transformTestServerConfig) VaultAuthRole() string                { return "" }
+func (c transformTestServerConfig) VaultAuthJWT() string                 { return "" }
+func (c transformTestServerConfig) VaultAuthJWTFile() string             { return "" }
+func (c transformTestServerConfig) VaultToken() string                   { return "" }
+func (c transformTestServerConfig) VaultAppRoleID() string               { return "" }
+func (c transformTestServerConfig) VaultAppRoleSecretID() string         { return "" }
+func (c transformTestServerConfig) VaultNamespace() string               { return "" }
+func (c transformTestServerConfig) VaultCACert() []byte                  { return nil }
+func (c transformTestServerConfig) VaultClientCert() []byte              { return nil }
+func (c transformTestServerConfig) VaultClientKey() []byte               { return nil }
+func (c transformTestServerConfig) VaultTLSServerName() string           { return "" }
+func (c transformTestServerConfig) VaultKeyMount() string                { return "" }
+func (c transformTestServerConfig) VaultKeyName() string                 { return "" }
+func (c transformTestServerConfig) AWSKMSEndpoint() string               { return "" }
+func (c transformTestServerConfig) GCPKMSEndpoint() string               { return "" }
+func (c transformTestServerConfig) KeyGroups() []config.KeyGroup         { return nil }
+func (c transformTestServerConfig) ShamirThreshold() int                 { return 0 }
+func (c transformTestServerConfig) CreationRules() []config.CreationRule { return nil }
+func (c transformTestServerConfig) EncryptionStrategy() string           { return config.EncryptionStrategyAll }
+func (c transformTestServerConfig) AlwaysEncryptedKeys() []string        { return nil }
+func (c transformTestServerConfig) EncryptedRegex() string               { return "" }
+func (c transformTestServerConfig) UnencryptedRegex() string             { return "" }
+func (c transformTestServerConfig) UnencryptedSuffix() string            { return "" }
+func (c transformTestServerConfig) MACOnlyEncrypted() bool               { return false }
+
+// generateState generates a terraform state with the number of resources
+func generateState(tb testing.TB, resources int) []byte {
+	type instance struct {
+		SchemaVersion       int                    `json:"schema_version"`
+		Attributes          map[string]interface{} `json:"attributes"`
+		SensitiveAttributes []interface{}          `json:"sensitive_attributes"`
+	}
+	type resource struct {
+		Mode      string     `json:"mode"`
+		Type      string     `json:"type"`
+		Name      string     `json:"name"`
+		Provider  string     `json:"provider"`
+		Instances []instance `json:"instances"`
+	}
+	state := struct {
+		Version          int                    `json:"version"`
+		TerraformVersion string                 `json:"terraform_version"`
+		Serial           int                    `json:"serial"`
+		Lineage          string                 `json:"lineage"`
+		Outputs          map[string]interface{} `json:"outputs"`
+		Resources        []resource             `json:"resources"`
+	}{
+		Version:          4,
+		TerraformVersion: "1.9.0",
+		Serial:           1,
+		Lineage:          "benchmark",
+		Outputs:          map[string]interface{}{},
+	}
+	for i := 0; i < resources; i++ {
+		state.Resources = append(state.Resources, resource{
+			Mode:     "managed",
+			Type:     "null_resource",
+			Name:     fmt.Sprintf("resource_%d", i),
+			Provider: `provider["registry.terraform.io/hashicorp/null"]`,
+			Instances: []instance{{
+				Attributes: map[string]interface{}{
+					"id":       fmt.Sprint(i),
+					"triggers": map[string]string{"value": randString(512)},
+				},
+				SensitiveAttributes: []interface{}{},
+			}},
+		})
+	}
+	result, err := json.Marshal(state)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return result
+}
+
+// stateTestBackendClient answers every request with the state
+type stateTestBackendClient struct {
+	state []byte
+}
+
+func (b stateTestBackendClient) Send(r *retryablehttp.Request) (*http.Response, error) {
+	body := b.state
+	if r.Method != methodGet {
+		body = nil
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+	}, nil
+}
+
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header            { return w.header }
+func (w discardResponseWriter) Write(data []byte) (int, error) { return len(data), nil }
+func (w discardResponseWriter) WriteHeader(int)                {}
+
+func Test_server_routes(t *testing.T) {
+	type upstreamRequest struct{ method, path string }
+	var got []upstreamRequest
+	newUpstream := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = append(got, upstreamRequest{method: r.Method, path: r.Host + r.URL.Path})
+		}))
+	}
+	teamA, teamB := newUpstream(), newUpstream()
+	defer teamA.Close()
+	defer teamB.Close()
+	serverConfig := randConfig(t, false).(*simpleTestServerConfig)
+	serverConfig.backendLockMethod = "LOCK"
+	routes, err := routing.New(routedTestServerConfig{simpleTestServerConfig: serverConfig, routes: []config.Route{
+		{Name: "team-a", PathPrefix: "/team-a", URL: teamA.URL},
+		{Name: "team-b", Host: "team-b.example.com", URL: teamB.URL, LockMethod: "PUT"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server{
+		config:        serverConfig,
+		routes:        routes,
+		transformer:   randAllowNothingTransformer(t),
+		requestLogger: serverConfig.Logger().Named("frontend"),
+	}
+	tests := []struct {
+		name           string
+		host           string
+		path           string
+		wantStatusCode int
+		wantRequest    *upstreamRequest
+	}{
+		{
+			name:           "path prefix",
+			host:           "tsb.example.com",
+			path:           "/team-a/states/test",
+			wantStatusCode: http.StatusOK,
+			wantRequest:    &upstreamRequest{method: "LOCK", path: strings.TrimPrefix(teamA.URL, "http://") + "/states/test"},
+		},
+		{
+			name:           "host",
+			host:           "team-b.example.com",
+			path:           "/states/test",
+			wantStatusCode: http.StatusOK,
+			wantRequest:    &upstreamRequest{method: "PUT", path: strings.TrimPrefix(teamB.URL, "http://") + "/states/test"},
+		},
+		{
+			name:           "no route",
+			host:           "tsb.example.com",
+			path:           "/team-c/states/test",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(methodLock, "http://"+tt.host+tt.path, strings.NewReader("{}"))
+
+			s.newRequestHandler()(recorder, request)
+
+			assert.Equal(t, tt.wantStatusCode, recorder.Code)
+			if tt.wantRequest == nil {
+				assert.Empty(t, got)
+				assert.Contains(t, recorder.Body.String(), "no backend route matches the request")
+				return
+			}
+			assert.Equal(t, []upstreamRequest{*tt.wantRequest}, got)
+		})
+	}
+}
+
+// routedTestServerConfig adds backend routes to the simpleTestServerConfig
+type routedTestServerConfig struct {
+	*simpleTestServerConfig
+	routes []config.Route
+}
+
+func (c routedTestServerConfig) BackendRoutes() []config.Route { return c.routes }
+func (c routedTestServerConfig) BackendType() string           { return config.BackendTypeHTTP }
+func (c routedTestServerConfig) BackendMTLSCert() []byte       { return nil }
+func (c routedTestServerConfig) BackendMTLSKey() []byte        { return nil }
+func (c routedTestServerConfig) BackendUsername() string       { return "" }
+func (c routedTestServerConfig) BackendPassword() string       { return "" }
+func (c routedTestServerConfig) BackendToken() string          { return "" }
+
+func Test_server_Shutdown(t *testing.T) {
+	config := randConfig(t, false)
+	entered := make(chan struct{})
+	released := make(chan struct{})
+	s := server{
+		config:        config,
+		transformer:   randAllowNothingTransformer(t),
+		backend:       blockingTestBackendClient{entered: entered, released: released, responseBuilder: randResponse(http.StatusOK)},
+		requestLogger: config.Logger().Named("frontend"),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.newRequestHandler())
+	s.httpServer = &http.Server{Handler: mux}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.serve(listener) }()
+
+	url := fmt.Sprintf("http://%s/states/test", listener.Addr())
+	responded := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest(methodLock, url, strings.NewReader("{}"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			responded <- 0
+			return
+		}
+		resp.Body.Close()
+		responded <- resp.StatusCode
+	}()
+	<-entered
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- s.Shutdown(ctx)
+	}()
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned before the in-flight request finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, err = http.Get(url)
+	assert.Error(t, err, "new requests are not accepted during shutdown")
+
+	close(released)
+	assert.Equal(t, http.StatusOK, <-responded)
+	assert.NoError(t, <-shutdown)
+	assert.NoError(t, <-served)
+}
+
 var (
 	testLogger   hclog.Logger = newTestLogger()
 	allowedRunes []rune       = []rune("abcdefghijklmnopqrstuvwxyz")
 )
 
+// plaintextLeakAlerts returns the plaintext leak alerts raised so far for the method
+func plaintextLeakAlerts(t *testing.T, method string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "service_plaintext_leak_counter" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "method" && label.GetValue() == method {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
 func randString(n int) string {
 	b := make([]rune, n)
 	for i := range b {
@@ -302,10 +1159,13 @@ func randBackendUnlockMethod() string {
 
 func randConfig(t *testing.T, backendWithPort bool) config.ServerConfig {
 	return &simpleTestServerConfig{
-		currentTest:         t,
-		backendURL:          randBackendURL(backendWithPort),
-		backendLockMethod:   randBackendLockMethod(),
-		backendUnlockMethod: randBackendUnlockMethod(),
+		currentTest:          t,
+		backendURL:           randBackendURL(backendWithPort),
+		backendLockMethod:    randBackendLockMethod(),
+		backendUnlockMethod:  randBackendUnlockMethod(),
+		decryptFailurePolicy: config.DecryptFailurePolicyPassthrough,
+		migrationMode:        config.MigrationModeOff,
+		maxBodySize:          1 << 20,
 	}
 }
 
@@ -383,10 +1243,16 @@ func newTestLogger() hclog.Logger {
 }
 
 type simpleTestServerConfig struct {
-	currentTest         *testing.T
-	backendURL          string
-	backendLockMethod   string
-	backendUnlockMethod string
+	currentTest          *testing.T
+	backendURL           string
+	backendLockMethod    string
+	backendUnlockMethod  string
+	decryptFailurePolicy string
+	migrationMode        string
+	maxBodySize          int64
+	cacheMaxSize         int64
+	writeGuard           bool
+	forwardCredentials   bool
 }
 
 func (c *simpleTestServerConfig) BackendMTLSCert() []byte {
@@ -399,10 +1265,25 @@ func (c *simpleTestServerConfig) BackendMTLSKey() []byte {
 	return nil
 }
 
-func (c *simpleTestServerConfig) AgePublicKey() string {
-	c.currentTest.Fatal("Unexpected config read AgePublicKey() ")
+func (c *simpleTestServerConfig) BackendUsername() string {
+	c.currentTest.Fatal("Unexpected config read BackendUsername")
+	return ""
+}
+
+func (c *simpleTestServerConfig) BackendPassword() string {
+	c.currentTest.Fatal("Unexpected config read BackendPassword")
+	return ""
+}
+
+func (c *simpleTestServerConfig) BackendToken() string {
+	c.currentTest.Fatal("Unexpected config read BackendToken")
 	return ""
 }
+
+func (c *simpleTestServerConfig) AgePublicKeys() []string {
+	c.currentTest.Fatal("Unexpected config read AgePublicKeys() ")
+	return nil
+}
 func (c *simpleTestServerConfig) AgePrivateKey() string {
 	c.currentTest.Fatal("Unexpected config read AgePublicKey() ")
 	return ""
@@ -411,6 +1292,30 @@ func (c *simpleTestServerConfig) VaultAddr() string {
 	c.currentTest.Fatal("Unexpected config read AgePublicKey() ")
 	return ""
 }
+func (c *simpleTestServerConfig) VaultAuthMethod() string {
+	c.currentTest.Fatal("Unexpected config read VaultAuthMethod() ")
+	return ""
+}
+func (c *simpleTestServerConfig) VaultAuthMount() string {
+	c.currentTest.Fatal("Unexpected config read VaultAuthMount() ")
+	return ""
+}
+func (c *simpleTestServerConfig) VaultAuthRole() string {
+	c.currentTest.Fatal("Unexpected config read VaultAuthRole() ")
+	return ""
+}
+func (c *simpleTestServerConfig) VaultAuthJWT() string {
+	c.currentTest.Fatal("Unexpected config read VaultAuthJWT() ")
+	return ""
+}
+func (c *simpleTestServerConfig) VaultAuthJWTFile() string {
+	c.currentTest.Fatal("Unexpected config read VaultAuthJWTFile() ")
+	return ""
+}
+func (c *simpleTestServerConfig) VaultToken() string {
+	c.currentTest.Fatal("Unexpected config read VaultToken() ")
+	return ""
+}
 func (c *simpleTestServerConfig) VaultAppRoleID() string {
 	c.currentTest.Fatal("Unexpected config read AgePublicKey() ")
 	return ""
@@ -419,6 +1324,26 @@ func (c *simpleTestServerConfig) VaultAppRoleSecretID() string {
 	c.currentTest.Fatal("Unexpected config read AgePublicKey() ")
 	return ""
 }
+func (c *simpleTestServerConfig) VaultNamespace() string {
+	c.currentTest.Fatal("Unexpected config read VaultNamespace() ")
+	return ""
+}
+func (c *simpleTestServerConfig) VaultCACert() []byte {
+	c.currentTest.Fatal("Unexpected config read VaultCACert() ")
+	return nil
+}
+func (c *simpleTestServerConfig) VaultClientCert() []byte {
+	c.currentTest.Fatal("Unexpected config read VaultClientCert() ")
+	return nil
+}
+func (c *simpleTestServerConfig) VaultClientKey() []byte {
+	c.currentTest.Fatal("Unexpected config read VaultClientKey() ")
+	return nil
+}
+func (c *simpleTestServerConfig) VaultTLSServerName() string {
+	c.currentTest.Fatal("Unexpected config read VaultTLSServerName() ")
+	return ""
+}
 func (c *simpleTestServerConfig) VaultKeyMount() string {
 	c.currentTest.Fatal("Unexpected config read AgePublicKey() ")
 	return ""
@@ -427,10 +1352,180 @@ func (c *simpleTestServerConfig) VaultKeyName() string {
 	c.currentTest.Fatal("Unexpected config read AgePublicKey() ")
 	return ""
 }
+func (c *simpleTestServerConfig) AWSKMSEndpoint() string {
+	c.currentTest.Fatal("Unexpected config read AWSKMSEndpoint() ")
+	return ""
+}
+func (c *simpleTestServerConfig) GCPKMSEndpoint() string {
+	c.currentTest.Fatal("Unexpected config read GCPKMSEndpoint() ")
+	return ""
+}
+func (c *simpleTestServerConfig) KeyGroups() []config.KeyGroup {
+	c.currentTest.Fatal("Unexpected config read KeyGroups() ")
+	return nil
+}
+func (c *simpleTestServerConfig) CreationRules() []config.CreationRule {
+	c.currentTest.Fatal("Unexpected config read CreationRules() ")
+	return nil
+}
+func (c *simpleTestServerConfig) ShamirThreshold() int {
+	c.currentTest.Fatal("Unexpected config read ShamirThreshold() ")
+	return 0
+}
+func (c *simpleTestServerConfig) EncryptionStrategy() string {
+	c.currentTest.Fatal("Unexpected config read EncryptionStrategy() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AlwaysEncryptedKeys() []string {
+	c.currentTest.Fatal("Unexpected config read AlwaysEncryptedKeys() ")
+	return nil
+}
+func (c *simpleTestServerConfig) EncryptedRegex() string {
+	c.currentTest.Fatal("Unexpected config read EncryptedRegex() ")
+	return ""
+}
+func (c *simpleTestServerConfig) UnencryptedRegex() string {
+	c.currentTest.Fatal("Unexpected config read UnencryptedRegex() ")
+	return ""
+}
+func (c *simpleTestServerConfig) UnencryptedSuffix() string {
+	c.currentTest.Fatal("Unexpected config read UnencryptedSuffix() ")
+	return ""
+}
+func (c *simpleTestServerConfig) MACOnlyEncrypted() bool {
+	c.currentTest.Fatal("Unexpected config read MACOnlyEncrypted() ")
+	return false
+}
 func (c *simpleTestServerConfig) ServerPort() string {
 	c.currentTest.Fatal("Unexpected config read AgePublicKey() ")
 	return ""
 }
+func (c *simpleTestServerConfig) ServerShutdownTimeout() time.Duration {
+	c.currentTest.Fatal("Unexpected config read ServerShutdownTimeout() ")
+	return 0
+}
+func (c *simpleTestServerConfig) ServerReadTimeout() time.Duration  { return time.Minute }
+func (c *simpleTestServerConfig) ServerWriteTimeout() time.Duration { return time.Minute }
+func (c *simpleTestServerConfig) ServerMaxBodySize() int64          { return c.maxBodySize }
+func (c *simpleTestServerConfig) DecryptFailurePolicy() string      { return c.decryptFailurePolicy }
+func (c *simpleTestServerConfig) MigrationMode() string             { return c.migrationMode }
+func (c *simpleTestServerConfig) WriteGuard() bool                  { return c.writeGuard }
+func (c *simpleTestServerConfig) CacheMaxSize() int64               { return c.cacheMaxSize }
+func (c *simpleTestServerConfig) CacheTTL() time.Duration           { return time.Minute }
+func (c *simpleTestServerConfig) DataKeyReuseWindow() time.Duration { return 0 }
+func (c *simpleTestServerConfig) MonitoringAddress() string {
+	c.currentTest.Fatal("Unexpected config read MonitoringAddress() ")
+	return ""
+}
+func (c *simpleTestServerConfig) MonitoringPort() string {
+	c.currentTest.Fatal("Unexpected config read MonitoringPort() ")
+	return ""
+}
+func (c *simpleTestServerConfig) ServerTLSCertFile() string {
+	c.currentTest.Fatal("Unexpected config read ServerTLSCertFile() ")
+	return ""
+}
+func (c *simpleTestServerConfig) ServerTLSKeyFile() string {
+	c.currentTest.Fatal("Unexpected config read ServerTLSKeyFile() ")
+	return ""
+}
+func (c *simpleTestServerConfig) ServerTLSClientCAFile() string {
+	c.currentTest.Fatal("Unexpected config read ServerTLSClientCAFile() ")
+	return ""
+}
+func (c *simpleTestServerConfig) ServerTLSClientSubjects() []string {
+	c.currentTest.Fatal("Unexpected config read ServerTLSClientSubjects() ")
+	return nil
+}
+func (c *simpleTestServerConfig) AuthTokensFile() string {
+	c.currentTest.Fatal("Unexpected config read AuthTokensFile() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AuthHtpasswdFile() string {
+	c.currentTest.Fatal("Unexpected config read AuthHtpasswdFile() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AuthJWTKeyFile() string {
+	c.currentTest.Fatal("Unexpected config read AuthJWTKeyFile() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AuthJWTIssuer() string {
+	c.currentTest.Fatal("Unexpected config read AuthJWTIssuer() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AuthJWTAudience() string {
+	c.currentTest.Fatal("Unexpected config read AuthJWTAudience() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AuthJWTIdentityClaim() string {
+	c.currentTest.Fatal("Unexpected config read AuthJWTIdentityClaim() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AuthPolicyFile() string {
+	c.currentTest.Fatal("Unexpected config read AuthPolicyFile() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AuthForwardCredentials() bool { return c.forwardCredentials }
+func (c *simpleTestServerConfig) AuditFile() string {
+	c.currentTest.Fatal("Unexpected config read AuditFile() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AuditSyslogNetwork() string {
+	c.currentTest.Fatal("Unexpected config read AuditSyslogNetwork() ")
+	return ""
+}
+func (c *simpleTestServerConfig) AuditSyslogAddress() string {
+	c.currentTest.Fatal("Unexpected config read AuditSyslogAddress() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendType() string {
+	c.currentTest.Fatal("Unexpected config read BackendType() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendLocalDirectory() string {
+	c.currentTest.Fatal("Unexpected config read BackendLocalDirectory() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendS3Bucket() string {
+	c.currentTest.Fatal("Unexpected config read BackendS3Bucket() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendS3Prefix() string {
+	c.currentTest.Fatal("Unexpected config read BackendS3Prefix() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendS3Region() string {
+	c.currentTest.Fatal("Unexpected config read BackendS3Region() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendS3Endpoint() string {
+	c.currentTest.Fatal("Unexpected config read BackendS3Endpoint() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendS3PathStyle() bool {
+	c.currentTest.Fatal("Unexpected config read BackendS3PathStyle() ")
+	return false
+}
+func (c *simpleTestServerConfig) BackendS3AccessKeyID() string {
+	c.currentTest.Fatal("Unexpected config read BackendS3AccessKeyID() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendS3SecretAccessKey() string {
+	c.currentTest.Fatal("Unexpected config read BackendS3SecretAccessKey() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendPostgresDSN() string {
+	c.currentTest.Fatal("Unexpected config read BackendPostgresDSN() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendPostgresTable() string {
+	c.currentTest.Fatal("Unexpected config read BackendPostgresTable() ")
+	return ""
+}
+func (c *simpleTestServerConfig) BackendRoutes() []config.Route {
+	c.currentTest.Fatal("Unexpected config read BackendRoutes() ")
+	return nil
+}
 func (c *simpleTestServerConfig) BackendURL() string                { return c.backendURL }
 func (c *simpleTestServerConfig) BackendLockMethod() string         { return c.backendLockMethod }
 func (c *simpleTestServerConfig) BackendUnlockMethod() string       { return c.backendUnlockMethod }
@@ -450,6 +1545,41 @@ func (b simpleTestBackendClient) Send(r *retryablehttp.Request) (*http.Response,
 	return b.responseBuilder.build(), nil
 }
 
+// blockingTestBackendClient signals entered and waits for released before it responds
+type blockingTestBackendClient struct {
+	entered         chan struct{}
+	released        chan struct{}
+	responseBuilder simpleResponseBuilder
+}
+
+func (b blockingTestBackendClient) Send(r *retryablehttp.Request) (*http.Response, error) {
+	close(b.entered)
+	<-b.released
+	return b.responseBuilder.build(), nil
+}
+
+type recordingTestBackendClient struct {
+	request         *retryablehttp.Request
+	responseBuilder simpleResponseBuilder
+}
+
+func (b *recordingTestBackendClient) Send(r *retryablehttp.Request) (*http.Response, error) {
+	b.request = r
+	return b.responseBuilder.build(), nil
+}
+
+type simpleTestAuthorizer struct {
+	permission auth.Permission
+	err        error
+}
+
+func (a *simpleTestAuthorizer) Authorize(_ *http.Request, permission auth.Permission) (string, error) {
+	a.permission = permission
+	return "test-identity", a.err
+}
+
+func (a *simpleTestAuthorizer) Challenge() string { return `Basic realm="test"` }
+
 type simpleTestTransformer struct {
 	currentTest   *testing.T
 	allowToSops   bool
@@ -459,7 +1589,7 @@ type simpleTestTransformer struct {
 	err           error
 }
 
-func (t *simpleTestTransformer) ToSops(config config.TransformConfig, input []byte, handler func(result []byte)) error {
+func (t *simpleTestTransformer) ToSops(config config.TransformConfig, _ string, input []byte, handler func(result []byte)) error {
 	if !t.allowToSops {
 		t.currentTest.Fatal("Unexpected ToSops cal")
 		return fmt.Errorf("Unexpected method call")
@@ -565,3 +1695,158 @@ func (b simpleResponseBuilder) build() *http.Response {
 	}
 	return &response
 }
+
+func Test_server_newRequestHandler_audit(t *testing.T) {
+	const encryptedState = `{"version":4,"serial":12,"lineage":"8f3c","resources":"ENC[AES256_GCM,data:c2VjcmV0]",` +
+		`"sops":{"age":[{"recipient":"age17gnuhjensr0f902238xt4jkdu9qh9anhjklfn7tr8m3ex5ltxfxqt3yx08","enc":"-----BEGIN AGE ENCRYPTED FILE-----\n-----END AGE ENCRYPTED FILE-----\n"}],` +
+		`"lastmodified":"2026-01-01T00:00:00Z","mac":"ENC[mac]","version":"3.9.0"}}`
+	tests := []struct {
+		name                 string
+		method               string
+		authErr              error
+		backendStatusCode    int
+		expectedRecordStatus int
+		expectedUpstream     int
+		expectedIdentity     string
+		expectedState        bool
+		noAuthorizer         bool
+	}{
+		{
+			name:                 "POST",
+			method:               methodPost,
+			backendStatusCode:    http.StatusOK,
+			expectedRecordStatus: http.StatusOK,
+			expectedUpstream:     http.StatusOK,
+			expectedIdentity:     "test-identity",
+			expectedState:        true,
+		},
+		{
+			name:                 "LOCK conflict",
+			method:               methodLock,
+			backendStatusCode:    http.StatusConflict,
+			expectedRecordStatus: http.StatusConflict,
+			expectedUpstream:     http.StatusConflict,
+			expectedIdentity:     "test-identity",
+		},
+		{
+			name:                 "forbidden",
+			method:               methodPost,
+			authErr:              fmt.Errorf("%w: test", auth.ErrForbidden),
+			expectedRecordStatus: http.StatusForbidden,
+			expectedIdentity:     "test-identity",
+		},
+		{
+			name:                 "unauthenticated",
+			method:               methodGet,
+			authErr:              auth.ErrUnauthenticated,
+			expectedRecordStatus: http.StatusUnauthorized,
+		},
+		{
+			name:                 "without authorizer",
+			method:               methodLock,
+			noAuthorizer:         true,
+			backendStatusCode:    http.StatusOK,
+			expectedRecordStatus: http.StatusOK,
+			expectedUpstream:     http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := randConfig(t, false)
+			transformer := &simpleTestTransformer{currentTest: t, allowToSops: true, output: []byte(encryptedState)}
+			auditor := &recordingTestAuditor{}
+			s := server{
+				config:        config,
+				transformer:   transformer,
+				backend:       &recordingTestBackendClient{responseBuilder: randResponse(tt.backendStatusCode)},
+				auditor:       auditor,
+				requestLogger: config.Logger().Named("frontend"),
+			}
+			if !tt.noAuthorizer {
+				s.authorizer = &simpleTestAuthorizer{err: tt.authErr}
+			}
+			incomingRequest := randRequestBuilder(tt.method, false).buildRequest()
+			incomingRequest.SetBasicAuth("alice", "password")
+
+			s.newRequestHandler()(&simpleResponseWriter{}, incomingRequest)
+
+			require.Len(t, auditor.records, 1)
+			record := auditor.records[0]
+			assert.Equal(t, tt.method, record.Method)
+			assert.Equal(t, incomingRequest.URL.Path, record.Path)
+			assert.Equal(t, tt.expectedIdentity, record.Identity)
+			assert.Equal(t, tt.expectedIdentity != "", record.Authenticated)
+			assert.Equal(t, tt.expectedRecordStatus, record.Status)
+			assert.Equal(t, tt.expectedUpstream, record.UpstreamStatus)
+			if tt.expectedState {
+				require.NotNil(t, record.Serial)
+				assert.Equal(t, uint64(12), *record.Serial)
+				assert.Equal(t, "8f3c", record.Lineage)
+				assert.Equal(t, []string{"age:age17gnuhjensr0f902238xt4jkdu9qh9anhjklfn7tr8m3ex5ltxfxqt3yx08"}, record.KeyFingerprints)
+			} else {
+				assert.Nil(t, record.Serial)
+				assert.Empty(t, record.KeyFingerprints)
+			}
+			data, err := json.Marshal(record)
+			require.NoError(t, err)
+			assert.NotContains(t, string(data), "c2VjcmV0")
+			assert.NotContains(t, string(data), "password")
+		})
+	}
+}
+
+type recordingTestAuditor struct {
+	records []audit.Record
+}
+
+func (a *recordingTestAuditor) Audit(record audit.Record) { a.records = append(a.records, record) }
+func (a *recordingTestAuditor) Close() error              { return nil }
+
+func Test_server_newRequestHandler_plaintextLeak(t *testing.T) {
+	const state = `{"version":4,"serial":1,"lineage":"test","outputs":{"password":{"value":"simple-password-value","sensitive":true}}}`
+	tests := []struct {
+		name               string
+		encryptedState     string
+		expectsBackend     bool
+		expectedStatusCode int
+	}{
+		{
+			name:               "encrypted",
+			encryptedState:     `{"version":4,"serial":1,"lineage":"test","outputs":"ENC[AES256_GCM,data:abc]","sops":{}}`,
+			expectsBackend:     true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "leaked",
+			encryptedState:     `{"version":4,"serial":1,"lineage":"test","outputs":{"password":{"value":"simple-password-value"}},"sops":{}}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := randConfig(t, false)
+			backendClient := &recordingTestBackendClient{responseBuilder: randResponse(http.StatusOK)}
+			s := server{
+				config:        config,
+				transformer:   &simpleTestTransformer{currentTest: t, allowToSops: true, output: []byte(tt.encryptedState)},
+				backend:       backendClient,
+				requestLogger: config.Logger().Named("frontend"),
+			}
+			incomingRequestBuilder := randRequestBuilder(methodPost, false)
+			incomingRequestBuilder.requestBody = state
+			responseWriter := &simpleResponseWriter{}
+			leakAlerts := plaintextLeakAlerts(t, methodPost)
+
+			s.newRequestHandler()(responseWriter, incomingRequestBuilder.buildRequest())
+
+			assert.Equal(t, tt.expectedStatusCode, responseWriter.statusCode)
+			assert.Equal(t, tt.expectsBackend, backendClient.request != nil)
+			if !tt.expectsBackend {
+				leakAlerts++
+				assert.Contains(t, responseWriter.body.String(), "output.password")
+				assert.NotContains(t, responseWriter.body.String(), "simple-password-value")
+			}
+			assert.Equal(t, leakAlerts, plaintextLeakAlerts(t, methodPost))
+		})
+	}
+}
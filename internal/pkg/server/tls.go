@@ -0,0 +1,142 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+// newTLSConfig creates the TLS configuration of the frontend. The certificate is reloaded if its files change.
+// With a client CA file the clients have to present a certificate signed by one of the CAs and with client subjects
+// the subject of the client certificate has to be one of them.
+func newTLSConfig(config config.ServerTLSConfig, logger hclog.Logger) (*tls.Config, error) {
+	reloader, err := newCertificateReloader(config.ServerTLSCertFile(), config.ServerTLSKeyFile(), logger)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if config.ServerTLSClientCAFile() == "" {
+		return tlsConfig, nil
+	}
+	caCert, err := os.ReadFile(config.ServerTLSClientCAFile())
+	if err != nil {
+		return nil, fmt.Errorf("can not read server TLS client CA file: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("server TLS client CA file %s contains no PEM encoded certificate", config.ServerTLSClientCAFile())
+	}
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	if subjects := config.ServerTLSClientSubjects(); len(subjects) > 0 {
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+				return fmt.Errorf("no verified client certificate")
+			}
+			subject := state.VerifiedChains[0][0].Subject
+			if !isAllowedSubject(subjects, subject.String(), subject.CommonName) {
+				logger.Warn("Reject client certificate", "subject", subject.String())
+				return fmt.Errorf("client certificate subject %q is not allowed", subject.String())
+			}
+			return nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// isAllowedSubject returns true if the distinguished name or the common name is one of the allowed subjects
+func isAllowedSubject(allowed []string, distinguishedName string, commonName string) bool {
+	return slices.Contains(allowed, distinguishedName) || (commonName != "" && slices.Contains(allowed, commonName))
+}
+
+// certificateReloader loads the certificate again if the modification time of the certificate or key file changed
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	logger      hclog.Logger
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertificateReloader(certFile string, keyFile string, logger hclog.Logger) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate returns the current certificate. If the files can not be loaded the previous certificate is kept.
+func (r *certificateReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.changed() {
+		if err := r.reload(); err != nil {
+			r.logger.Error("Can not reload server TLS certificate, keep the previous one", "err", err)
+		} else {
+			r.logger.Info("Reloaded server TLS certificate", "cert-file", r.certFile)
+		}
+	}
+	return r.certificate, nil
+}
+
+func (r *certificateReloader) changed() bool {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(r.certModTime) || !keyInfo.ModTime().Equal(r.keyModTime)
+}
+
+func (r *certificateReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("can not read server TLS certificate file: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("can not read server TLS key file: %w", err)
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("can not load server TLS certificate: %w", err)
+	}
+	r.certificate = &certificate
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return nil
+}
@@ -0,0 +1,269 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newTLSConfig(t *testing.T) {
+	ca := newTestCA(t, "test-ca")
+	otherCA := newTestCA(t, "other-ca")
+	serverCert, serverKey := ca.issue(t, pkix.Name{CommonName: "localhost"}, x509.ExtKeyUsageServerAuth)
+	runnerCert, runnerKey := ca.issue(t, pkix.Name{CommonName: "runner", Organization: []string{"example"}}, x509.ExtKeyUsageClientAuth)
+	otherCert, otherKey := ca.issue(t, pkix.Name{CommonName: "other"}, x509.ExtKeyUsageClientAuth)
+	foreignCert, foreignKey := otherCA.issue(t, pkix.Name{CommonName: "runner"}, x509.ExtKeyUsageClientAuth)
+
+	type clientCert struct {
+		cert, key []byte
+	}
+	tests := []struct {
+		name           string
+		clientCA       bool
+		clientSubjects []string
+		clientCert     *clientCert
+		wantErr        bool
+	}{
+		{
+			name: "tls",
+		},
+		{
+			name:       "mtls",
+			clientCA:   true,
+			clientCert: &clientCert{runnerCert, runnerKey},
+		},
+		{
+			name:     "mtls without client certificate",
+			clientCA: true,
+			wantErr:  true,
+		},
+		{
+			name:       "mtls with client certificate of unknown CA",
+			clientCA:   true,
+			clientCert: &clientCert{foreignCert, foreignKey},
+			wantErr:    true,
+		},
+		{
+			name:           "allowed common name",
+			clientCA:       true,
+			clientSubjects: []string{"runner"},
+			clientCert:     &clientCert{runnerCert, runnerKey},
+		},
+		{
+			name:           "allowed distinguished name",
+			clientCA:       true,
+			clientSubjects: []string{"CN=runner,O=example"},
+			clientCert:     &clientCert{runnerCert, runnerKey},
+		},
+		{
+			name:           "subject not allowed",
+			clientCA:       true,
+			clientSubjects: []string{"runner"},
+			clientCert:     &clientCert{otherCert, otherKey},
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory := t.TempDir()
+			config := &testTLSConfig{
+				certFile:       writeTestFile(t, directory, "tls.crt", serverCert),
+				keyFile:        writeTestFile(t, directory, "tls.key", serverKey),
+				clientSubjects: tt.clientSubjects,
+			}
+			if tt.clientCA {
+				config.clientCAFile = writeTestFile(t, directory, "ca.crt", ca.certPEM)
+			}
+			tlsConfig, err := newTLSConfig(config, testLogger)
+			require.NoError(t, err)
+			httpServer := newTestTLSServer(tlsConfig)
+			defer httpServer.Close()
+
+			clientTLSConfig := &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"}
+			if tt.clientCert != nil {
+				certificate, err := tls.X509KeyPair(tt.clientCert.cert, tt.clientCert.key)
+				require.NoError(t, err)
+				clientTLSConfig.Certificates = []tls.Certificate{certificate}
+			}
+			resp, err := newTestTLSClient(clientTLSConfig).Get(httpServer.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_newTLSConfig_invalidFiles(t *testing.T) {
+	ca := newTestCA(t, "test-ca")
+	serverCert, serverKey := ca.issue(t, pkix.Name{CommonName: "localhost"}, x509.ExtKeyUsageServerAuth)
+	directory := t.TempDir()
+	certFile := writeTestFile(t, directory, "tls.crt", serverCert)
+	keyFile := writeTestFile(t, directory, "tls.key", serverKey)
+
+	_, err := newTLSConfig(&testTLSConfig{certFile: certFile, keyFile: filepath.Join(directory, "missing.key")}, testLogger)
+	assert.Error(t, err)
+	_, err = newTLSConfig(&testTLSConfig{certFile: certFile, keyFile: keyFile, clientCAFile: keyFile}, testLogger)
+	assert.Error(t, err)
+}
+
+func Test_certificateReloader(t *testing.T) {
+	ca := newTestCA(t, "test-ca")
+	firstCert, firstKey := ca.issue(t, pkix.Name{CommonName: "first"}, x509.ExtKeyUsageServerAuth)
+	secondCert, secondKey := ca.issue(t, pkix.Name{CommonName: "second"}, x509.ExtKeyUsageServerAuth)
+	directory := t.TempDir()
+	certFile := writeTestFile(t, directory, "tls.crt", firstCert)
+	keyFile := writeTestFile(t, directory, "tls.key", firstKey)
+
+	reloader, err := newCertificateReloader(certFile, keyFile, testLogger)
+	require.NoError(t, err)
+	assert.Equal(t, "first", leafCommonName(t, reloader))
+
+	// an inconsistent pair keeps the previous certificate
+	writeTestFileAt(t, certFile, secondCert, time.Now().Add(time.Minute))
+	assert.Equal(t, "first", leafCommonName(t, reloader))
+
+	writeTestFileAt(t, keyFile, secondKey, time.Now().Add(time.Minute))
+	assert.Equal(t, "second", leafCommonName(t, reloader))
+}
+
+func leafCommonName(t *testing.T, reloader *certificateReloader) string {
+	certificate, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func newTestTLSServer(tlsConfig *tls.Config) *httptest.Server {
+	httpServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	httpServer.TLS = tlsConfig
+	httpServer.StartTLS()
+	return httpServer
+}
+
+func newTestTLSClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+}
+
+type testCA struct {
+	certPEM     []byte
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	serial      int64
+}
+
+func newTestCA(t *testing.T, commonName string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		certificate: certificate,
+		key:         key,
+		serial:      1,
+	}
+}
+
+// issue returns a PEM encoded certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.certificate)
+	return pool
+}
+
+func writeTestFile(t *testing.T, directory string, name string, data []byte) string {
+	file := filepath.Join(directory, name)
+	require.NoError(t, os.WriteFile(file, data, 0600))
+	return file
+}
+
+// writeTestFileAt writes the file with a modification time, as some file systems have a coarse time resolution
+func writeTestFileAt(t *testing.T, file string, data []byte, modTime time.Time) {
+	require.NoError(t, os.WriteFile(file, data, 0600))
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+}
+
+type testTLSConfig struct {
+	certFile       string
+	keyFile        string
+	clientCAFile   string
+	clientSubjects []string
+}
+
+func (c *testTLSConfig) ServerTLSCertFile() string         { return c.certFile }
+func (c *testTLSConfig) ServerTLSKeyFile() string          { return c.keyFile }
+func (c *testTLSConfig) ServerTLSClientCAFile() string     { return c.clientCAFile }
+func (c *testTLSConfig) ServerTLSClientSubjects() []string { return c.clientSubjects }
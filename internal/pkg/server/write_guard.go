@@ -0,0 +1,130 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// forceWriteHeader skips the write guard for intentional writes, e.g. of "terraform state push -force"
+const forceWriteHeader = "X-Terraform-Sops-Backend-Force"
+
+const (
+	writeGuardOutcomePassed   = "passed"
+	writeGuardOutcomeRejected = "rejected"
+	writeGuardOutcomeForced   = "forced"
+)
+
+// errStateConflict is returned if a state would overwrite a stored state of another lineage or with a higher serial
+var errStateConflict = errors.New("state conflicts with the stored state")
+
+// stateRequestIgnoredHeaders are the headers of the incoming POST request not passed on to the GET of the stored state
+var stateRequestIgnoredHeaders = ignoredHeaders{
+	"Content-Length": 0,
+	"Content-Type":   0,
+	forceWriteHeader: 0,
+}
+
+// stateHeader is the serial and lineage of a terraform state, which are not encrypted
+type stateHeader struct {
+	serial  *uint64
+	lineage string
+}
+
+func parseStateHeader(state []byte) stateHeader {
+	var header stateHeader
+	var fields struct {
+		Serial  json.RawMessage `json:"serial"`
+		Lineage json.RawMessage `json:"lineage"`
+	}
+	if err := json.Unmarshal(state, &fields); err != nil {
+		return header
+	}
+	var serial uint64
+	if err := json.Unmarshal(fields.Serial, &serial); err == nil {
+		header.serial = &serial
+	}
+	var lineage string
+	if err := json.Unmarshal(fields.Lineage, &lineage); err == nil && !strings.HasPrefix(lineage, "ENC[") {
+		header.lineage = lineage
+	}
+	return header
+}
+
+// guardWrite rejects the state of the incoming POST request with errStateConflict if the state stored in the backend
+// has another lineage or a higher serial. Without write guard or with the force header every state is accepted.
+func (s server) guardWrite(incomingRequest *http.Request, state []byte) error {
+	if !s.config.WriteGuard() || len(state) == 0 {
+		return nil
+	}
+	if strings.EqualFold(incomingRequest.Header.Get(forceWriteHeader), "true") {
+		writeGuardCounter.WithLabelValues(writeGuardOutcomeForced).Inc()
+		s.requestLogger.Warn("Write guard skipped by force header", "path", incomingRequest.URL.Path)
+		return nil
+	}
+	stored, err := s.storedState(incomingRequest)
+	if err != nil {
+		return err
+	}
+	if err := checkStateHeader(parseStateHeader(stored), parseStateHeader(state)); err != nil {
+		writeGuardCounter.WithLabelValues(writeGuardOutcomeRejected).Inc()
+		return err
+	}
+	writeGuardCounter.WithLabelValues(writeGuardOutcomePassed).Inc()
+	return nil
+}
+
+// checkStateHeader compares the header of the new state with the one of the stored state. Unknown serials and
+// lineages, e.g. of a first write or of encrypted values, are not compared.
+func checkStateHeader(stored, state stateHeader) error {
+	if stored.lineage != "" && state.lineage != "" && stored.lineage != state.lineage {
+		return fmt.Errorf("%w: lineage %q differs from lineage %q of the stored state", errStateConflict, state.lineage, stored.lineage)
+	}
+	if stored.serial != nil && state.serial != nil && *state.serial < *stored.serial {
+		return fmt.Errorf("%w: serial %d is lower than serial %d of the stored state", errStateConflict, *state.serial, *stored.serial)
+	}
+	return nil
+}
+
+// storedState returns the state of the path of the incoming request stored in the backend or nil if there is none
+func (s server) storedState(incomingRequest *http.Request) ([]byte, error) {
+	request, err := retryablehttp.NewRequest(methodGet, fmt.Sprintf("%s%s", s.config.BackendURL(), s.upstreamPath(incomingRequest.URL.Path)), nil)
+	if err != nil {
+		return nil, err
+	}
+	copyHeader(incomingRequest.Header, request.Header, stateRequestIgnoredHeaders)
+	response, err := s.backend.Send(request)
+	if err != nil {
+		return nil, fmt.Errorf("can not get stored state: %w", err)
+	}
+	defer response.Body.Close()
+	body, err := readBody(response.Body, response.ContentLength, s.config.ServerMaxBodySize())
+	if err != nil {
+		return nil, fmt.Errorf("can not read stored state: %w", err)
+	}
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("can not get stored state, backend responded with status %d", response.StatusCode)
+	}
+	return body, nil
+}
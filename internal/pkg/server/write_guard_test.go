@@ -0,0 +1,185 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_server_newRequestHandler_writeGuard(t *testing.T) {
+	const storedState = `{"version":4,"serial":5,"lineage":"8f3c","resources":"ENC[AES256_GCM,data:c2VjcmV0]","sops":{}}`
+	tests := []struct {
+		name               string
+		writeGuard         bool
+		force              bool
+		storedStatusCode   int
+		storedState        string
+		state              string
+		expectsGet         bool
+		expectsPost        bool
+		expectedStatusCode int
+	}{
+		{
+			name:               "disabled",
+			storedStatusCode:   http.StatusOK,
+			storedState:        storedState,
+			state:              `{"version":4,"serial":1,"lineage":"other"}`,
+			expectsPost:        true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "higher serial",
+			writeGuard:         true,
+			storedStatusCode:   http.StatusOK,
+			storedState:        storedState,
+			state:              `{"version":4,"serial":6,"lineage":"8f3c"}`,
+			expectsGet:         true,
+			expectsPost:        true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "same serial",
+			writeGuard:         true,
+			storedStatusCode:   http.StatusOK,
+			storedState:        storedState,
+			state:              `{"version":4,"serial":5,"lineage":"8f3c"}`,
+			expectsGet:         true,
+			expectsPost:        true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "no stored state",
+			writeGuard:         true,
+			storedStatusCode:   http.StatusNotFound,
+			state:              `{"version":4,"serial":1,"lineage":"8f3c"}`,
+			expectsGet:         true,
+			expectsPost:        true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "lower serial",
+			writeGuard:         true,
+			storedStatusCode:   http.StatusOK,
+			storedState:        storedState,
+			state:              `{"version":4,"serial":4,"lineage":"8f3c"}`,
+			expectsGet:         true,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "other lineage",
+			writeGuard:         true,
+			storedStatusCode:   http.StatusOK,
+			storedState:        storedState,
+			state:              `{"version":4,"serial":6,"lineage":"other"}`,
+			expectsGet:         true,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "forced",
+			writeGuard:         true,
+			force:              true,
+			storedStatusCode:   http.StatusOK,
+			storedState:        storedState,
+			state:              `{"version":4,"serial":1,"lineage":"other"}`,
+			expectsPost:        true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "backend failure",
+			writeGuard:         true,
+			storedStatusCode:   http.StatusServiceUnavailable,
+			state:              `{"version":4,"serial":6,"lineage":"8f3c"}`,
+			expectsGet:         true,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := randConfig(t, false).(*simpleTestServerConfig)
+			serverConfig.writeGuard = tt.writeGuard
+			transformer := randAllowToSopsTransformer(t, nil)
+			backendClient := &writeGuardTestBackendClient{statusCode: tt.storedStatusCode, state: tt.storedState}
+			s := server{
+				config:        serverConfig,
+				transformer:   transformer,
+				backend:       backendClient,
+				requestLogger: serverConfig.Logger().Named("frontend"),
+			}
+			incomingRequestBuilder := randRequestBuilder(methodPost, false)
+			incomingRequestBuilder.requestBody = tt.state
+			incomingRequest := incomingRequestBuilder.buildRequest()
+			if tt.force {
+				incomingRequest.Header.Set(forceWriteHeader, "true")
+			}
+			responseWriter := &simpleResponseWriter{}
+
+			s.newRequestHandler()(responseWriter, incomingRequest)
+
+			assert.Equal(t, tt.expectedStatusCode, responseWriter.statusCode)
+			assert.Equal(t, tt.expectsGet, backendClient.get != nil)
+			if tt.expectsGet {
+				assert.Equal(t, incomingRequest.URL.Path, backendClient.get.URL.Path)
+				assert.Empty(t, backendClient.get.Header.Get("Content-Length"))
+			}
+			if assert.Equal(t, tt.expectsPost, backendClient.post != nil) && tt.expectsPost {
+				assert.Empty(t, backendClient.post.Header.Get(forceWriteHeader))
+				assert.Equal(t, []byte(tt.state), transformer.input)
+			}
+			if tt.expectedStatusCode == http.StatusConflict {
+				require.NotNil(t, responseWriter.body)
+				assert.True(t, strings.HasPrefix(responseWriter.body.String(), "Conflict: "), responseWriter.body.String())
+			}
+		})
+	}
+}
+
+func Test_checkStateHeader(t *testing.T) {
+	assert.NoError(t, checkStateHeader(parseStateHeader([]byte(`{"serial":"ENC[AES256_GCM,data:NQ==]","lineage":"ENC[AES256_GCM,data:YQ==]"}`)), parseStateHeader([]byte(`{"serial":1,"lineage":"b"}`))))
+	assert.NoError(t, checkStateHeader(parseStateHeader([]byte(`{"serial":5}`)), parseStateHeader([]byte(`{"lineage":"b"}`))))
+	assert.ErrorIs(t, checkStateHeader(parseStateHeader([]byte(`{"serial":5}`)), parseStateHeader([]byte(`{"serial":4}`))), errStateConflict)
+}
+
+// writeGuardTestBackendClient answers GET requests with the stored state and records the GET and POST requests
+type writeGuardTestBackendClient struct {
+	statusCode int
+	state      string
+	get        *retryablehttp.Request
+	post       *retryablehttp.Request
+}
+
+func (b *writeGuardTestBackendClient) Send(r *retryablehttp.Request) (*http.Response, error) {
+	statusCode, body := http.StatusOK, ""
+	switch r.Method {
+	case http.MethodGet:
+		b.get = r
+		statusCode, body = b.statusCode, b.state
+	case http.MethodPost:
+		b.post = r
+	}
+	return &http.Response{
+		StatusCode:    statusCode,
+		Header:        http.Header{},
+		Body:          io.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+	}, nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	stateSuffix = ".tfstate"
+	lockSuffix  = ".tflock"
+)
+
+// local stores each state as file below the directory. A lock is a file next to the state, which is created
+// exclusively.
+type local struct {
+	directory string
+	mutex     sync.Mutex
+}
+
+// NewLocal creates a Storage in the local directory
+func NewLocal(directory string) (Storage, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("can not create state directory: %w", err)
+	}
+	return &local{
+		directory: directory,
+	}, nil
+}
+
+func (l *local) Get(_ context.Context, statePath string) ([]byte, error) {
+	file, err := l.file(statePath, stateSuffix)
+	if err != nil {
+		return nil, err
+	}
+	state, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return state, err
+}
+
+func (l *local) Put(_ context.Context, statePath string, state []byte) error {
+	file, err := l.file(statePath, stateSuffix)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	// write to a temporary file first, so readers never see a partially written state
+	temp, err := os.CreateTemp(filepath.Dir(file), ".tfstate-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(state); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
+
+func (l *local) Delete(_ context.Context, statePath string) error {
+	file, err := l.file(statePath, stateSuffix)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *local) Lock(ctx context.Context, statePath string, lock Lock) (*Lock, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	file, err := l.file(statePath, lockSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+	lockFile, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		current, err := l.currentLock(file)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := lockFile.Write(lock.Info); err != nil {
+		lockFile.Close()
+		os.Remove(file)
+		return nil, err
+	}
+	return nil, lockFile.Close()
+}
+
+func (l *local) Unlock(_ context.Context, statePath string, id string) (*Lock, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	file, err := l.file(statePath, lockSuffix)
+	if err != nil {
+		return nil, err
+	}
+	current, err := l.currentLock(file)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	if current.ID != id {
+		return current, ErrLocked
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (l *local) CurrentLock(_ context.Context, statePath string) (*Lock, error) {
+	file, err := l.file(statePath, lockSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return l.currentLock(file)
+}
+
+func (l *local) currentLock(file string) (*Lock, error) {
+	info, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lock, err := NewLock(info)
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+func (l *local) Ping(_ context.Context) error {
+	info, err := os.Stat(l.directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", l.directory)
+	}
+	return nil
+}
+
+// file returns the file of the state path with the suffix. The state path can not leave the directory.
+func (l *local) file(statePath, suffix string) (string, error) {
+	cleaned := path.Clean("/" + statePath)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid state path %q", statePath)
+	}
+	return filepath.Join(l.directory, filepath.FromSlash(cleaned)+suffix), nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	// registers the postgres driver
+	_ "github.com/lib/pq"
+	storageConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+// postgres stores each state as row of the table. A lock is a row of the lock table with the state path as primary
+// key.
+type postgres struct {
+	db        *sql.DB
+	table     string
+	lockTable string
+}
+
+// NewPostgres creates a Storage in a PostgreSQL database. The tables are created if they do not exist.
+func NewPostgres(config storageConfig.StorageConfig) (Storage, error) {
+	db, err := sql.Open("postgres", config.BackendPostgresDSN())
+	if err != nil {
+		return nil, fmt.Errorf("can not open postgres database: %w", err)
+	}
+	p := &postgres{
+		db:        db,
+		table:     config.BackendPostgresTable(),
+		lockTable: config.BackendPostgresTable() + "_locks",
+	}
+	// the table names are validated by the configuration
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			path TEXT PRIMARY KEY,
+			state BYTEA NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`, p.table),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			path TEXT PRIMARY KEY,
+			id TEXT NOT NULL,
+			info BYTEA NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`, p.lockTable),
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("can not create postgres table: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func (p *postgres) Get(ctx context.Context, statePath string) ([]byte, error) {
+	var state []byte
+	err := p.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT state FROM %s WHERE path = $1`, p.table), statePath).
+		Scan(&state)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return state, err
+}
+
+func (p *postgres) Put(ctx context.Context, statePath string, state []byte) error {
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (path, state) VALUES ($1, $2)
+		ON CONFLICT (path) DO UPDATE SET state = EXCLUDED.state, updated_at = now()`, p.table), statePath, state)
+	return err
+}
+
+func (p *postgres) Delete(ctx context.Context, statePath string) error {
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE path = $1`, p.table), statePath)
+	return err
+}
+
+func (p *postgres) Lock(ctx context.Context, statePath string, lock Lock) (*Lock, error) {
+	result, err := p.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (path, id, info) VALUES ($1, $2, $3)
+		ON CONFLICT (path) DO NOTHING`, p.lockTable), statePath, lock.ID, lock.Info)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 1 {
+		return nil, err
+	}
+	current, err := p.CurrentLock(ctx, statePath)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		// the lock was released in the meantime
+		return p.Lock(ctx, statePath, lock)
+	}
+	return current, ErrLocked
+}
+
+func (p *postgres) Unlock(ctx context.Context, statePath string, id string) (*Lock, error) {
+	result, err := p.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE path = $1 AND id = $2`, p.lockTable),
+		statePath, id)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 1 {
+		return nil, err
+	}
+	current, err := p.CurrentLock(ctx, statePath)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	return current, ErrLocked
+}
+
+func (p *postgres) CurrentLock(ctx context.Context, statePath string) (*Lock, error) {
+	var lock Lock
+	err := p.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT id, info FROM %s WHERE path = $1`, p.lockTable), statePath).
+		Scan(&lock.ID, &lock.Info)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+func (p *postgres) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
@@ -0,0 +1,175 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	storageConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+// s3Storage stores each state as object of the bucket. A lock is an object next to the state, which is created
+// with a conditional write.
+type s3Storage struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 creates a Storage in a S3 compatible object storage
+func NewS3(config storageConfig.StorageConfig) (Storage, error) {
+	options := []func(*awsconfig.LoadOptions) error{}
+	if region := config.BackendS3Region(); region != "" {
+		options = append(options, awsconfig.WithRegion(region))
+	}
+	if accessKeyID := config.BackendS3AccessKeyID(); accessKeyID != "" {
+		options = append(options, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(accessKeyID, config.BackendS3SecretAccessKey(), ""),
+		))
+	}
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("can not load S3 configuration: %w", err)
+	}
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if endpoint := config.BackendS3Endpoint(); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = config.BackendS3PathStyle()
+		// S3 compatible storages do not support all checksum algorithms
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+	return &s3Storage{
+		client: client,
+		bucket: config.BackendS3Bucket(),
+		prefix: config.BackendS3Prefix(),
+	}, nil
+}
+
+func (s *s3Storage) Get(ctx context.Context, statePath string) ([]byte, error) {
+	return s.getObject(ctx, s.key(statePath, stateSuffix))
+}
+
+func (s *s3Storage) Put(ctx context.Context, statePath string, state []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(statePath, stateSuffix)),
+		Body:        bytes.NewReader(state),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (s *s3Storage) Delete(ctx context.Context, statePath string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(statePath, stateSuffix)),
+	})
+	return err
+}
+
+func (s *s3Storage) Lock(ctx context.Context, statePath string, lock Lock) (*Lock, error) {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(statePath, lockSuffix)),
+		Body:        bytes.NewReader(lock.Info),
+		ContentType: aws.String("application/json"),
+		// only create the lock if there is none
+		IfNoneMatch: aws.String("*"),
+	})
+	if hasStatusCode(err, http.StatusPreconditionFailed) || hasStatusCode(err, http.StatusConflict) {
+		current, err := s.CurrentLock(ctx, statePath)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrLocked
+	}
+	return nil, err
+}
+
+func (s *s3Storage) Unlock(ctx context.Context, statePath string, id string) (*Lock, error) {
+	current, err := s.CurrentLock(ctx, statePath)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	if current.ID != id {
+		return current, ErrLocked
+	}
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(statePath, lockSuffix)),
+	})
+	return nil, err
+}
+
+func (s *s3Storage) CurrentLock(ctx context.Context, statePath string) (*Lock, error) {
+	info, err := s.getObject(ctx, s.key(statePath, lockSuffix))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lock, err := NewLock(info)
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+func (s *s3Storage) Ping(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	})
+	return err
+}
+
+func (s *s3Storage) getObject(ctx context.Context, key string) ([]byte, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if hasStatusCode(err, http.StatusNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return io.ReadAll(output.Body)
+}
+
+// key returns the object key of the state path with the suffix
+func (s *s3Storage) key(statePath, suffix string) string {
+	return s.prefix + strings.TrimPrefix(path.Clean("/"+statePath), "/") + suffix
+}
+
+func hasStatusCode(err error, statusCode int) bool {
+	var responseError *awshttp.ResponseError
+	return errors.As(err, &responseError) && responseError.HTTPStatusCode() == statusCode
+}
@@ -0,0 +1,91 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	storageConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+var (
+	// ErrNotFound no state is stored for the path
+	ErrNotFound = errors.New("state not found")
+	// ErrLocked the state is locked by another lock
+	ErrLocked = errors.New("state locked")
+)
+
+// Lock of a terraform state
+type Lock struct {
+	// ID of the lock
+	ID string
+	// Info is the JSON lock information of the terraform HTTP backend protocol
+	Info []byte
+}
+
+// NewLock reads the lock from the JSON lock information of the terraform HTTP backend protocol
+func NewLock(info []byte) (Lock, error) {
+	var value struct {
+		ID string
+	}
+	if err := json.Unmarshal(info, &value); err != nil {
+		return Lock{}, fmt.Errorf("invalid lock information: %w", err)
+	}
+	if value.ID == "" {
+		return Lock{}, fmt.Errorf("lock information contains no ID")
+	}
+	return Lock{ID: value.ID, Info: info}, nil
+}
+
+// Locker locks terraform states
+type Locker interface {
+	// Lock locks the state of the path. If the state is already locked it returns the existing lock with ErrLocked.
+	Lock(ctx context.Context, path string, lock Lock) (*Lock, error)
+	// Unlock releases the lock with the ID. If the state is locked with another ID it returns the existing lock
+	// with ErrLocked. Unlocking a state without lock succeeds.
+	Unlock(ctx context.Context, path string, id string) (*Lock, error)
+	// CurrentLock returns the lock of the state or nil if the state is not locked
+	CurrentLock(ctx context.Context, path string) (*Lock, error)
+}
+
+// Storage persists terraform states
+type Storage interface {
+	Locker
+	// Get returns the state of the path or ErrNotFound
+	Get(ctx context.Context, path string) ([]byte, error)
+	// Put stores the state of the path
+	Put(ctx context.Context, path string, state []byte) error
+	// Delete removes the state of the path
+	Delete(ctx context.Context, path string) error
+	// Ping checks the connection to the storage
+	Ping(ctx context.Context) error
+}
+
+// New creates the Storage of the configured backend type
+func New(config storageConfig.StorageConfig) (Storage, error) {
+	switch config.BackendType() {
+	case storageConfig.BackendTypeLocal:
+		return NewLocal(config.BackendLocalDirectory())
+	case storageConfig.BackendTypeS3:
+		return NewS3(config)
+	case storageConfig.BackendTypePostgres:
+		return NewPostgres(config)
+	default:
+		return nil, fmt.Errorf("backend type %q has no storage", config.BackendType())
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	storageConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+func TestLocal(t *testing.T) {
+	testStorage(t, func(t *testing.T) Storage {
+		storage, err := NewLocal(t.TempDir())
+		require.NoError(t, err)
+		return storage
+	})
+}
+
+func TestLocal_pathTraversal(t *testing.T) {
+	directory := t.TempDir()
+	storage, err := NewLocal(directory + "/states")
+	require.NoError(t, err)
+
+	require.NoError(t, storage.Put(context.Background(), "/../../outside", []byte("state")))
+
+	_, err = os.Stat(directory + "/states/outside.tfstate")
+	assert.NoError(t, err)
+	_, err = storage.Get(context.Background(), "/")
+	assert.Error(t, err)
+}
+
+func TestS3(t *testing.T) {
+	testStorage(t, func(t *testing.T) Storage {
+		s3Server := httptest.NewServer(newTestS3Handler())
+		t.Cleanup(s3Server.Close)
+		storage, err := NewS3(testConfig{
+			s3Bucket:          "states",
+			s3Prefix:          "terraform/",
+			s3Region:          "eu-central-1",
+			s3Endpoint:        s3Server.URL,
+			s3PathStyle:       true,
+			s3AccessKeyID:     "access-key",
+			s3SecretAccessKey: "secret-key",
+		})
+		require.NoError(t, err)
+		return storage
+	})
+}
+
+// TestPostgres runs against the PostgreSQL database of the TEST_POSTGRES_DSN environment variable
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	testStorage(t, func(t *testing.T) Storage {
+		storage, err := NewPostgres(testConfig{
+			postgresDSN:   dsn,
+			postgresTable: fmt.Sprintf("terraform_states_%d", time.Now().UnixNano()),
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			p := storage.(*postgres)
+			_, _ = p.db.Exec(fmt.Sprintf("DROP TABLE %s, %s", p.table, p.lockTable))
+			_ = p.db.Close()
+		})
+		return storage
+	})
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  testConfig
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "local",
+			config:  testConfig{backendType: storageConfig.BackendTypeLocal, localDirectory: t.TempDir()},
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "s3",
+			config:  testConfig{backendType: storageConfig.BackendTypeS3, s3Bucket: "states", s3Region: "eu-central-1"},
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "http",
+			config:  testConfig{backendType: storageConfig.BackendTypeHTTP},
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, err := New(tt.config)
+			if tt.wantErr(t, err) && err == nil {
+				assert.NotNil(t, storage)
+			}
+		})
+	}
+}
+
+// testStorage checks the behavior every Storage has to provide
+func testStorage(t *testing.T, newStorage func(t *testing.T) Storage) {
+	ctx := context.Background()
+	lockA, err := NewLock([]byte(`{"ID":"a","Operation":"OperationTypeApply"}`))
+	require.NoError(t, err)
+	lockB, err := NewLock([]byte(`{"ID":"b","Operation":"OperationTypePlan"}`))
+	require.NoError(t, err)
+
+	t.Run("ping", func(t *testing.T) {
+		assert.NoError(t, newStorage(t).Ping(ctx))
+	})
+
+	t.Run("state", func(t *testing.T) {
+		storage := newStorage(t)
+
+		_, err := storage.Get(ctx, "/states/test")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, storage.Put(ctx, "/states/test", []byte("state-1")))
+		require.NoError(t, storage.Put(ctx, "/states/test", []byte("state-2")))
+		state, err := storage.Get(ctx, "/states/test")
+		assert.NoError(t, err)
+		assert.Equal(t, "state-2", string(state))
+
+		_, err = storage.Get(ctx, "/states/other")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, storage.Delete(ctx, "/states/test"))
+		_, err = storage.Get(ctx, "/states/test")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, storage.Delete(ctx, "/states/test"))
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		storage := newStorage(t)
+
+		current, err := storage.CurrentLock(ctx, "/states/test")
+		assert.NoError(t, err)
+		assert.Nil(t, current)
+
+		current, err = storage.Lock(ctx, "/states/test", lockA)
+		assert.NoError(t, err)
+		assert.Nil(t, current)
+
+		current, err = storage.Lock(ctx, "/states/test", lockB)
+		assert.ErrorIs(t, err, ErrLocked)
+		if assert.NotNil(t, current) {
+			assert.Equal(t, "a", current.ID)
+			assert.JSONEq(t, string(lockA.Info), string(current.Info))
+		}
+
+		current, err = storage.CurrentLock(ctx, "/states/test")
+		assert.NoError(t, err)
+		if assert.NotNil(t, current) {
+			assert.Equal(t, "a", current.ID)
+		}
+
+		// locks are independent per state
+		_, err = storage.Lock(ctx, "/states/other", lockB)
+		assert.NoError(t, err)
+
+		current, err = storage.Unlock(ctx, "/states/test", "b")
+		assert.ErrorIs(t, err, ErrLocked)
+		if assert.NotNil(t, current) {
+			assert.Equal(t, "a", current.ID)
+		}
+
+		_, err = storage.Unlock(ctx, "/states/test", "a")
+		assert.NoError(t, err)
+		_, err = storage.Unlock(ctx, "/states/test", "a")
+		assert.NoError(t, err)
+
+		_, err = storage.Lock(ctx, "/states/test", lockB)
+		assert.NoError(t, err)
+	})
+
+	t.Run("concurrent lock", func(t *testing.T) {
+		storage := newStorage(t)
+		var (
+			wg      sync.WaitGroup
+			mutex   sync.Mutex
+			granted int
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				lock, err := NewLock([]byte(fmt.Sprintf(`{"ID":"%d"}`, i)))
+				require.NoError(t, err)
+				if _, err := storage.Lock(ctx, "/states/test", lock); err == nil {
+					mutex.Lock()
+					granted++
+					mutex.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, 1, granted)
+	})
+}
+
+func TestNewLock(t *testing.T) {
+	lock, err := NewLock([]byte(`{"ID":"a"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", lock.ID)
+
+	_, err = NewLock([]byte(`{}`))
+	assert.Error(t, err)
+	_, err = NewLock([]byte(`no json`))
+	assert.Error(t, err)
+}
+
+// newTestS3Handler is an in memory stand-in of a S3 compatible object storage with path style addressing
+func newTestS3Handler() http.Handler {
+	var (
+		mutex   sync.Mutex
+		objects = map[string][]byte{}
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		switch r.Method {
+		case http.MethodHead:
+			// the bucket exists
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			object, ok := objects[key]
+			if !ok {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(object)
+		case http.MethodPut:
+			if _, ok := objects[key]; ok && r.Header.Get("If-None-Match") == "*" {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusPreconditionFailed)
+				_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			objects[key] = body
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+type testConfig struct {
+	backendType       string
+	localDirectory    string
+	s3Bucket          string
+	s3Prefix          string
+	s3Region          string
+	s3Endpoint        string
+	s3PathStyle       bool
+	s3AccessKeyID     string
+	s3SecretAccessKey string
+	postgresDSN       string
+	postgresTable     string
+}
+
+func (c testConfig) BackendType() string              { return c.backendType }
+func (c testConfig) BackendLocalDirectory() string    { return c.localDirectory }
+func (c testConfig) BackendS3Bucket() string          { return c.s3Bucket }
+func (c testConfig) BackendS3Prefix() string          { return c.s3Prefix }
+func (c testConfig) BackendS3Region() string          { return c.s3Region }
+func (c testConfig) BackendS3Endpoint() string        { return c.s3Endpoint }
+func (c testConfig) BackendS3PathStyle() bool         { return c.s3PathStyle }
+func (c testConfig) BackendS3AccessKeyID() string     { return c.s3AccessKeyID }
+func (c testConfig) BackendS3SecretAccessKey() string { return c.s3SecretAccessKey }
+func (c testConfig) BackendPostgresDSN() string       { return c.postgresDSN }
+func (c testConfig) BackendPostgresTable() string     { return c.postgresTable }
+func (c testConfig) Logger() hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{Name: "unit-test", Level: hclog.Trace, Output: os.Stderr})
+}
@@ -0,0 +1,116 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"regexp"
+
+	transformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+// creationRuleConfig returns the config of the first creation rule whose path regex matches the path or otherwise
+// the config itself
+func creationRuleConfig(config transformConfig.TransformConfig, path string) (transformConfig.TransformConfig, error) {
+	for _, rule := range config.CreationRules() {
+		pathRegex, err := regexp.Compile(rule.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("configuration failure, invalid path regex of creation rule: %w", err)
+		}
+		if pathRegex.MatchString(path) {
+			if config.Logger().IsDebug() {
+				config.Logger().Debug("apply creation rule", "path", path, "path_regex", rule.PathRegex)
+			}
+			return ruleConfig{TransformConfig: config, rule: rule}, nil
+		}
+	}
+	return config, nil
+}
+
+// ruleConfig replaces the keys and the field selection of the transform config with the ones of the creation rule. A
+// rule with keys replaces all keys, the keys it does not set are empty.
+type ruleConfig struct {
+	transformConfig.TransformConfig
+	rule transformConfig.CreationRule
+}
+
+func (c ruleConfig) hasKeys() bool {
+	return len(c.rule.AgePublicKeys) > 0 || c.rule.VaultTransitKey.Name != ""
+}
+
+func (c ruleConfig) AgePublicKeys() []string {
+	if c.hasKeys() {
+		return c.rule.AgePublicKeys
+	}
+	return c.TransformConfig.AgePublicKeys()
+}
+
+func (c ruleConfig) VaultKeyMount() string {
+	if c.hasKeys() {
+		return c.rule.VaultTransitKey.Mount
+	}
+	return c.TransformConfig.VaultKeyMount()
+}
+
+func (c ruleConfig) VaultKeyName() string {
+	if c.hasKeys() {
+		return c.rule.VaultTransitKey.Name
+	}
+	return c.TransformConfig.VaultKeyName()
+}
+
+// KeyGroups is empty for a rule with keys, so the keys of the rule form a single key group
+func (c ruleConfig) KeyGroups() []transformConfig.KeyGroup {
+	if c.hasKeys() {
+		return nil
+	}
+	return c.TransformConfig.KeyGroups()
+}
+
+func (c ruleConfig) ShamirThreshold() int {
+	if c.hasKeys() {
+		return 0
+	}
+	return c.TransformConfig.ShamirThreshold()
+}
+
+// EncryptionStrategy is "all" for a rule with encrypted regex, as the sensitive encryption strategy ignores it
+func (c ruleConfig) EncryptionStrategy() string {
+	if c.rule.EncryptedRegex != "" {
+		return transformConfig.EncryptionStrategyAll
+	}
+	return c.TransformConfig.EncryptionStrategy()
+}
+
+func (c ruleConfig) EncryptedRegex() string {
+	if c.rule.EncryptedRegex != "" {
+		return c.rule.EncryptedRegex
+	}
+	return c.TransformConfig.EncryptedRegex()
+}
+
+func (c ruleConfig) UnencryptedRegex() string {
+	if c.rule.EncryptedRegex != "" {
+		return ""
+	}
+	return c.TransformConfig.UnencryptedRegex()
+}
+
+func (c ruleConfig) UnencryptedSuffix() string {
+	if c.rule.EncryptedRegex != "" {
+		return ""
+	}
+	return c.TransformConfig.UnencryptedSuffix()
+}
@@ -0,0 +1,177 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	terraformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+func TestToSops_creationRules(t *testing.T) {
+	sandbox, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	production, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	config := testConfig{
+		agePublicKeys: []string{sandbox.Recipient().String()},
+		agePrivateKey: fmt.Sprintf("%s\n%s", sandbox.String(), production.String()),
+		creationRules: []terraformConfig.CreationRule{
+			{PathRegex: "^/states/prod/", AgePublicKeys: []string{production.Recipient().String()}, EncryptedRegex: "^outputs$"},
+			{PathRegex: "^/states/", EncryptedRegex: "^value$"},
+		},
+	}
+	tests := []struct {
+		name               string
+		path               string
+		wantRecipient      string
+		wantEncryptedRegex string
+	}{
+		{
+			name:               "first matching rule",
+			path:               "/states/prod/network",
+			wantRecipient:      production.Recipient().String(),
+			wantEncryptedRegex: "^outputs$",
+		},
+		{
+			name:               "rule without keys",
+			path:               "/states/sandbox/network",
+			wantRecipient:      sandbox.Recipient().String(),
+			wantEncryptedRegex: "^value$",
+		},
+		{
+			name:          "no matching rule",
+			path:          "/other/network",
+			wantRecipient: sandbox.Recipient().String(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var encrypted []byte
+			require.NoError(t, New().ToSops(config, tt.path, []byte(`{"version":4,"serial":1,"outputs":{"secret":{"value":"s3cr3t"}}}`), func(result []byte) { encrypted = result }))
+
+			var state struct {
+				Sops struct {
+					Age []struct {
+						Recipient string `json:"recipient"`
+					} `json:"age"`
+					EncryptedRegex string `json:"encrypted_regex"`
+				} `json:"sops"`
+			}
+			require.NoError(t, json.Unmarshal(encrypted, &state))
+			if assert.Len(t, state.Sops.Age, 1) {
+				assert.Equal(t, tt.wantRecipient, state.Sops.Age[0].Recipient)
+			}
+			assert.Equal(t, tt.wantEncryptedRegex, state.Sops.EncryptedRegex)
+			var decrypted []byte
+			assert.NoError(t, New().FromSops(config, encrypted, func(result []byte) error { decrypted = result; return nil }))
+			assert.Contains(t, string(decrypted), "s3cr3t")
+		})
+	}
+}
+
+func Test_ruleConfig(t *testing.T) {
+	config := testConfig{
+		agePublicKeys:      []string{"age1global"},
+		vaultKeyMount:      "sops",
+		vaultKeyName:       "terraform",
+		keyGroups:          []terraformConfig.KeyGroup{{Name: "a"}, {Name: "b"}},
+		shamirThreshold:    2,
+		encryptionStrategy: terraformConfig.EncryptionStrategySensitive,
+		unencryptedSuffix:  "_unencrypted",
+	}
+
+	withoutOverrides := ruleConfig{TransformConfig: config}
+	assert.Equal(t, []string{"age1global"}, withoutOverrides.AgePublicKeys())
+	assert.Equal(t, "sops", withoutOverrides.VaultKeyMount())
+	assert.Equal(t, "terraform", withoutOverrides.VaultKeyName())
+	assert.Len(t, withoutOverrides.KeyGroups(), 2)
+	assert.Equal(t, 2, withoutOverrides.ShamirThreshold())
+	assert.Equal(t, terraformConfig.EncryptionStrategySensitive, withoutOverrides.EncryptionStrategy())
+	assert.Equal(t, "_unencrypted", withoutOverrides.UnencryptedSuffix())
+
+	withVaultTransitKey := ruleConfig{TransformConfig: config, rule: terraformConfig.CreationRule{
+		VaultTransitKey: terraformConfig.VaultTransitKey{Mount: "production", Name: "states"},
+		EncryptedRegex:  "^outputs$",
+	}}
+	assert.Empty(t, withVaultTransitKey.AgePublicKeys())
+	assert.Equal(t, "production", withVaultTransitKey.VaultKeyMount())
+	assert.Equal(t, "states", withVaultTransitKey.VaultKeyName())
+	assert.Empty(t, withVaultTransitKey.KeyGroups())
+	assert.Equal(t, 0, withVaultTransitKey.ShamirThreshold())
+	assert.Equal(t, terraformConfig.EncryptionStrategyAll, withVaultTransitKey.EncryptionStrategy())
+	assert.Equal(t, "^outputs$", withVaultTransitKey.EncryptedRegex())
+	assert.Empty(t, withVaultTransitKey.UnencryptedRegex())
+	assert.Empty(t, withVaultTransitKey.UnencryptedSuffix())
+
+	withAgePublicKeys := ruleConfig{TransformConfig: config, rule: terraformConfig.CreationRule{
+		AgePublicKeys: []string{"age1production"},
+	}}
+	assert.Equal(t, []string{"age1production"}, withAgePublicKeys.AgePublicKeys())
+	assert.Empty(t, withAgePublicKeys.VaultKeyMount())
+	assert.Empty(t, withAgePublicKeys.VaultKeyName())
+	assert.Empty(t, withAgePublicKeys.KeyGroups())
+	assert.Equal(t, terraformConfig.EncryptionStrategySensitive, withAgePublicKeys.EncryptionStrategy())
+}
+
+func Test_defaultKeyGroup_creationRule(t *testing.T) {
+	global, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	production, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	config := testConfig{
+		agePublicKeys: []string{global.Recipient().String()},
+		vaultAddr:     "https://vault.test",
+		vaultKeyMount: "sops",
+		vaultKeyName:  "terraform",
+	}
+	tests := []struct {
+		name     string
+		rule     terraformConfig.CreationRule
+		wantKeys []string
+	}{
+		{
+			name:     "age only rule",
+			rule:     terraformConfig.CreationRule{AgePublicKeys: []string{production.Recipient().String()}},
+			wantKeys: []string{production.Recipient().String()},
+		},
+		{
+			name:     "vault only rule",
+			rule:     terraformConfig.CreationRule{VaultTransitKey: terraformConfig.VaultTransitKey{Mount: "production", Name: "states"}},
+			wantKeys: []string{"https://vault.test/v1/production/keys/states"},
+		},
+		{
+			name:     "rule without keys",
+			wantKeys: []string{global.Recipient().String(), "https://vault.test/v1/sops/keys/terraform"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group, err := defaultKeyGroup(ruleConfig{TransformConfig: config, rule: tt.rule})
+			require.NoError(t, err)
+
+			var keys []string
+			for _, key := range group {
+				keys = append(keys, key.ToString())
+			}
+			assert.Equal(t, tt.wantKeys, keys)
+		})
+	}
+}
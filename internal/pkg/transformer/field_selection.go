@@ -0,0 +1,159 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	transformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+var (
+	// defaultUnencryptedRegex keeps the values required to identify a state readable
+	defaultUnencryptedRegex = fmt.Sprintf(
+		"^(%s)$",
+		strings.Join([]string{
+			"version",
+			"terraform_version",
+			"serial",
+			"lineage",
+		}, "|"))
+	// nothingEncryptedRegex matches no key of a terraform state
+	nothingEncryptedRegex = "^$"
+)
+
+// fieldSelection selects the values of a state SOPS encrypts
+type fieldSelection struct {
+	encryptedRegex    string
+	unencryptedRegex  string
+	unencryptedSuffix string
+	macOnlyEncrypted  bool
+}
+
+func newFieldSelection(config transformConfig.FieldSelectionConfig, input []byte) (fieldSelection, error) {
+	switch config.EncryptionStrategy() {
+	case "", transformConfig.EncryptionStrategyAll:
+		return allFieldSelection(config), nil
+	case transformConfig.EncryptionStrategySensitive:
+		return sensitiveFieldSelection(config, input)
+	default:
+		return fieldSelection{}, fmt.Errorf("configuration failure, unknown encryption strategy %q", config.EncryptionStrategy())
+	}
+}
+
+// allFieldSelection encrypts the whole state except the configured unencrypted values
+func allFieldSelection(config transformConfig.FieldSelectionConfig) fieldSelection {
+	selection := fieldSelection{
+		encryptedRegex:    config.EncryptedRegex(),
+		unencryptedRegex:  config.UnencryptedRegex(),
+		unencryptedSuffix: config.UnencryptedSuffix(),
+		macOnlyEncrypted:  config.MACOnlyEncrypted(),
+	}
+	if selection.encryptedRegex == "" && selection.unencryptedRegex == "" && selection.unencryptedSuffix == "" {
+		selection.unencryptedRegex = defaultUnencryptedRegex
+	}
+	return selection
+}
+
+// sensitiveFieldSelection encrypts the values terraform marks as sensitive and the always encrypted keys.
+// SOPS selects by key name, so every value with the name of a sensitive value gets encrypted.
+func sensitiveFieldSelection(config transformConfig.FieldSelectionConfig, input []byte) (fieldSelection, error) {
+	keys, err := sensitiveKeys(input)
+	if err != nil {
+		return fieldSelection{}, err
+	}
+	keys = append(keys, config.AlwaysEncryptedKeys()...)
+
+	encryptedRegex := nothingEncryptedRegex
+	if quotedKeys := quoteKeys(keys); len(quotedKeys) > 0 {
+		encryptedRegex = fmt.Sprintf("^(%s)$", strings.Join(quotedKeys, "|"))
+	}
+	return fieldSelection{
+		encryptedRegex:   encryptedRegex,
+		macOnlyEncrypted: config.MACOnlyEncrypted(),
+	}, nil
+}
+
+type sensitiveState struct {
+	Outputs map[string]struct {
+		Sensitive bool `json:"sensitive"`
+	} `json:"outputs"`
+	Resources []struct {
+		Instances []struct {
+			SensitiveAttributes []json.RawMessage `json:"sensitive_attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+type sensitivePathStep struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// sensitiveKeys returns the names of the sensitive outputs and the sensitive resource attributes.
+// A sensitive attribute path is reduced to the attribute it starts with, so the whole attribute is encrypted.
+func sensitiveKeys(input []byte) ([]string, error) {
+	var state sensitiveState
+	if err := json.Unmarshal(input, &state); err != nil {
+		return nil, fmt.Errorf("can not read sensitive values of state: %w", err)
+	}
+	var keys []string
+	for name, output := range state.Outputs {
+		if output.Sensitive {
+			keys = append(keys, name)
+		}
+	}
+	for _, resource := range state.Resources {
+		for _, instance := range resource.Instances {
+			for _, rawPath := range instance.SensitiveAttributes {
+				keys = append(keys, sensitiveAttributeKey(rawPath))
+			}
+		}
+	}
+	return keys, nil
+}
+
+// sensitiveAttributeKey returns the attribute a sensitive path starts with.
+// Paths which can not be read fall back to all attributes of the instance.
+func sensitiveAttributeKey(rawPath json.RawMessage) string {
+	var path []sensitivePathStep
+	if err := json.Unmarshal(rawPath, &path); err != nil || len(path) == 0 {
+		return "attributes"
+	}
+	name, ok := path[0].Value.(string)
+	if path[0].Type != "get_attr" || !ok || name == "" {
+		return "attributes"
+	}
+	return name
+}
+
+func quoteKeys(keys []string) []string {
+	unique := map[string]struct{}{}
+	for _, key := range keys {
+		if key != "" {
+			unique[regexp.QuoteMeta(key)] = struct{}{}
+		}
+	}
+	quotedKeys := make([]string, 0, len(unique))
+	for key := range unique {
+		quotedKeys = append(quotedKeys, key)
+	}
+	sort.Strings(quotedKeys)
+	return quotedKeys
+}
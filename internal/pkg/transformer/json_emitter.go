@@ -0,0 +1,101 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/stores"
+)
+
+// emitEncryptedJSON returns the encrypted tree with its SOPS metadata as JSON like the SOPS JSON store
+func emitEncryptedJSON(tree sops.Tree) ([]byte, error) {
+	branch := make(sops.TreeBranch, 0, len(tree.Branches[0])+1)
+	branch = append(branch, tree.Branches[0]...)
+	branch = append(branch, sops.TreeItem{Key: stores.SopsMetadataKey, Value: stores.MetadataFromInternal(tree.Metadata)})
+	return emitJSON(branch)
+}
+
+// emitJSON returns the tree branch as tab indented JSON like the SOPS JSON store. The SOPS JSON store concatenates
+// strings to encode objects and arrays, which takes memory quadratic to the number of their values, e.g. the
+// resources of a large terraform state. emitJSON writes all values into a single buffer instead.
+func emitJSON(branch sops.TreeBranch) ([]byte, error) {
+	compact := bytes.NewBuffer(nil)
+	if err := encodeJSON(compact, branch); err != nil {
+		return nil, fmt.Errorf("could not marshal tree: %w", err)
+	}
+	indented := bytes.NewBuffer(make([]byte, 0, compact.Len()+compact.Len()/4))
+	if err := json.Indent(indented, compact.Bytes(), "", "\t"); err != nil {
+		return nil, fmt.Errorf("could not marshal tree: %w", err)
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
+}
+
+// encodeJSON writes the value as compact JSON to the buffer. Comments of the tree are left out.
+func encodeJSON(buffer *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case sops.TreeBranch:
+		buffer.WriteByte('{')
+		empty := true
+		for _, item := range value {
+			if _, ok := item.Key.(sops.Comment); ok {
+				continue
+			}
+			key, ok := item.Key.(string)
+			if !ok {
+				return fmt.Errorf("unexpected key %v of type %T", item.Key, item.Key)
+			}
+			if !empty {
+				buffer.WriteByte(',')
+			}
+			empty = false
+			if err := encodeJSON(buffer, key); err != nil {
+				return err
+			}
+			buffer.WriteByte(':')
+			if err := encodeJSON(buffer, item.Value); err != nil {
+				return fmt.Errorf("value of %q: %w", key, err)
+			}
+		}
+		buffer.WriteByte('}')
+	case []interface{}:
+		buffer.WriteByte('[')
+		empty := true
+		for _, item := range value {
+			if _, ok := item.(sops.Comment); ok {
+				continue
+			}
+			if !empty {
+				buffer.WriteByte(',')
+			}
+			empty = false
+			if err := encodeJSON(buffer, item); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte(']')
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buffer.Write(encoded)
+	}
+	return nil
+}
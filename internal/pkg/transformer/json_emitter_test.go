@@ -0,0 +1,87 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_emitJSON(t *testing.T) {
+	unencryptedJSON, err := os.ReadFile("fixtures/tfstates/unencrypted.tfstate")
+	require.NoError(t, err)
+	branches, err := inputStore().LoadPlainFile(unencryptedJSON)
+	require.NoError(t, err)
+	tests := []struct {
+		name   string
+		branch sops.TreeBranch
+	}{
+		{
+			name:   "terraform state",
+			branch: branches[0],
+		},
+		{
+			name: "comments, escaped and empty values",
+			branch: sops.TreeBranch{
+				{Key: sops.Comment{Value: "comment"}, Value: nil},
+				{Key: "html", Value: "<a href=\"x\">&</a>"},
+				{Key: "empty_object", Value: sops.TreeBranch{}},
+				{Key: "empty_array", Value: []interface{}{}},
+				{Key: "values", Value: []interface{}{sops.Comment{Value: "comment"}, 1.5, true, nil, sops.TreeBranch{{Key: "nested", Value: []interface{}{"a", "b"}}}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := outputStore().EmitPlainFile(sops.TreeBranches{tt.branch})
+			require.NoError(t, err)
+
+			got, err := emitJSON(tt.branch)
+
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(got))
+		})
+	}
+}
+
+func Test_emitEncryptedJSON(t *testing.T) {
+	tree := sops.Tree{
+		Branches: sops.TreeBranches{{{Key: "serial", Value: 1}, {Key: "outputs", Value: "ENC[AES256_GCM,data:test]"}}},
+		Metadata: sops.Metadata{
+			LastModified:              time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			MessageAuthenticationCode: "ENC[AES256_GCM,data:mac]",
+			UnencryptedRegex:          defaultUnencryptedRegex,
+			Version:                   "3.11.0",
+		},
+	}
+	want, err := outputStore().EmitEncryptedFile(tree)
+	require.NoError(t, err)
+
+	got, err := emitEncryptedJSON(tree)
+
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func Test_emitJSON_invalidKey(t *testing.T) {
+	_, err := emitJSON(sops.TreeBranch{{Key: 1, Value: "value"}})
+
+	assert.Error(t, err)
+}
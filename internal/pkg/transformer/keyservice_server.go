@@ -16,6 +16,8 @@ package transformer
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
 	"github.com/getsops/sops/v3/hcvault"
 	"github.com/getsops/sops/v3/keyservice"
@@ -24,99 +26,176 @@ import (
 )
 
 var (
-	keyServiceServerCache *keyServiceServer
+	keyServiceServerCache      *keyServiceServer
+	keyServiceServerCacheMutex sync.Mutex
 )
 
+// keyServiceConfig provides the access to the key services
+type keyServiceConfig interface {
+	transformConfig.VaultConfig
+	transformConfig.KMSConfig
+}
+
 type keyServiceServer struct {
 	parent      keyservice.Server
-	config      transformConfig.VaultConfig
+	config      keyServiceConfig
 	vaultClient *vaultClient
+	// awsKMSClient and gcpKMSClient replace the parent for keys of the service if a custom endpoint is configured
+	awsKMSClient *awsKMSClient
+	gcpKMSClient *gcpKMSClient
 }
 
-func cachedKeyServiceServer(config transformConfig.VaultConfig) keyservice.KeyServiceServer {
+func cachedKeyServiceServer(config keyServiceConfig) (*keyServiceServer, error) {
+	keyServiceServerCacheMutex.Lock()
+	defer keyServiceServerCacheMutex.Unlock()
 	if keyServiceServerCache == nil {
-		keyServiceServerCache = newKeyServiceServer(config, keyservice.Server{})
+		server, err := newKeyServiceServer(config, keyservice.Server{})
+		if err != nil {
+			return nil, err
+		}
+		keyServiceServerCache = server
 	}
-	return keyServiceServerCache
+	return keyServiceServerCache, nil
 }
 
-func newKeyServiceServer(config transformConfig.VaultConfig, parent keyservice.Server) *keyServiceServer {
-	vaultClient := newVaultClient(config)
-	return &keyServiceServer{
+func newKeyServiceServer(config keyServiceConfig, parent keyservice.Server) (*keyServiceServer, error) {
+	vaultClient, err := newVaultClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("can not create vault client: %w", err)
+	}
+	server := &keyServiceServer{
 		parent:      parent,
 		config:      config,
 		vaultClient: vaultClient,
 	}
+	if endpoint := config.AWSKMSEndpoint(); endpoint != "" {
+		server.awsKMSClient = newAWSKMSClient(endpoint)
+	}
+	if endpoint := config.GCPKMSEndpoint(); endpoint != "" {
+		server.gcpKMSClient = newGCPKMSClient(endpoint)
+	}
+	return server, nil
 }
 
-func (ks *keyServiceServer) encryptWithVault(key *keyservice.VaultKey, plaintext []byte) ([]byte, error) {
+func (ks *keyServiceServer) encryptWithVault(ctx context.Context, key *keyservice.VaultKey, plaintext []byte) ([]byte, error) {
 	vaultKey := hcvault.MasterKey{
 		VaultAddress: key.VaultAddress,
 		EnginePath:   key.EnginePath,
 		KeyName:      key.KeyName,
 	}
-	hcvault.Token(ks.vaultClient.getToken()).ApplyToMasterKey(&vaultKey)
+	token, err := ks.vaultClient.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hcvault.Token(token).ApplyToMasterKey(&vaultKey)
+	hcvault.NewHTTPClient(ks.vaultClient.httpClient).ApplyToMasterKey(&vaultKey)
 	timer := prometheus.NewTimer(vaultRequestDuration.WithLabelValues("encrypt"))
-	err := vaultKey.Encrypt(plaintext)
+	err = vaultKey.Encrypt(plaintext)
 	timer.ObserveDuration()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("vault transit encrypt with key %s/%s failed: %w", key.EnginePath, key.KeyName, err)
 	}
 	return []byte(vaultKey.EncryptedKey), nil
 }
 
-func (ks *keyServiceServer) decryptWithVault(key *keyservice.VaultKey, ciphertext []byte) ([]byte, error) {
+func (ks *keyServiceServer) decryptWithVault(ctx context.Context, key *keyservice.VaultKey, ciphertext []byte) ([]byte, error) {
 	vaultKey := hcvault.MasterKey{
 		VaultAddress: key.VaultAddress,
 		EnginePath:   key.EnginePath,
 		KeyName:      key.KeyName,
 	}
 	vaultKey.EncryptedKey = string(ciphertext)
-	hcvault.Token(ks.vaultClient.getToken()).ApplyToMasterKey(&vaultKey)
+	token, err := ks.vaultClient.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hcvault.Token(token).ApplyToMasterKey(&vaultKey)
+	hcvault.NewHTTPClient(ks.vaultClient.httpClient).ApplyToMasterKey(&vaultKey)
 	timer := prometheus.NewTimer(vaultRequestDuration.WithLabelValues("decrypt"))
 	plaintext, err := vaultKey.Decrypt()
 	timer.ObserveDuration()
-	return []byte(plaintext), err
+	if err != nil {
+		return nil, fmt.Errorf("vault transit decrypt with key %s/%s failed: %w", key.EnginePath, key.KeyName, err)
+	}
+	return plaintext, nil
 }
 
 func (ks *keyServiceServer) Encrypt(ctx context.Context,
 	req *keyservice.EncryptRequest) (*keyservice.EncryptResponse, error) {
 
+	timer := prometheus.NewTimer(keyServiceRequestDuration.WithLabelValues("encrypt", keyType(req.Key)))
+	defer timer.ObserveDuration()
+	var ciphertext []byte
+	var err error
 	switch k := req.Key.KeyType.(type) {
 	case *keyservice.Key_VaultKey:
-		timer := prometheus.NewTimer(keyServiceRequestDuration.WithLabelValues("encrypt", "vault"))
-		defer timer.ObserveDuration()
-		ciphertext, err := ks.encryptWithVault(k.VaultKey, req.Plaintext)
-		if err != nil {
-			return nil, err
+		ciphertext, err = ks.encryptWithVault(ctx, k.VaultKey, req.Plaintext)
+	case *keyservice.Key_KmsKey:
+		if ks.awsKMSClient == nil {
+			return ks.parent.Encrypt(ctx, req)
+		}
+		ciphertext, err = ks.awsKMSClient.encrypt(ctx, k.KmsKey, req.Plaintext)
+	case *keyservice.Key_GcpKmsKey:
+		if ks.gcpKMSClient == nil {
+			return ks.parent.Encrypt(ctx, req)
 		}
-		return &keyservice.EncryptResponse{
-			Ciphertext: ciphertext,
-		}, nil
+		ciphertext, err = ks.gcpKMSClient.encrypt(ctx, k.GcpKmsKey, req.Plaintext)
 	default:
-		timer := prometheus.NewTimer(keyServiceRequestDuration.WithLabelValues("encrypt", "age"))
-		defer timer.ObserveDuration()
 		return ks.parent.Encrypt(ctx, req)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.EncryptResponse{
+		Ciphertext: ciphertext,
+	}, nil
 }
 
 func (ks *keyServiceServer) Decrypt(ctx context.Context,
 	req *keyservice.DecryptRequest) (*keyservice.DecryptResponse, error) {
 
+	timer := prometheus.NewTimer(keyServiceRequestDuration.WithLabelValues("decrypt", keyType(req.Key)))
+	defer timer.ObserveDuration()
+	var plaintext []byte
+	var err error
 	switch k := req.Key.KeyType.(type) {
 	case *keyservice.Key_VaultKey:
-		timer := prometheus.NewTimer(keyServiceRequestDuration.WithLabelValues("decrypt", "vault"))
-		defer timer.ObserveDuration()
-		plaintext, err := ks.decryptWithVault(k.VaultKey, req.Ciphertext)
-		if err != nil {
-			return nil, err
+		plaintext, err = ks.decryptWithVault(ctx, k.VaultKey, req.Ciphertext)
+	case *keyservice.Key_KmsKey:
+		if ks.awsKMSClient == nil {
+			return ks.parent.Decrypt(ctx, req)
+		}
+		plaintext, err = ks.awsKMSClient.decrypt(ctx, k.KmsKey, req.Ciphertext)
+	case *keyservice.Key_GcpKmsKey:
+		if ks.gcpKMSClient == nil {
+			return ks.parent.Decrypt(ctx, req)
 		}
-		return &keyservice.DecryptResponse{
-			Plaintext: plaintext,
-		}, nil
+		plaintext, err = ks.gcpKMSClient.decrypt(ctx, k.GcpKmsKey, req.Ciphertext)
 	default:
-		timer := prometheus.NewTimer(keyServiceRequestDuration.WithLabelValues("decrypt", "age"))
-		defer timer.ObserveDuration()
 		return ks.parent.Decrypt(ctx, req)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{
+		Plaintext: plaintext,
+	}, nil
+}
+
+// keyType returns the key type label of the key service metrics
+func keyType(key *keyservice.Key) string {
+	switch key.KeyType.(type) {
+	case *keyservice.Key_VaultKey:
+		return "vault"
+	case *keyservice.Key_KmsKey:
+		return "aws_kms"
+	case *keyservice.Key_GcpKmsKey:
+		return "gcp_kms"
+	case *keyservice.Key_AzureKeyvaultKey:
+		return "azure_key_vault"
+	case *keyservice.Key_PgpKey:
+		return "pgp"
+	default:
+		return "age"
+	}
 }
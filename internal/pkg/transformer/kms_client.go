@@ -0,0 +1,189 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sync"
+
+	gcpKMS "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsARN "github.com/aws/aws-sdk-go-v2/aws/arn"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	awsKMS "github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/getsops/sops/v3/keyservice"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// awsKMSClient de- and encrypts data keys with an AWS KMS compatible service at a custom endpoint. The encrypted data
+// keys are base64 encoded like the ones of the SOPS AWS KMS master keys.
+type awsKMSClient struct {
+	endpoint string
+	mutex    sync.Mutex
+	clients  map[string]*awsKMS.Client
+}
+
+func newAWSKMSClient(endpoint string) *awsKMSClient {
+	return &awsKMSClient{
+		endpoint: endpoint,
+		clients:  map[string]*awsKMS.Client{},
+	}
+}
+
+func (c *awsKMSClient) encrypt(ctx context.Context, key *keyservice.KmsKey, plaintext []byte) ([]byte, error) {
+	client, err := c.client(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	output, err := client.Encrypt(ctx, &awsKMS.EncryptInput{
+		KeyId:             aws.String(key.Arn),
+		Plaintext:         plaintext,
+		EncryptionContext: key.Context,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("aws kms encrypt with key %s failed: %w", key.Arn, err)
+	}
+	return []byte(base64.StdEncoding.EncodeToString(output.CiphertextBlob)), nil
+}
+
+func (c *awsKMSClient) decrypt(ctx context.Context, key *keyservice.KmsKey, ciphertext []byte) ([]byte, error) {
+	client, err := c.client(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	ciphertextBlob, err := base64.StdEncoding.DecodeString(string(ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("can not decode data key encrypted with aws kms key %s: %w", key.Arn, err)
+	}
+	output, err := client.Decrypt(ctx, &awsKMS.DecryptInput{
+		KeyId:             aws.String(key.Arn),
+		CiphertextBlob:    ciphertextBlob,
+		EncryptionContext: key.Context,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("aws kms decrypt with key %s failed: %w", key.Arn, err)
+	}
+	return output.Plaintext, nil
+}
+
+// client returns the client for the region and profile of the key. The credentials are resolved like the ones of
+// the SOPS AWS KMS master keys, but roles can not be assumed at a custom endpoint.
+func (c *awsKMSClient) client(ctx context.Context, key *keyservice.KmsKey) (*awsKMS.Client, error) {
+	if key.Role != "" {
+		return nil, fmt.Errorf("aws kms key %s: roles are not supported with a custom endpoint", key.Arn)
+	}
+	arn, err := awsARN.Parse(key.Arn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid aws kms key ARN %q: %w", key.Arn, err)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	clientKey := arn.Region + "/" + key.AwsProfile
+	if client, ok := c.clients[clientKey]; ok {
+		return client, nil
+	}
+	options := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(arn.Region)}
+	if key.AwsProfile != "" {
+		options = append(options, awsconfig.WithSharedConfigProfile(key.AwsProfile))
+	}
+	awsConfig, err := awsconfig.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("can not load aws config: %w", err)
+	}
+	client := awsKMS.NewFromConfig(awsConfig, func(o *awsKMS.Options) {
+		o.BaseEndpoint = aws.String(c.endpoint)
+	})
+	c.clients[clientKey] = client
+	return client, nil
+}
+
+// gcpKMSClient de- and encrypts data keys with a GCP KMS compatible gRPC service at a custom endpoint. An http
+// endpoint is connected without TLS and authentication. The encrypted data keys are base64 encoded like the ones of
+// the SOPS GCP KMS master keys.
+type gcpKMSClient struct {
+	endpoint string
+	mutex    sync.Mutex
+	client   *gcpKMS.KeyManagementClient
+}
+
+func newGCPKMSClient(endpoint string) *gcpKMSClient {
+	return &gcpKMSClient{endpoint: endpoint}
+}
+
+func (c *gcpKMSClient) encrypt(ctx context.Context, key *keyservice.GcpKmsKey, plaintext []byte) ([]byte, error) {
+	client, err := c.keyManagementClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:      key.ResourceId,
+		Plaintext: plaintext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gcp kms encrypt with key %s failed: %w", key.ResourceId, err)
+	}
+	return []byte(base64.StdEncoding.EncodeToString(response.Ciphertext)), nil
+}
+
+func (c *gcpKMSClient) decrypt(ctx context.Context, key *keyservice.GcpKmsKey, ciphertext []byte) ([]byte, error) {
+	client, err := c.keyManagementClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("can not decode data key encrypted with gcp kms key %s: %w", key.ResourceId, err)
+	}
+	response, err := client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:       key.ResourceId,
+		Ciphertext: decoded,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gcp kms decrypt with key %s failed: %w", key.ResourceId, err)
+	}
+	return response.Plaintext, nil
+}
+
+func (c *gcpKMSClient) keyManagementClient(ctx context.Context) (*gcpKMS.KeyManagementClient, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	endpoint, err := url.Parse(c.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gcp kms endpoint %q: %w", c.endpoint, err)
+	}
+	options := []option.ClientOption{option.WithEndpoint(endpoint.Host)}
+	if endpoint.Scheme == "http" {
+		options = append(options,
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+	}
+	// the client outlives the context of the request
+	client, err := gcpKMS.NewKeyManagementClient(context.WithoutCancel(ctx), options...)
+	if err != nil {
+		return nil, fmt.Errorf("can not create gcp kms client: %w", err)
+	}
+	c.client = client
+	return client, nil
+}
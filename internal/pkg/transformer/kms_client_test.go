@@ -0,0 +1,179 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	terraformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+	"google.golang.org/grpc"
+)
+
+const (
+	testAWSKMSKeyARN        = "arn:aws:kms:eu-central-1:111122223333:key/terraform"
+	testGCPKMSKeyResourceID = "projects/test/locations/global/keyRings/terraform/cryptoKeys/sops"
+)
+
+func TestKMSKeyGroups(t *testing.T) {
+	resetKeyServiceServerCache()
+	defer resetKeyServiceServerCache()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	awsKMS := &fakeAWSKMS{}
+	awsKMSServer := httptest.NewServer(awsKMS)
+	defer awsKMSServer.Close()
+	gcpKMS := &fakeGCPKMS{}
+	gcpKMSAddress := serveFakeGCPKMS(t, gcpKMS)
+	config := testConfig{
+		awsKMSEndpoint: awsKMSServer.URL,
+		gcpKMSEndpoint: "http://" + gcpKMSAddress,
+		keyGroups: []terraformConfig.KeyGroup{
+			{Name: "aws", AWSKMSKeys: []terraformConfig.AWSKMSKey{{ARN: testAWSKMSKeyARN, Context: map[string]string{"purpose": "terraform"}}}},
+			{Name: "gcp", GCPKMSResourceIDs: []string{testGCPKMSKeyResourceID}},
+		},
+	}
+
+	var encrypted []byte
+	require.NoError(t, New().ToSops(config, "", []byte(`{"version":4,"serial":1,"outputs":{"secret":{"value":"s3cr3t"}}}`), func(result []byte) { encrypted = result }))
+	var state struct {
+		Sops struct {
+			KeyGroups []struct {
+				KMS []struct {
+					ARN     string            `json:"arn"`
+					Context map[string]string `json:"context"`
+				} `json:"kms"`
+				GCPKMS []struct {
+					ResourceID string `json:"resource_id"`
+				} `json:"gcp_kms"`
+			} `json:"key_groups"`
+		} `json:"sops"`
+	}
+	require.NoError(t, json.Unmarshal(encrypted, &state))
+	require.Len(t, state.Sops.KeyGroups, 2)
+	if assert.Len(t, state.Sops.KeyGroups[0].KMS, 1) {
+		assert.Equal(t, testAWSKMSKeyARN, state.Sops.KeyGroups[0].KMS[0].ARN)
+		assert.Equal(t, map[string]string{"purpose": "terraform"}, state.Sops.KeyGroups[0].KMS[0].Context)
+	}
+	if assert.Len(t, state.Sops.KeyGroups[1].GCPKMS, 1) {
+		assert.Equal(t, testGCPKMSKeyResourceID, state.Sops.KeyGroups[1].GCPKMS[0].ResourceID)
+	}
+
+	var decrypted []byte
+	assert.NoError(t, New().FromSops(config, encrypted, func(result []byte) error { decrypted = result; return nil }))
+	assert.Contains(t, string(decrypted), "s3cr3t")
+	assert.Equal(t, []string{"Encrypt", "Decrypt"}, awsKMS.operations)
+	assert.Equal(t, []string{"Encrypt", "Decrypt"}, gcpKMS.operations)
+}
+
+func Test_awsKMSClient_role(t *testing.T) {
+	_, err := newAWSKMSClient("http://localhost").encrypt(context.Background(), &keyservice.KmsKey{Arn: testAWSKMSKeyARN, Role: "arn:aws:iam::111122223333:role/sops"}, []byte("data key"))
+
+	assert.ErrorContains(t, err, "roles are not supported")
+}
+
+// fakeAWSKMS is an AWS KMS emulator, which "encrypts" with the key ARN and the encryption context as prefix
+type fakeAWSKMS struct {
+	mutex      sync.Mutex
+	operations []string
+}
+
+func (f *fakeAWSKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "TrentService.")
+	f.mutex.Lock()
+	f.operations = append(f.operations, operation)
+	f.mutex.Unlock()
+	var request struct {
+		KeyId             string
+		Plaintext         []byte
+		CiphertextBlob    []byte
+		EncryptionContext map[string]string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefix := request.KeyId + "|" + request.EncryptionContext["purpose"] + "|"
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch operation {
+	case "Encrypt":
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"KeyId":          request.KeyId,
+			"CiphertextBlob": base64.StdEncoding.EncodeToString(append([]byte(prefix), request.Plaintext...)),
+		})
+	case "Decrypt":
+		plaintext, ok := strings.CutPrefix(string(request.CiphertextBlob), prefix)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"InvalidCiphertextException","message":"invalid ciphertext"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"KeyId":     request.KeyId,
+			"Plaintext": base64.StdEncoding.EncodeToString([]byte(plaintext)),
+		})
+	default:
+		http.Error(w, "unexpected operation "+operation, http.StatusBadRequest)
+	}
+}
+
+// fakeGCPKMS is a GCP KMS emulator, which "encrypts" with the key resource ID as prefix
+type fakeGCPKMS struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+	mutex      sync.Mutex
+	operations []string
+}
+
+func (f *fakeGCPKMS) Encrypt(_ context.Context, request *kmspb.EncryptRequest) (*kmspb.EncryptResponse, error) {
+	f.record("Encrypt")
+	return &kmspb.EncryptResponse{
+		Name:       request.Name,
+		Ciphertext: append([]byte(request.Name+"|"), request.Plaintext...),
+	}, nil
+}
+
+func (f *fakeGCPKMS) Decrypt(_ context.Context, request *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+	f.record("Decrypt")
+	return &kmspb.DecryptResponse{
+		Plaintext: []byte(strings.TrimPrefix(string(request.Ciphertext), request.Name+"|")),
+	}, nil
+}
+
+func (f *fakeGCPKMS) record(operation string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.operations = append(f.operations, operation)
+}
+
+func serveFakeGCPKMS(t *testing.T, fake *fakeGCPKMS) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	kmspb.RegisterKeyManagementServiceServer(server, fake)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
@@ -0,0 +1,179 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// minLeakLength is the minimum length of a sensitive value to look for. Shorter values like "true" appear in every
+// encrypted state by chance.
+const minLeakLength = 8
+
+// ErrPlaintextLeak is returned if sensitive values of a state appear unencrypted in the encrypted state
+var ErrPlaintextLeak = errors.New("plaintext leak")
+
+// leakState holds the values of a terraform state as raw JSON, only sensitive values are decoded
+type leakState struct {
+	Outputs map[string]struct {
+		Value     json.RawMessage `json:"value"`
+		Sensitive bool            `json:"sensitive"`
+	} `json:"outputs"`
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			Attributes          json.RawMessage   `json:"attributes"`
+			SensitiveAttributes []json.RawMessage `json:"sensitive_attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// DetectLeaks returns ErrPlaintextLeak if a string value of a sensitive output or sensitive resource attribute of the
+// input state appears verbatim in the output. The error names the sensitive values by their location, never by their
+// content. An input, which is no terraform state, has no sensitive values.
+func DetectLeaks(input []byte, output []byte) error {
+	var state leakState
+	if err := json.Unmarshal(input, &state); err != nil {
+		return nil
+	}
+	var locations []string
+	check := func(location string, value interface{}) {
+		for _, text := range stringValues(value) {
+			if len(text) < minLeakLength {
+				continue
+			}
+			for _, encoded := range encodedStrings(text) {
+				if bytes.Contains(output, encoded) {
+					locations = append(locations, location)
+					return
+				}
+			}
+		}
+	}
+	for name, output := range state.Outputs {
+		var value interface{}
+		if output.Sensitive && json.Unmarshal(output.Value, &value) == nil {
+			check(fmt.Sprintf("output.%s", name), value)
+		}
+	}
+	for _, resource := range state.Resources {
+		address := strings.TrimPrefix(fmt.Sprintf("%s.%s.%s", resource.Module, resource.Type, resource.Name), ".")
+		if resource.Mode == "data" {
+			address = strings.TrimPrefix(fmt.Sprintf("%s.data.%s.%s", resource.Module, resource.Type, resource.Name), ".")
+		}
+		for i, instance := range resource.Instances {
+			if len(instance.SensitiveAttributes) == 0 {
+				continue
+			}
+			var attributes map[string]interface{}
+			if err := json.Unmarshal(instance.Attributes, &attributes); err != nil {
+				continue
+			}
+			for _, rawPath := range instance.SensitiveAttributes {
+				name, value, ok := sensitiveAttributeValue(attributes, rawPath)
+				if ok {
+					check(fmt.Sprintf("%s[%d].%s", address, i, name), value)
+				}
+			}
+		}
+	}
+	if len(locations) > 0 {
+		sort.Strings(locations)
+		return fmt.Errorf("%w: sensitive values of %s appear unencrypted", ErrPlaintextLeak, strings.Join(locations, ", "))
+	}
+	return nil
+}
+
+// AlertPlaintextLeak raises the plaintext leak alert for the request method or the operation, e.g. migrate or rotate,
+// which detected unencrypted sensitive values or terraform states
+func AlertPlaintextLeak(method string) {
+	plaintextLeakCounter.WithLabelValues(method).Inc()
+}
+
+// sensitiveAttributeValue follows the sensitive path through the attributes of a resource instance and returns the
+// name of the attribute the path starts with and the value at the end of the path
+func sensitiveAttributeValue(attributes map[string]interface{}, rawPath json.RawMessage) (string, interface{}, bool) {
+	var path []sensitivePathStep
+	if err := json.Unmarshal(rawPath, &path); err != nil || len(path) == 0 {
+		return "", nil, false
+	}
+	var value interface{} = attributes
+	for _, step := range path {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			key, ok := step.Value.(string)
+			if !ok {
+				return "", nil, false
+			}
+			value = current[key]
+		case []interface{}:
+			index, ok := step.Value.(float64)
+			if !ok || index < 0 || int(index) >= len(current) {
+				return "", nil, false
+			}
+			value = current[int(index)]
+		default:
+			return "", nil, false
+		}
+	}
+	name, _ := path[0].Value.(string)
+	return name, value, true
+}
+
+// stringValues returns all strings of the value and its nested values
+func stringValues(value interface{}) []string {
+	switch current := value.(type) {
+	case string:
+		return []string{current}
+	case map[string]interface{}:
+		var values []string
+		for _, nested := range current {
+			values = append(values, stringValues(nested)...)
+		}
+		return values
+	case []interface{}:
+		var values []string
+		for _, nested := range current {
+			values = append(values, stringValues(nested)...)
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// encodedStrings returns the JSON encodings of the text without quotes with and without escaped HTML characters
+func encodedStrings(text string) [][]byte {
+	var encodings [][]byte
+	for _, escapeHTML := range []bool{true, false} {
+		buffer := bytes.NewBuffer(nil)
+		encoder := json.NewEncoder(buffer)
+		encoder.SetEscapeHTML(escapeHTML)
+		if err := encoder.Encode(text); err != nil {
+			continue
+		}
+		encoded := bytes.TrimSuffix(buffer.Bytes(), []byte("\n"))
+		encodings = append(encodings, encoded[1:len(encoded)-1])
+	}
+	return encodings
+}
@@ -0,0 +1,140 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"os"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	terraformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+func TestDetectLeaks_toSops(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	tests := []struct {
+		name               string
+		encryptionStrategy string
+		unencryptedRegex   string
+		wantLocations      []string
+	}{
+		{
+			name: "default",
+		},
+		{
+			name:               "sensitive strategy",
+			encryptionStrategy: terraformConfig.EncryptionStrategySensitive,
+		},
+		{
+			name:             "misconfigured unencrypted regex",
+			unencryptedRegex: "^(version|terraform_version|serial|lineage|value|result)$",
+			wantLocations:    []string{"output.password", "random_password.this[0].result"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unencryptedJSON, err := os.ReadFile("fixtures/tfstates/unencrypted.tfstate")
+			require.NoError(t, err)
+			config := testConfig{
+				agePublicKeys:      []string{identity.Recipient().String()},
+				encryptionStrategy: tt.encryptionStrategy,
+				unencryptedRegex:   tt.unencryptedRegex,
+			}
+			var encryptedJSON []byte
+			require.NoError(t, New().ToSops(config, "", unencryptedJSON, func(result []byte) { encryptedJSON = result }))
+
+			err = DetectLeaks(unencryptedJSON, encryptedJSON)
+
+			if len(tt.wantLocations) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrPlaintextLeak)
+			for _, location := range tt.wantLocations {
+				assert.ErrorContains(t, err, location)
+			}
+			assert.NotContains(t, err.Error(), "simple-password-value")
+			assert.NotContains(t, err.Error(), "bcrypt_hash")
+		})
+	}
+}
+
+func TestDetectLeaks(t *testing.T) {
+	const input = `{
+		"outputs": {
+			"public": {"value": "public-value-1234", "sensitive": false},
+			"short": {"value": "short", "sensitive": true}
+		},
+		"resources": [{
+			"module": "module.db",
+			"mode": "data",
+			"type": "vault_generic_secret",
+			"name": "this",
+			"instances": [{
+				"attributes": {"data": {"users": [{"name": "admin", "password": "p<ss>&word-1234"}]}},
+				"sensitive_attributes": [
+					[{"type": "get_attr", "value": "data"}, {"type": "index", "value": {"value": "users", "type": "string"}}],
+					[{"type": "get_attr", "value": "data"}, {"type": "get_attr", "value": "users"}, {"type": "index", "value": 0}, {"type": "get_attr", "value": "password"}],
+					[{"type": "get_attr", "value": "missing"}]
+				]
+			}]
+		}]
+	}`
+	tests := []struct {
+		name    string
+		input   string
+		output  string
+		wantErr string
+	}{
+		{
+			name:   "encrypted",
+			input:  input,
+			output: `{"outputs":"ENC[AES256_GCM,data:abc]","public":"public-value-1234","short":"short"}`,
+		},
+		{
+			name:    "escaped HTML characters",
+			input:   input,
+			output:  `{"password":"p\u003css\u003e\u0026word-1234"}`,
+			wantErr: "module.db.data.vault_generic_secret.this[0].data",
+		},
+		{
+			name:    "unescaped HTML characters",
+			input:   input,
+			output:  `{"password":"p<ss>&word-1234"}`,
+			wantErr: "module.db.data.vault_generic_secret.this[0].data",
+		},
+		{
+			name:   "no terraform state",
+			input:  "no JSON",
+			output: "no JSON",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DetectLeaks([]byte(tt.input), []byte(tt.output))
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrPlaintextLeak)
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.NotContains(t, err.Error(), "word-1234")
+		})
+	}
+}
@@ -15,11 +15,17 @@
 package transformer
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 var (
+	// vaultTokenIssued holds the unix nano time of the latest vault login
+	vaultTokenIssued atomic.Int64
+
 	defBuckets           = []float64{.001, .002, .003, .004, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
 	vaultRequestDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -45,4 +51,31 @@ var (
 		},
 		[]string{"request"},
 	)
+	vaultTokenAge = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "transformer_vault_token_age_seconds",
+			Help: "Age of the current Vault token since its login.",
+		},
+		func() float64 {
+			issued := vaultTokenIssued.Load()
+			if issued == 0 {
+				return 0
+			}
+			return time.Since(time.Unix(0, issued)).Seconds()
+		},
+	)
+	vaultTokenFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "transformer_vault_token_failures_total",
+			Help: "Counter for failed Vault logins and token renewals.",
+		},
+		[]string{"operation"},
+	)
+	plaintextLeakCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_plaintext_leak_counter",
+			Help: "Counter Vector of detected unencrypted sensitive values and terraform states by request method or operation",
+		},
+		[]string{"method"},
+	)
 )
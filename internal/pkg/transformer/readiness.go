@@ -0,0 +1,78 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/getsops/sops/v3/age"
+	transformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+// ErrNotConfigured is returned by a check whose dependency is not configured
+var ErrNotConfigured = errors.New("not configured")
+
+// Checker checks the dependencies of the transformer
+type Checker interface {
+	// CheckAge returns with error if an AGE key of the config can not be parsed
+	CheckAge(config transformConfig.TransformConfig) error
+	// CheckVault returns with error if Vault is not reachable or the login fails
+	CheckVault(ctx context.Context, config transformConfig.TransformConfig) error
+}
+
+// NewChecker creates a new Checker
+func NewChecker() Checker {
+	return transform{}
+}
+
+// CheckAge parses the AGE private key and the AGE public keys of all key groups and creation rules
+func (transform) CheckAge(config transformConfig.TransformConfig) error {
+	recipients := append([]string{}, config.AgePublicKeys()...)
+	for _, keyGroup := range config.KeyGroups() {
+		recipients = append(recipients, keyGroup.AgePublicKeys...)
+	}
+	for _, rule := range config.CreationRules() {
+		recipients = append(recipients, rule.AgePublicKeys...)
+	}
+	if len(recipients) == 0 && config.AgePrivateKey() == "" {
+		return ErrNotConfigured
+	}
+	for _, recipient := range recipients {
+		if _, err := age.MasterKeysFromRecipients(recipient); err != nil {
+			return fmt.Errorf("can not parse AGE public key: %w", err)
+		}
+	}
+	if config.AgePrivateKey() != "" {
+		var identities age.ParsedIdentities
+		if err := identities.Import(config.AgePrivateKey()); err != nil {
+			return fmt.Errorf("can not parse AGE private key: %w", err)
+		}
+	}
+	return nil
+}
+
+// CheckVault requests the health of Vault and logs in if the current token has expired
+func (transform) CheckVault(ctx context.Context, config transformConfig.TransformConfig) error {
+	if config.VaultAddr() == "" {
+		return ErrNotConfigured
+	}
+	keyServiceServer, err := cachedKeyServiceServer(config)
+	if err != nil {
+		return err
+	}
+	return keyServiceServer.vaultClient.check(ctx)
+}
@@ -0,0 +1,149 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	terraformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+func TestCheckAge(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	tests := []struct {
+		name    string
+		config  testConfig
+		wantErr error
+		wantOK  bool
+	}{
+		{
+			name:    "not configured",
+			wantErr: ErrNotConfigured,
+		},
+		{
+			name:   "valid keys",
+			config: testConfig{agePublicKeys: []string{identity.Recipient().String()}, agePrivateKey: "# created: now\n" + identity.String()},
+			wantOK: true,
+		},
+		{
+			name:   "valid key group",
+			config: testConfig{keyGroups: []terraformConfig.KeyGroup{{Name: "a", AgePublicKeys: []string{identity.Recipient().String()}}}},
+			wantOK: true,
+		},
+		{
+			name:   "invalid public key",
+			config: testConfig{agePublicKeys: []string{"age1invalid"}},
+		},
+		{
+			name:   "invalid key group public key",
+			config: testConfig{keyGroups: []terraformConfig.KeyGroup{{Name: "a", AgePublicKeys: []string{"age1invalid"}}}},
+		},
+		{
+			name:   "invalid private key",
+			config: testConfig{agePublicKeys: []string{identity.Recipient().String()}, agePrivateKey: "AGE-SECRET-KEY-1INVALID"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewChecker().CheckAge(tt.config)
+
+			switch {
+			case tt.wantOK:
+				assert.NoError(t, err)
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			default:
+				assert.Error(t, err)
+				assert.NotErrorIs(t, err, ErrNotConfigured)
+			}
+		})
+	}
+}
+
+func TestCheckVault(t *testing.T) {
+	tests := []struct {
+		name          string
+		sealed        bool
+		loginStatus   int
+		wantErr       bool
+		wantLoginPath bool
+	}{
+		{
+			name:          "ready",
+			loginStatus:   http.StatusOK,
+			wantLoginPath: true,
+		},
+		{
+			name:        "sealed",
+			sealed:      true,
+			loginStatus: http.StatusOK,
+			wantErr:     true,
+		},
+		{
+			name:          "login fails",
+			loginStatus:   http.StatusForbidden,
+			wantErr:       true,
+			wantLoginPath: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetKeyServiceServerCache()
+			defer resetKeyServiceServerCache()
+			var gotLogin bool
+			vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/v1/sys/health":
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{"initialized": true, "sealed": tt.sealed})
+				case "/v1/auth/approle/login":
+					gotLogin = true
+					if tt.loginStatus != http.StatusOK {
+						http.Error(w, `{"errors":["permission denied"]}`, tt.loginStatus)
+						return
+					}
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{
+						"data": nil,
+						"auth": map[string]interface{}{"client_token": "approle-token", "lease_duration": 3600},
+					})
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer vault.Close()
+			config := testConfig{vaultAddr: vault.URL, vaultAppRoleID: "role-id", vaultAppRoleSecretID: "secret-id"}
+			keyServiceServer, err := cachedKeyServiceServer(config)
+			require.NoError(t, err)
+			keyServiceServer.vaultClient.loginBackoff = 0
+
+			err = NewChecker().CheckVault(context.Background(), config)
+
+			assert.Equal(t, tt.wantErr, err != nil, "error %v", err)
+			assert.Equal(t, tt.wantLoginPath, gotLogin)
+		})
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		assert.ErrorIs(t, NewChecker().CheckVault(context.Background(), testConfig{}), ErrNotConfigured)
+	})
+}
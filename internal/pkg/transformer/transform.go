@@ -15,28 +15,38 @@
 package transformer
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	jsonEncoding "encoding/json"
 	"fmt"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/getsops/sops/v3"
 	"github.com/getsops/sops/v3/aes"
 	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/azkv"
 	"github.com/getsops/sops/v3/cmd/sops/common"
 	"github.com/getsops/sops/v3/cmd/sops/formats"
 	sopsConfig "github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/gcpkms"
 	"github.com/getsops/sops/v3/hcvault"
 	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/kms"
+	"github.com/getsops/sops/v3/pgp"
+	"github.com/getsops/sops/v3/stores"
 	"github.com/getsops/sops/v3/stores/json"
 	"github.com/getsops/sops/v3/version"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/cache"
 	transformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
 )
 
 // SOPSTransformer encrypts to SOPS and decrypts from SOPS
 type SOPSTransformer interface {
-	ToSops(config transformConfig.TransformConfig, input []byte, handler func(result []byte)) error
+	// ToSops encrypts the state of the path with the keys of the creation rule of the path. The path is empty if the
+	// state is not stored in the backend.
+	ToSops(config transformConfig.TransformConfig, path string, input []byte, handler func(result []byte)) error
 	FromSops(config transformConfig.TransformConfig, input []byte, handler func(result []byte) error) error
 }
 
@@ -45,10 +55,21 @@ func New() SOPSTransformer {
 	return transform{}
 }
 
-type transform struct{}
+// NewCaching creates a new SOPSTransformer, which skips the key services, e.g. the Vault transit engine, with cached
+// data keys. The decrypted data keys are cached as long as the encrypted data keys of a state do not change. The
+// reusable data keys are reused to encrypt the state of the same path as long as its key groups do not change. Both
+// caches are optional.
+func NewCaching(dataKeys *cache.Cache, reusableDataKeys *cache.Cache) SOPSTransformer {
+	return transform{dataKeys: dataKeys, reusableDataKeys: reusableDataKeys}
+}
+
+type transform struct {
+	dataKeys         *cache.Cache
+	reusableDataKeys *cache.Cache
+}
 
 // ToSops transforms the input JSON data into a SOPS encrypted JSON data and hands it tho the handler
-func (transform) ToSops(config transformConfig.TransformConfig, input []byte, handler func(result []byte)) error {
+func (t transform) ToSops(config transformConfig.TransformConfig, path string, input []byte, handler func(result []byte)) error {
 	timer := prometheus.NewTimer(transformerRequestDuration.WithLabelValues("encrypt"))
 	defer timer.ObserveDuration()
 
@@ -67,29 +88,29 @@ func (transform) ToSops(config transformConfig.TransformConfig, input []byte, ha
 		return fmt.Errorf("input is already encrypted")
 	}
 
-	var group sops.KeyGroup
-
-	ageMasterKey, err := ageMasterKey(config)
+	config, err = creationRuleConfig(config, path)
 	if err != nil {
 		return err
 	}
-	group = append(group, ageMasterKey)
-
-	hcvaultMasterKey, err := hcvaultMasterKey(config)
+	groups, err := keyGroups(config)
 	if err != nil {
 		return err
 	}
-	if hcvaultMasterKey != nil {
-		group = append(group, hcvaultMasterKey)
+	if err := validateShamirThreshold(config.ShamirThreshold(), len(groups)); err != nil {
+		return err
+	}
+
+	selection, err := newFieldSelection(config, input)
+	if err != nil {
+		return err
 	}
 
 	tree := sops.Tree{
 		Branches: branches,
-		Metadata: encryptMetadata(group),
+		Metadata: encryptMetadata(groups, config.ShamirThreshold(), selection),
 	}
-	dataKey, errs := tree.GenerateDataKeyWithKeyServices([]keyservice.KeyServiceClient{keyservice.NewCustomLocalClient(cachedKeyServiceServer(config))})
-	if len(errs) > 0 {
-		err = fmt.Errorf("could not generate data key: %s", errs)
+	dataKey, err := t.newDataKey(config, path, &tree)
+	if err != nil {
 		return err
 	}
 
@@ -102,16 +123,16 @@ func (transform) ToSops(config transformConfig.TransformConfig, input []byte, ha
 		return err
 	}
 
-	result, err := outputStore.EmitEncryptedFile(tree)
+	result, err := emitEncryptedJSON(tree)
 	if err != nil {
-		return fmt.Errorf("could not marshal tree: %s", err)
+		return err
 	}
 	handler(result)
 	return nil
 }
 
 // FromSops transforms the SOPS encrypted input JSON data into a decrypted JSON data and hands it tho the handler
-func (transform) FromSops(config transformConfig.TransformConfig, input []byte, handler func(result []byte) error) error {
+func (t transform) FromSops(config transformConfig.TransformConfig, input []byte, handler func(result []byte) error) error {
 	timer := prometheus.NewTimer(transformerRequestDuration.WithLabelValues("decrypt"))
 	defer timer.ObserveDuration()
 
@@ -124,17 +145,7 @@ func (transform) FromSops(config transformConfig.TransformConfig, input []byte,
 	if err != nil {
 		return err
 	}
-	key, err := tree.Metadata.GetDataKeyWithKeyServices(
-		[]keyservice.KeyServiceClient{
-			keyservice.NewCustomLocalClient(
-				cachedKeyServiceServer(config),
-			),
-		},
-		[]string{
-			"age",
-			"hc_vault",
-		},
-	)
+	key, err := t.dataKey(config, tree.Metadata)
 	if err != nil {
 		return err
 	}
@@ -161,13 +172,159 @@ func (transform) FromSops(config transformConfig.TransformConfig, input []byte,
 		return fmt.Errorf("failed to verify data integrity. expected mac %q, got %q", originalMac, mac)
 	}
 
-	result, err := store.EmitPlainFile(tree.Branches)
+	result, err := emitJSON(tree.Branches[0])
 	if err != nil {
 		return err
 	}
 	return handler(result)
 }
 
+// newDataKey returns the data key to encrypt the tree and sets its encrypted data keys in the metadata of the tree.
+// Within the reuse window the data key of the previous encryption of the path is reused, which skips the key services.
+func (t transform) newDataKey(config transformConfig.TransformConfig, path string, tree *sops.Tree) ([]byte, error) {
+	reusable := t.reusableDataKeys != nil && path != ""
+	version := keyGroupsFingerprint(tree.Metadata)
+	if reusable {
+		if value, ok := t.reusableDataKeys.Get(path, version); ok {
+			if keyGroups, err := decodeKeyGroups(value[dataKeySize:]); err == nil {
+				tree.Metadata.KeyGroups = keyGroups
+				return value[:dataKeySize], nil
+			}
+			clear(value)
+		}
+	}
+	keyServiceServer, err := cachedKeyServiceServer(config)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, errs := tree.GenerateDataKeyWithKeyServices([]keyservice.KeyServiceClient{keyservice.NewCustomLocalClient(keyServiceServer)})
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("could not generate data key: %s", errs)
+	}
+	if reusable {
+		encodedKeyGroups, err := encodeKeyGroups(tree.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		value := append(append(make([]byte, 0, dataKeySize+len(encodedKeyGroups)), dataKey...), encodedKeyGroups...)
+		t.reusableDataKeys.Put(path, version, value)
+		clear(value)
+	}
+	return dataKey, nil
+}
+
+// keyGroupsFingerprint identifies the configured keys of the key groups
+func keyGroupsFingerprint(metadata sops.Metadata) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\x00", metadata.ShamirThreshold)
+	for _, group := range metadata.KeyGroups {
+		for _, key := range group {
+			fmt.Fprintf(hash, "%s\x00%s\x00", key.TypeToIdentifier(), key.ToString())
+		}
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// KeyFingerprints returns the type and identifier of every master key of the SOPS encrypted state, e.g.
+// "age:age1..." for an AGE recipient. They identify the keys without any secret.
+func KeyFingerprints(state []byte) ([]string, error) {
+	var encrypted struct {
+		Sops *stores.Metadata `json:"sops"`
+	}
+	if err := jsonEncoding.Unmarshal(state, &encrypted); err != nil {
+		return nil, err
+	}
+	if encrypted.Sops == nil {
+		return nil, fmt.Errorf("state has no SOPS metadata")
+	}
+	metadata, err := encrypted.Sops.ToInternal()
+	if err != nil {
+		return nil, err
+	}
+	var fingerprints []string
+	for _, group := range metadata.KeyGroups {
+		for _, key := range group {
+			fingerprints = append(fingerprints, fmt.Sprintf("%s:%s", key.TypeToIdentifier(), key.ToString()))
+		}
+	}
+	return fingerprints, nil
+}
+
+// encodeKeyGroups encodes the key groups with their encrypted data keys like the SOPS metadata of a state
+func encodeKeyGroups(metadata sops.Metadata) ([]byte, error) {
+	return jsonEncoding.Marshal(stores.MetadataFromInternal(sops.Metadata{
+		KeyGroups:       metadata.KeyGroups,
+		ShamirThreshold: metadata.ShamirThreshold,
+		LastModified:    time.Now(),
+	}))
+}
+
+func decodeKeyGroups(data []byte) ([]sops.KeyGroup, error) {
+	var metadata stores.Metadata
+	if err := jsonEncoding.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	internal, err := metadata.ToInternal()
+	if err != nil {
+		return nil, err
+	}
+	return internal.KeyGroups, nil
+}
+
+// dataKey decrypts the data key of the metadata with the key services or returns it from the data key cache
+func (t transform) dataKey(config transformConfig.TransformConfig, metadata sops.Metadata) ([]byte, error) {
+	fingerprint := dataKeyFingerprint(metadata)
+	if t.dataKeys != nil {
+		if key, ok := t.dataKeys.Get(fingerprint, ""); ok {
+			return key, nil
+		}
+	}
+	keyServiceServer, err := cachedKeyServiceServer(config)
+	if err != nil {
+		return nil, err
+	}
+	key, err := metadata.GetDataKeyWithKeyServices(
+		[]keyservice.KeyServiceClient{
+			keyservice.NewCustomLocalClient(
+				keyServiceServer,
+			),
+		},
+		[]string{
+			age.KeyTypeIdentifier,
+			hcvault.KeyTypeIdentifier,
+			kms.KeyTypeIdentifier,
+			gcpkms.KeyTypeIdentifier,
+			azkv.KeyTypeIdentifier,
+			pgp.KeyTypeIdentifier,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if t.dataKeys != nil {
+		t.dataKeys.Put(fingerprint, "", key)
+	}
+	return key, nil
+}
+
+// dataKeyFingerprint identifies the data key by all of its encrypted copies
+func dataKeyFingerprint(metadata sops.Metadata) string {
+	hash := sha256.New()
+	for _, group := range metadata.KeyGroups {
+		for _, key := range group {
+			hash.Write([]byte(key.ToString()))
+			hash.Write([]byte{0})
+			hash.Write(key.EncryptedDataKey())
+			hash.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// dataKeySize is the size of the data keys generated by SOPS
+const dataKeySize = 32
+
 func inputStore() sops.Store {
 	storesConf := sopsConfig.NewStoresConfig()
 	return json.NewStore(&storesConf.JSON)
@@ -178,58 +335,175 @@ func outputStore() sops.Store {
 	return json.NewStore(&storesConf.JSON)
 }
 
-func agePublicKey(config transformConfig.AgeConfig) (string, error) {
-	if config.AgePublicKey() == "" {
-		return "", fmt.Errorf("configuration failure, missing public AGE key")
+func agePublicKeys(config transformConfig.AgeConfig) ([]string, error) {
+	if len(config.AgePublicKeys()) == 0 {
+		return nil, fmt.Errorf("configuration failure, missing public AGE key")
 	}
-	return config.AgePublicKey(), nil
+	return config.AgePublicKeys(), nil
 }
 
-func encryptMetadata(keyGroup sops.KeyGroup) sops.Metadata {
+func encryptMetadata(keyGroups []sops.KeyGroup, shamirThreshold int, selection fieldSelection) sops.Metadata {
 	return sops.Metadata{
-		KeyGroups:         []sops.KeyGroup{keyGroup},
-		UnencryptedSuffix: "",
-		EncryptedSuffix:   "",
-		UnencryptedRegex: fmt.Sprintf(
-			"^(%s)$",
-			strings.Join([]string{
-				"version",
-				"terraform_version",
-				"serial",
-				"lineage",
-			}, "|")),
-		EncryptedRegex:          "",
+		KeyGroups:               keyGroups,
+		UnencryptedSuffix:       selection.unencryptedSuffix,
+		EncryptedSuffix:         "",
+		UnencryptedRegex:        selection.unencryptedRegex,
+		EncryptedRegex:          selection.encryptedRegex,
 		UnencryptedCommentRegex: "",
 		EncryptedCommentRegex:   "",
-		MACOnlyEncrypted:        false,
+		MACOnlyEncrypted:        selection.macOnlyEncrypted,
 		Version:                 version.Version,
-		ShamirThreshold:         0,
+		ShamirThreshold:         shamirThreshold,
+	}
+}
+
+func validateShamirThreshold(shamirThreshold int, numberOfGroups int) error {
+	if shamirThreshold > numberOfGroups {
+		return fmt.Errorf("configuration failure, shamir threshold %d exceeds number of key groups %d", shamirThreshold, numberOfGroups)
 	}
+	// SOPS requires at least two shares to split the data key
+	if numberOfGroups > 1 && shamirThreshold == 1 {
+		return fmt.Errorf("configuration failure, shamir threshold must be 0 or at least 2 for %d key groups", numberOfGroups)
+	}
+	return nil
 }
 
-func ageMasterKey(config transformConfig.AgeConfig) (*age.MasterKey, error) {
-	agePublicKey, err := agePublicKey(config)
+func keyGroups(config transformConfig.TransformConfig) ([]sops.KeyGroup, error) {
+	if len(config.KeyGroups()) == 0 {
+		group, err := defaultKeyGroup(config)
+		if err != nil {
+			return nil, err
+		}
+		return []sops.KeyGroup{group}, nil
+	}
+	var groups []sops.KeyGroup
+	for _, keyGroupConfig := range config.KeyGroups() {
+		group, err := keyGroup(config, keyGroupConfig)
+		if err != nil {
+			return nil, fmt.Errorf("key group %q: %w", keyGroupConfig.Name, err)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// defaultKeyGroup returns the AGE keys and the Vault transit key of the config as a single key group. The AGE keys are
+// optional with Vault transit key, e.g. for a creation rule with a Vault transit key only.
+func defaultKeyGroup(config transformConfig.TransformConfig) (sops.KeyGroup, error) {
+	var group sops.KeyGroup
+
+	hcvaultMasterKey, err := hcvaultMasterKey(config)
 	if err != nil {
 		return nil, err
 	}
-	ageKeys, err := age.MasterKeysFromRecipients(agePublicKey)
+
+	if len(config.AgePublicKeys()) > 0 || hcvaultMasterKey == nil {
+		ageMasterKeys, err := ageMasterKeys(config)
+		if err != nil {
+			return nil, err
+		}
+		for _, ageMasterKey := range ageMasterKeys {
+			group = append(group, ageMasterKey)
+		}
+	}
+
+	if hcvaultMasterKey != nil {
+		group = append(group, hcvaultMasterKey)
+	}
+	return group, nil
+}
+
+func keyGroup(config transformConfig.VaultConfig, keyGroupConfig transformConfig.KeyGroup) (sops.KeyGroup, error) {
+	var group sops.KeyGroup
+
+	for _, agePublicKey := range keyGroupConfig.AgePublicKeys {
+		ageKeys, err := age.MasterKeysFromRecipients(agePublicKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, ageKey := range ageKeys {
+			group = append(group, ageKey)
+		}
+	}
+
+	for _, vaultTransitKey := range keyGroupConfig.VaultTransitKeys {
+		if config.VaultAddr() == "" {
+			return nil, fmt.Errorf("configuration failure, missing vault address for transit key %s/%s", vaultTransitKey.Mount, vaultTransitKey.Name)
+		}
+		hcvaultMasterKey, err := hcvaultTransitMasterKey(config.VaultAddr(), vaultTransitKey.Mount, vaultTransitKey.Name)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, hcvaultMasterKey)
+	}
+
+	for _, awsKMSKey := range keyGroupConfig.AWSKMSKeys {
+		group = append(group, awsKMSMasterKey(awsKMSKey))
+	}
+
+	for _, resourceID := range keyGroupConfig.GCPKMSResourceIDs {
+		group = append(group, gcpkms.NewMasterKeyFromResourceID(resourceID))
+	}
+
+	for _, keyURL := range keyGroupConfig.AzureKeyVaultKeyURLs {
+		// a key URL without version looks up the latest version of the key
+		azkvMasterKey, err := azkv.NewMasterKeyFromURL(keyURL)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, azkvMasterKey)
+	}
+
+	for _, fingerprint := range keyGroupConfig.PGPFingerprints {
+		group = append(group, pgp.NewMasterKeyFromFingerprint(fingerprint))
+	}
+
+	if len(group) < 1 {
+		return nil, fmt.Errorf("configuration failure, expected number of keys >= 1 found %v", len(group))
+	}
+	return group, nil
+}
+
+func awsKMSMasterKey(key transformConfig.AWSKMSKey) *kms.MasterKey {
+	encryptionContext := make(map[string]*string, len(key.Context))
+	for name, value := range key.Context {
+		encryptionContext[name] = &value
+	}
+	return kms.NewMasterKeyWithProfile(key.ARN, key.Role, encryptionContext, key.Profile)
+}
+
+func ageMasterKeys(config transformConfig.AgeConfig) ([]*age.MasterKey, error) {
+	agePublicKeys, err := agePublicKeys(config)
 	if err != nil {
 		return nil, err
 	}
+	var result []*age.MasterKey
+	for _, agePublicKey := range agePublicKeys {
+		// every entry may hold a comma separated list of recipients
+		ageKeys, err := age.MasterKeysFromRecipients(agePublicKey)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ageKeys...)
+	}
 
-	if len(ageKeys) != 1 {
-		return nil, fmt.Errorf("configuration failure, expected number of age keys = 1 found %v", len(ageKeys))
+	if len(result) < 1 {
+		return nil, fmt.Errorf("configuration failure, expected number of age keys >= 1 found %v", len(result))
 	}
 
-	return ageKeys[0], nil
+	return result, nil
 }
 
 func hcvaultMasterKey(config transformConfig.VaultConfig) (*hcvault.MasterKey, error) {
 	vaultAddr := config.VaultAddr()
-	if vaultAddr == "" {
+	if vaultAddr == "" || config.VaultKeyName() == "" {
 		return nil, nil
 	}
-	hcVaultKeys, err := hcvault.NewMasterKeysFromURIs(fmt.Sprintf("%s/v1/%s/keys/%s", vaultAddr, config.VaultKeyMount(), config.VaultKeyName()))
+	return hcvaultTransitMasterKey(vaultAddr, config.VaultKeyMount(), config.VaultKeyName())
+}
+
+func hcvaultTransitMasterKey(vaultAddr, keyMount, keyName string) (*hcvault.MasterKey, error) {
+	hcVaultKeys, err := hcvault.NewMasterKeysFromURIs(fmt.Sprintf("%s/v1/%s/keys/%s", vaultAddr, keyMount, keyName))
 	if err != nil {
 		return nil, err
 	}
@@ -19,11 +19,16 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"strings"
 	"testing"
+	"time"
 
+	"filippo.io/age"
 	"github.com/hashicorp/go-hclog"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	"github.com/wtschreiter/terraformsopsbackend/internal/pkg/cache"
 	terraformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
 )
 
@@ -59,10 +64,16 @@ func TestToSops(t *testing.T) {
 	if !assert.NotEmpty(t, vaultKeyName, "require TRANSFORM_VAULT_TRANSIT_NAME") {
 		return
 	}
+	otherAgeIdentity, err := age.GenerateX25519Identity()
+	if !assert.NoError(t, err) {
+		return
+	}
+	otherAgePublicKey := otherAgeIdentity.Recipient().String()
+	otherAgePrivateKey := otherAgeIdentity.String()
 	transformer := New()
 	tests := []struct {
 		name                 string
-		agePublicKey         string
+		agePublicKeys        []string
 		agePrivateKey        string
 		vaultAddr            string
 		vaultAppRoleID       string
@@ -73,7 +84,22 @@ func TestToSops(t *testing.T) {
 	}{
 		{
 			name:          "plain positive age",
-			agePublicKey:  agePublicKey,
+			agePublicKeys: []string{agePublicKey},
+			agePrivateKey: agePrivateKey,
+		},
+		{
+			name:          "positive multiple age recipients",
+			agePublicKeys: []string{agePublicKey, otherAgePublicKey},
+			agePrivateKey: agePrivateKey,
+		},
+		{
+			name:          "positive multiple age recipients decrypt with other key",
+			agePublicKeys: []string{agePublicKey, otherAgePublicKey},
+			agePrivateKey: otherAgePrivateKey,
+		},
+		{
+			name:          "positive comma separated age recipients",
+			agePublicKeys: []string{fmt.Sprintf("%s,%s", otherAgePublicKey, agePublicKey)},
 			agePrivateKey: agePrivateKey,
 		},
 		{
@@ -81,13 +107,33 @@ func TestToSops(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name:         "error on corrupted public age key",
-			agePublicKey: "this-is-not-a-public-age-key",
-			wantErr:      true,
+			name:          "error on empty public age key",
+			agePublicKeys: []string{""},
+			wantErr:       true,
+		},
+		{
+			name:          "error on corrupted public age key",
+			agePublicKeys: []string{"this-is-not-a-public-age-key"},
+			wantErr:       true,
+		},
+		{
+			name:          "error on one corrupted public age key in multiple recipients",
+			agePublicKeys: []string{agePublicKey, "this-is-not-a-public-age-key"},
+			wantErr:       true,
 		},
 		{
 			name:                 "plain positive vault",
-			agePublicKey:         agePublicKey,
+			agePublicKeys:        []string{agePublicKey},
+			vaultAddr:            vaultAddr,
+			vaultAppRoleID:       vaultAppRoleID,
+			vaultAppRoleSecretID: vaultAppRoleSecretID,
+			vaultKeyMount:        vaultKeyMount,
+			vaultKeyName:         vaultKeyName,
+		},
+		{
+			name:                 "positive multiple age recipients and vault",
+			agePublicKeys:        []string{agePublicKey, otherAgePublicKey},
+			agePrivateKey:        otherAgePrivateKey,
 			vaultAddr:            vaultAddr,
 			vaultAppRoleID:       vaultAppRoleID,
 			vaultAppRoleSecretID: vaultAppRoleSecretID,
@@ -96,7 +142,7 @@ func TestToSops(t *testing.T) {
 		},
 		{
 			name:                 "error on missing vault key mount",
-			agePublicKey:         agePublicKey,
+			agePublicKeys:        []string{agePublicKey},
 			vaultAddr:            vaultAddr,
 			vaultAppRoleID:       vaultAppRoleID,
 			vaultAppRoleSecretID: vaultAppRoleSecretID,
@@ -107,6 +153,7 @@ func TestToSops(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			resetKeyServiceServerCache()
 			var err error
 			var unencryptedJSON []byte
 			var unencryptedTFState tfstate
@@ -115,7 +162,7 @@ func TestToSops(t *testing.T) {
 			var decryptedJSON []byte
 			var decryptedTFState tfstate
 			var config terraformConfig.TransformConfig = newConfig(
-				tt.agePublicKey,
+				tt.agePublicKeys,
 				tt.agePrivateKey,
 				tt.vaultAddr,
 				tt.vaultAppRoleID,
@@ -135,7 +182,7 @@ func TestToSops(t *testing.T) {
 			}
 
 			// Act
-			if err = transformer.ToSops(config, unencryptedJSON, func(sopsResult []byte) { encryptedJSON = sopsResult }); (err != nil) != tt.wantErr {
+			if err = transformer.ToSops(config, "", unencryptedJSON, func(sopsResult []byte) { encryptedJSON = sopsResult }); (err != nil) != tt.wantErr {
 				t.Errorf("TransformToSops() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
@@ -155,6 +202,17 @@ func TestToSops(t *testing.T) {
 			assert.Equal(t, unencryptedTFState.Serial, encryptedTFState.Serial)
 			assert.Equal(t, unencryptedTFState.Lineage, encryptedTFState.Lineage)
 			assert.NotEqual(t, unencryptedTFState.Outputs.Password.Value, encryptedTFState.Outputs.Password.Value)
+			if assert.NotNil(t, encryptedTFState.Sops) {
+				var expectedRecipients []string
+				for _, agePublicKey := range tt.agePublicKeys {
+					expectedRecipients = append(expectedRecipients, strings.Split(agePublicKey, ",")...)
+				}
+				var gotRecipients []string
+				for _, ageKey := range encryptedTFState.Sops.Age {
+					gotRecipients = append(gotRecipients, ageKey.Recipient)
+				}
+				assert.ElementsMatch(t, expectedRecipients, gotRecipients)
+			}
 
 			// Act reverse
 			err = transformer.FromSops(config, encryptedJSON, func(result []byte) error { decryptedJSON = result; return nil })
@@ -206,7 +264,7 @@ func TestFromSops(t *testing.T) {
 	}
 	transformer := New()
 	withVaultToSops := newConfig(
-		agePublicKey,
+		[]string{agePublicKey},
 		"",
 		vaultAddr,
 		vaultAppRoleID,
@@ -215,7 +273,7 @@ func TestFromSops(t *testing.T) {
 		vaultKeyName,
 	)
 	withoutVaultToSops := newConfig(
-		agePublicKey,
+		[]string{agePublicKey},
 		"",
 		"",
 		"",
@@ -254,6 +312,7 @@ func TestFromSops(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			resetKeyServiceServerCache()
 			var err error
 			var unencryptedJSON []byte
 			var unencryptedTFState tfstate
@@ -261,7 +320,7 @@ func TestFromSops(t *testing.T) {
 			var decryptedJSON []byte
 			var decryptedTFState tfstate
 			var config terraformConfig.TransformConfig = newConfig(
-				"",
+				nil,
 				tt.agePrivateKey,
 				tt.vaultAddr,
 				tt.vaultAppRoleID,
@@ -279,7 +338,7 @@ func TestFromSops(t *testing.T) {
 			if !assert.NoError(t, err) {
 				return
 			}
-			err = transformer.ToSops(tt.toSopsConfig, unencryptedJSON, func(sopsResult []byte) { encryptedJSON = sopsResult })
+			err = transformer.ToSops(tt.toSopsConfig, "", unencryptedJSON, func(sopsResult []byte) { encryptedJSON = sopsResult })
 			if !assert.NoError(t, err) {
 				return
 			}
@@ -303,6 +362,552 @@ func TestFromSops(t *testing.T) {
 	}
 }
 
+func TestFromSops_dataKeyCache(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if !assert.NoError(t, err) {
+		return
+	}
+	config := testConfig{agePublicKeys: []string{identity.Recipient().String()}, agePrivateKey: identity.String()}
+	var encrypted []byte
+	if !assert.NoError(t, New().ToSops(config, "", []byte(`{"version":4,"serial":1,"outputs":{"secret":{"value":"s3cr3t"}}}`), func(result []byte) { encrypted = result })) {
+		return
+	}
+	dataKeys := cache.New(t.Name(), 1024, time.Minute)
+	transformer := NewCaching(dataKeys, nil)
+	var decrypted []byte
+	handler := func(result []byte) error { decrypted = result; return nil }
+
+	assert.NoError(t, transformer.FromSops(config, encrypted, handler))
+	assert.Contains(t, string(decrypted), "s3cr3t")
+
+	// without private key only the cached data key can decrypt the state
+	withoutPrivateKey := testConfig{agePublicKeys: config.agePublicKeys}
+	decrypted = nil
+	assert.NoError(t, transformer.FromSops(withoutPrivateKey, encrypted, handler))
+	assert.Contains(t, string(decrypted), "s3cr3t")
+	assert.Error(t, New().FromSops(withoutPrivateKey, encrypted, handler))
+}
+
+func TestToSops_dataKeyReuse(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if !assert.NoError(t, err) {
+		return
+	}
+	otherIdentity, err := age.GenerateX25519Identity()
+	if !assert.NoError(t, err) {
+		return
+	}
+	config := testConfig{agePublicKeys: []string{identity.Recipient().String()}, agePrivateKey: identity.String()}
+	otherConfig := testConfig{agePublicKeys: []string{otherIdentity.Recipient().String()}, agePrivateKey: otherIdentity.String()}
+	transformer := NewCaching(nil, cache.New(t.Name(), 1<<20, time.Minute))
+	encrypt := func(config testConfig, transformer SOPSTransformer, path string) string {
+		var encrypted []byte
+		assert.NoError(t, transformer.ToSops(config, path, []byte(`{"version":4,"serial":1,"outputs":{"secret":{"value":"s3cr3t"}}}`), func(result []byte) { encrypted = result }))
+		var decrypted []byte
+		assert.NoError(t, New().FromSops(config, encrypted, func(result []byte) error { decrypted = result; return nil }))
+		assert.Contains(t, string(decrypted), "s3cr3t")
+		var state struct {
+			Sops struct {
+				Age []struct {
+					Enc string `json:"enc"`
+				} `json:"age"`
+			} `json:"sops"`
+		}
+		assert.NoError(t, json.Unmarshal(encrypted, &state))
+		if assert.Len(t, state.Sops.Age, 1) {
+			return state.Sops.Age[0].Enc
+		}
+		return ""
+	}
+
+	first := encrypt(config, transformer, "/states/a")
+	assert.Equal(t, first, encrypt(config, transformer, "/states/a"), "data key of the same path must be reused")
+	assert.NotEqual(t, first, encrypt(config, transformer, "/states/b"), "data key of another path must not be reused")
+	assert.NotEqual(t, first, encrypt(config, transformer, ""), "data key without path must not be reused")
+	assert.NotEqual(t, first, encrypt(otherConfig, transformer, "/states/a"), "data key of changed key groups must not be reused")
+	assert.NotEqual(t, first, encrypt(config, New(), "/states/a"), "data key must not be reused without cache")
+}
+
+func TestToSops_pgp(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	gnupgHome := t.TempDir()
+	t.Setenv("GNUPGHOME", gnupgHome)
+	defer func() { _ = exec.Command("gpgconf", "--kill", "gpg-agent").Run() }()
+	if output, err := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "terraform@example.org", "default", "default", "never").CombinedOutput(); !assert.NoError(t, err, string(output)) {
+		return
+	}
+	output, err := exec.Command("gpg", "--batch", "--with-colons", "--list-keys", "terraform@example.org").Output()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var fingerprint string
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && fingerprint == "" {
+			fingerprint = fields[9]
+		}
+	}
+	config := testConfig{keyGroups: []terraformConfig.KeyGroup{{Name: "pgp", PGPFingerprints: []string{fingerprint}}}}
+
+	var encrypted []byte
+	if !assert.NoError(t, New().ToSops(config, "", []byte(`{"version":4,"serial":1,"outputs":{"secret":{"value":"s3cr3t"}}}`), func(result []byte) { encrypted = result })) {
+		return
+	}
+	assert.Contains(t, string(encrypted), fingerprint)
+	var decrypted []byte
+	assert.NoError(t, New().FromSops(config, encrypted, func(result []byte) error { decrypted = result; return nil }))
+	assert.Contains(t, string(decrypted), "s3cr3t")
+}
+
+func TestKeyGroups(t *testing.T) {
+	if !assert.NoError(t, godotenv.Load("../../../.testenv")) {
+		return
+	}
+	agePublicKey := os.Getenv("TRANSFORM_AGE_PUBLIC_KEY")
+	if !assert.NotEmpty(t, agePublicKey, "require TRANSFORM_AGE_PUBLIC_KEY") {
+		return
+	}
+	agePrivateKey := os.Getenv("TRANSFORM_AGE_PRIVATE_KEY")
+	if !assert.NotEmpty(t, agePrivateKey, "require TRANSFORM_AGE_PRIVATE_KEY") {
+		return
+	}
+	vaultAddr := os.Getenv("TRANSFORM_VAULT_ADDRESS")
+	if !assert.NotEmpty(t, vaultAddr, "require TRANSFORM_VAULT_ADDRESS") {
+		return
+	}
+	vaultAppRoleID := os.Getenv("TRANSFORM_VAULT_APP_ROLE_ID")
+	if !assert.NotEmpty(t, vaultAppRoleID, "require TRANSFORM_VAULT_APP_ROLE_ID") {
+		return
+	}
+	vaultAppRoleSecretID := os.Getenv("TRANSFORM_VAULT_APP_ROLE_SECRET_ID")
+	if !assert.NotEmpty(t, vaultAppRoleSecretID, "require TRANSFORM_VAULT_APP_ROLE_SECRET_ID") {
+		return
+	}
+	vaultKeyMount := os.Getenv("TRANSFORM_VAULT_TRANSIT_MOUNT")
+	if !assert.NotEmpty(t, vaultKeyMount, "require TRANSFORM_VAULT_TRANSIT_MOUNT") {
+		return
+	}
+	vaultKeyName := os.Getenv("TRANSFORM_VAULT_TRANSIT_NAME")
+	if !assert.NotEmpty(t, vaultKeyName, "require TRANSFORM_VAULT_TRANSIT_NAME") {
+		return
+	}
+	otherAgeIdentity, err := age.GenerateX25519Identity()
+	if !assert.NoError(t, err) {
+		return
+	}
+	otherAgePublicKey := otherAgeIdentity.Recipient().String()
+	otherAgePrivateKey := otherAgeIdentity.String()
+	operators := terraformConfig.KeyGroup{
+		Name:          "age operators",
+		AgePublicKeys: []string{agePublicKey},
+	}
+	breakGlass := terraformConfig.KeyGroup{
+		Name:          "break glass",
+		AgePublicKeys: []string{otherAgePublicKey},
+	}
+	vaultTransit := terraformConfig.KeyGroup{
+		Name: "vault transit",
+		VaultTransitKeys: []terraformConfig.VaultTransitKey{
+			{Mount: vaultKeyMount, Name: vaultKeyName},
+		},
+	}
+	transformer := New()
+	tests := []struct {
+		name              string
+		keyGroups         []terraformConfig.KeyGroup
+		shamirThreshold   int
+		agePrivateKey     string
+		toSopsWithVault   bool
+		fromSopsWithVault bool
+		wantEncryptErr    bool
+		wantDecryptErr    bool
+		wantKeyGroupCount int
+	}{
+		{
+			name:              "two age groups require both keys",
+			keyGroups:         []terraformConfig.KeyGroup{operators, breakGlass},
+			shamirThreshold:   2,
+			agePrivateKey:     fmt.Sprintf("%s\n%s", agePrivateKey, otherAgePrivateKey),
+			wantKeyGroupCount: 2,
+		},
+		{
+			name:              "error on two age groups decrypt with one key",
+			keyGroups:         []terraformConfig.KeyGroup{operators, breakGlass},
+			shamirThreshold:   2,
+			agePrivateKey:     agePrivateKey,
+			wantDecryptErr:    true,
+			wantKeyGroupCount: 2,
+		},
+		{
+			name:              "three groups threshold two decrypt with two keys",
+			keyGroups:         []terraformConfig.KeyGroup{operators, breakGlass, vaultTransit},
+			shamirThreshold:   2,
+			agePrivateKey:     fmt.Sprintf("%s\n%s", otherAgePrivateKey, agePrivateKey),
+			toSopsWithVault:   true,
+			wantKeyGroupCount: 3,
+		},
+		{
+			name:              "age and vault groups require both keys",
+			keyGroups:         []terraformConfig.KeyGroup{operators, vaultTransit},
+			shamirThreshold:   2,
+			agePrivateKey:     agePrivateKey,
+			toSopsWithVault:   true,
+			fromSopsWithVault: true,
+			wantKeyGroupCount: 2,
+		},
+		{
+			name:            "error on threshold exceeding key groups",
+			keyGroups:       []terraformConfig.KeyGroup{operators, breakGlass},
+			shamirThreshold: 3,
+			wantEncryptErr:  true,
+		},
+		{
+			name:            "error on threshold one with multiple key groups",
+			keyGroups:       []terraformConfig.KeyGroup{operators, breakGlass},
+			shamirThreshold: 1,
+			wantEncryptErr:  true,
+		},
+		{
+			name:           "error on empty key group",
+			keyGroups:      []terraformConfig.KeyGroup{operators, {Name: "empty"}},
+			wantEncryptErr: true,
+		},
+		{
+			name:           "error on vault transit key without vault address",
+			keyGroups:      []terraformConfig.KeyGroup{operators, vaultTransit},
+			wantEncryptErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetKeyServiceServerCache()
+			var err error
+			var unencryptedJSON []byte
+			var unencryptedTFState tfstate
+			var encryptedJSON []byte
+			var encryptedTFState tfstate
+			var decryptedJSON []byte
+			var decryptedTFState tfstate
+			toSopsConfig := testConfig{
+				keyGroups:       tt.keyGroups,
+				shamirThreshold: tt.shamirThreshold,
+			}
+			fromSopsConfig := testConfig{
+				agePrivateKey: tt.agePrivateKey,
+			}
+			if tt.toSopsWithVault {
+				toSopsConfig.vaultAddr = vaultAddr
+				toSopsConfig.vaultAppRoleID = vaultAppRoleID
+				toSopsConfig.vaultAppRoleSecretID = vaultAppRoleSecretID
+			}
+			if tt.fromSopsWithVault {
+				fromSopsConfig.vaultAddr = vaultAddr
+				fromSopsConfig.vaultAppRoleID = vaultAppRoleID
+				fromSopsConfig.vaultAppRoleSecretID = vaultAppRoleSecretID
+			}
+
+			// Prepare
+			unencryptedJSON, err = os.ReadFile("fixtures/tfstates/unencrypted.tfstate")
+			if !assert.NoError(t, err) {
+				return
+			}
+			err = json.Unmarshal(unencryptedJSON, &unencryptedTFState)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// Act
+			if err = transformer.ToSops(toSopsConfig, "", unencryptedJSON, func(sopsResult []byte) { encryptedJSON = sopsResult }); (err != nil) != tt.wantEncryptErr {
+				t.Errorf("TransformToSops() error = %v, wantErr %v", err, tt.wantEncryptErr)
+				return
+			}
+			if tt.wantEncryptErr {
+				return
+			}
+
+			// Validate result
+			err = json.Unmarshal(encryptedJSON, &encryptedTFState)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if assert.NotNil(t, encryptedTFState.Sops) {
+				assert.Len(t, encryptedTFState.Sops.KeyGroups, tt.wantKeyGroupCount)
+				assert.Equal(t, tt.shamirThreshold, encryptedTFState.Sops.ShamirThreshold)
+			}
+
+			// Act reverse
+			if err = transformer.FromSops(fromSopsConfig, encryptedJSON, func(result []byte) error { decryptedJSON = result; return nil }); (err != nil) != tt.wantDecryptErr {
+				t.Errorf("TransformFromSops() error = %v, wantErr %v", err, tt.wantDecryptErr)
+				return
+			}
+			if tt.wantDecryptErr {
+				return
+			}
+
+			// Validate reverse
+			err = json.Unmarshal(decryptedJSON, &decryptedTFState)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, unencryptedTFState, decryptedTFState)
+		})
+	}
+}
+
+func TestFieldSelection(t *testing.T) {
+	if !assert.NoError(t, godotenv.Load("../../../.testenv")) {
+		return
+	}
+	agePublicKey := os.Getenv("TRANSFORM_AGE_PUBLIC_KEY")
+	if !assert.NotEmpty(t, agePublicKey, "require TRANSFORM_AGE_PUBLIC_KEY") {
+		return
+	}
+	agePrivateKey := os.Getenv("TRANSFORM_AGE_PRIVATE_KEY")
+	if !assert.NotEmpty(t, agePrivateKey, "require TRANSFORM_AGE_PRIVATE_KEY") {
+		return
+	}
+	transformer := New()
+	tests := []struct {
+		name                string
+		encryptionStrategy  string
+		alwaysEncryptedKeys []string
+		encryptedRegex      string
+		unencryptedRegex    string
+		unencryptedSuffix   string
+		macOnlyEncrypted    bool
+		wantPlain           [][]interface{}
+		wantEncrypted       [][]interface{}
+	}{
+		{
+			name: "default selection",
+			wantPlain: [][]interface{}{
+				{"version"},
+				{"serial"},
+				{"lineage"},
+			},
+			wantEncrypted: [][]interface{}{
+				{"outputs", "password", "value"},
+				{"resources", 0, "type"},
+				{"resources", 0, "instances", 0, "attributes", "result"},
+			},
+		},
+		{
+			name:           "encrypted regex",
+			encryptedRegex: "^(attributes|outputs)$",
+			wantPlain: [][]interface{}{
+				{"serial"},
+				{"resources", 0, "type"},
+				{"resources", 0, "provider"},
+			},
+			wantEncrypted: [][]interface{}{
+				{"outputs", "password", "value"},
+				{"resources", 0, "instances", 0, "attributes", "result"},
+			},
+		},
+		{
+			name:             "unencrypted regex",
+			unencryptedRegex: "^(version|terraform_version|serial|lineage|mode|type|name|provider)$",
+			wantPlain: [][]interface{}{
+				{"lineage"},
+				{"resources", 0, "mode"},
+				{"resources", 0, "name"},
+			},
+			wantEncrypted: [][]interface{}{
+				{"outputs", "password", "value"},
+				{"resources", 0, "instances", 0, "attributes", "result"},
+			},
+		},
+		{
+			name:              "unencrypted suffix",
+			unencryptedSuffix: "_version",
+			wantPlain: [][]interface{}{
+				{"terraform_version"},
+				{"resources", 0, "instances", 0, "schema_version"},
+			},
+			wantEncrypted: [][]interface{}{
+				{"serial"},
+				{"outputs", "password", "value"},
+			},
+		},
+		{
+			name:               "sensitive strategy",
+			encryptionStrategy: terraformConfig.EncryptionStrategySensitive,
+			wantPlain: [][]interface{}{
+				{"serial"},
+				{"lineage"},
+				{"resources", 0, "type"},
+				{"resources", 0, "instances", 0, "attributes", "id"},
+				{"resources", 0, "instances", 0, "attributes", "length"},
+				{"resources", 0, "instances", 0, "sensitive_attributes"},
+			},
+			wantEncrypted: [][]interface{}{
+				{"outputs", "password", "value"},
+				{"resources", 0, "instances", 0, "attributes", "result"},
+				{"resources", 0, "instances", 0, "attributes", "bcrypt_hash"},
+			},
+		},
+		{
+			name:                "sensitive strategy with always encrypted keys",
+			encryptionStrategy:  terraformConfig.EncryptionStrategySensitive,
+			alwaysEncryptedKeys: []string{"id"},
+			macOnlyEncrypted:    true,
+			wantPlain: [][]interface{}{
+				{"serial"},
+				{"resources", 0, "instances", 0, "attributes", "length"},
+			},
+			wantEncrypted: [][]interface{}{
+				{"outputs", "password", "value"},
+				{"resources", 0, "instances", 0, "attributes", "id"},
+				{"resources", 0, "instances", 0, "attributes", "result"},
+			},
+		},
+		{
+			name:             "MAC only encrypted",
+			encryptedRegex:   "^(attributes|outputs)$",
+			macOnlyEncrypted: true,
+			wantPlain: [][]interface{}{
+				{"resources", 0, "type"},
+			},
+			wantEncrypted: [][]interface{}{
+				{"outputs", "password", "value"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetKeyServiceServerCache()
+			var err error
+			var unencryptedJSON []byte
+			var unencryptedState map[string]interface{}
+			var encryptedJSON []byte
+			var encryptedState map[string]interface{}
+			var decryptedJSON []byte
+			var decryptedState map[string]interface{}
+			config := testConfig{
+				agePublicKeys:       []string{agePublicKey},
+				agePrivateKey:       agePrivateKey,
+				encryptionStrategy:  tt.encryptionStrategy,
+				alwaysEncryptedKeys: tt.alwaysEncryptedKeys,
+				encryptedRegex:      tt.encryptedRegex,
+				unencryptedRegex:    tt.unencryptedRegex,
+				unencryptedSuffix:   tt.unencryptedSuffix,
+				macOnlyEncrypted:    tt.macOnlyEncrypted,
+			}
+
+			// Prepare
+			unencryptedJSON, err = os.ReadFile("fixtures/tfstates/unencrypted.tfstate")
+			if !assert.NoError(t, err) {
+				return
+			}
+			err = json.Unmarshal(unencryptedJSON, &unencryptedState)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// Act
+			err = transformer.ToSops(config, "", unencryptedJSON, func(sopsResult []byte) { encryptedJSON = sopsResult })
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// Validate result
+			err = json.Unmarshal(encryptedJSON, &encryptedState)
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, path := range tt.wantPlain {
+				assert.Equal(t, valueAt(unencryptedState, path...), valueAt(encryptedState, path...), "expected plain value at %v", path)
+			}
+			for _, path := range tt.wantEncrypted {
+				assert.True(t, isEncryptedValue(valueAt(encryptedState, path...)), "expected encrypted value at %v", path)
+			}
+
+			// Act reverse
+			err = transformer.FromSops(config, encryptedJSON, func(result []byte) error { decryptedJSON = result; return nil })
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// Validate reverse
+			err = json.Unmarshal(decryptedJSON, &decryptedState)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, unencryptedState, decryptedState)
+		})
+	}
+}
+
+func Test_sensitiveKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "no sensitive values",
+			input: `{"version":4,"outputs":{"name":{"value":"x","type":"string"}},"resources":[{"instances":[{"sensitive_attributes":[]}]}]}`,
+		},
+		{
+			name:  "sensitive output",
+			input: `{"outputs":{"name":{"value":"x"},"token":{"value":"y","sensitive":true}}}`,
+			want:  []string{"token"},
+		},
+		{
+			name:  "nested sensitive attribute",
+			input: `{"resources":[{"instances":[{"sensitive_attributes":[[{"type":"get_attr","value":"settings"},{"type":"index","value":{"value":0,"type":"number"}},{"type":"get_attr","value":"password"}]]}]}]}`,
+			want:  []string{"settings"},
+		},
+		{
+			name:  "unreadable sensitive attribute path",
+			input: `{"resources":[{"instances":[{"sensitive_attributes":[[{"type":"index","value":{"value":0,"type":"number"}}]]}]}]}`,
+			want:  []string{"attributes"},
+		},
+		{
+			name:    "error on invalid JSON",
+			input:   `{"resources":`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sensitiveKeys([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("sensitiveKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+}
+
+func valueAt(value interface{}, path ...interface{}) interface{} {
+	for _, element := range path {
+		switch key := element.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			value = object[key]
+		case int:
+			list, ok := value.([]interface{})
+			if !ok || key >= len(list) {
+				return nil
+			}
+			value = list[key]
+		}
+	}
+	return value
+}
+
+func isEncryptedValue(value interface{}) bool {
+	text, ok := value.(string)
+	return ok && strings.HasPrefix(text, "ENC[")
+}
+
 type tfstate struct {
 	Version          int    `json:"version"`
 	TerraformVersion string `json:"terraform_version"`
@@ -315,6 +920,13 @@ type tfstate struct {
 	} `json:"outputs"`
 	Resources    []map[string]interface{} `json:"resources"`
 	CheckResults []map[string]interface{} `json:"check_results"`
+	Sops         *struct {
+		Age []struct {
+			Recipient string `json:"recipient"`
+		} `json:"age"`
+		KeyGroups       []map[string]interface{} `json:"key_groups"`
+		ShamirThreshold int                      `json:"shamir_threshold"`
+	} `json:"sops,omitempty"`
 }
 
 var (
@@ -323,25 +935,75 @@ var (
 
 type testConfig struct {
 	agePrivateKey        string
-	agePublicKey         string
+	agePublicKeys        []string
 	vaultAddr            string
+	vaultAuthMethod      string
+	vaultAuthMount       string
+	vaultAuthRole        string
+	vaultAuthJWT         string
+	vaultAuthJWTFile     string
+	vaultToken           string
 	vaultAppRoleID       string
 	vaultAppRoleSecretID string
+	vaultNamespace       string
+	vaultCACert          []byte
+	vaultClientCert      []byte
+	vaultClientKey       []byte
+	vaultTLSServerName   string
 	vaultKeyMount        string
 	vaultKeyName         string
+	awsKMSEndpoint       string
+	gcpKMSEndpoint       string
+	keyGroups            []terraformConfig.KeyGroup
+	shamirThreshold      int
+	encryptionStrategy   string
+	alwaysEncryptedKeys  []string
+	encryptedRegex       string
+	unencryptedRegex     string
+	unencryptedSuffix    string
+	macOnlyEncrypted     bool
+	creationRules        []terraformConfig.CreationRule
 }
 
 func (c testConfig) AgePrivateKey() string        { return c.agePrivateKey }
-func (c testConfig) AgePublicKey() string         { return c.agePublicKey }
+func (c testConfig) AgePublicKeys() []string      { return c.agePublicKeys }
 func (c testConfig) VaultAddr() string            { return c.vaultAddr }
+func (c testConfig) VaultAuthMethod() string      { return c.vaultAuthMethod }
+func (c testConfig) VaultAuthMount() string       { return c.vaultAuthMount }
+func (c testConfig) VaultAuthRole() string        { return c.vaultAuthRole }
+func (c testConfig) VaultAuthJWT() string         { return c.vaultAuthJWT }
+func (c testConfig) VaultAuthJWTFile() string     { return c.vaultAuthJWTFile }
+func (c testConfig) VaultToken() string           { return c.vaultToken }
 func (c testConfig) VaultAppRoleID() string       { return c.vaultAppRoleID }
 func (c testConfig) VaultAppRoleSecretID() string { return c.vaultAppRoleSecretID }
+func (c testConfig) VaultNamespace() string       { return c.vaultNamespace }
+func (c testConfig) VaultCACert() []byte          { return c.vaultCACert }
+func (c testConfig) VaultClientCert() []byte      { return c.vaultClientCert }
+func (c testConfig) VaultClientKey() []byte       { return c.vaultClientKey }
+func (c testConfig) VaultTLSServerName() string   { return c.vaultTLSServerName }
 func (c testConfig) VaultKeyMount() string        { return c.vaultKeyMount }
 func (c testConfig) VaultKeyName() string         { return c.vaultKeyName }
+func (c testConfig) AWSKMSEndpoint() string       { return c.awsKMSEndpoint }
+func (c testConfig) GCPKMSEndpoint() string       { return c.gcpKMSEndpoint }
 func (c testConfig) Logger() hclog.Logger         { return testLogger }
+func (c testConfig) KeyGroups() []terraformConfig.KeyGroup {
+	return c.keyGroups
+}
+func (c testConfig) CreationRules() []terraformConfig.CreationRule {
+	return c.creationRules
+}
+func (c testConfig) ShamirThreshold() int       { return c.shamirThreshold }
+func (c testConfig) EncryptionStrategy() string { return c.encryptionStrategy }
+func (c testConfig) AlwaysEncryptedKeys() []string {
+	return c.alwaysEncryptedKeys
+}
+func (c testConfig) EncryptedRegex() string    { return c.encryptedRegex }
+func (c testConfig) UnencryptedRegex() string  { return c.unencryptedRegex }
+func (c testConfig) UnencryptedSuffix() string { return c.unencryptedSuffix }
+func (c testConfig) MACOnlyEncrypted() bool    { return c.macOnlyEncrypted }
 
 func newConfig(
-	agePublicKey,
+	agePublicKeys []string,
 	agePrivateKey,
 	vaultAddr,
 	vaultAppRoleID,
@@ -351,7 +1013,7 @@ func newConfig(
 ) terraformConfig.TransformConfig {
 	return testConfig{
 		agePrivateKey:        agePrivateKey,
-		agePublicKey:         agePublicKey,
+		agePublicKeys:        agePublicKeys,
 		vaultAddr:            vaultAddr,
 		vaultAppRoleID:       vaultAppRoleID,
 		vaultAppRoleSecretID: vaultAppRoleSecretID,
@@ -369,3 +1031,10 @@ func newTestLogger() hclog.Logger {
 		JSONFormat:        false,
 	})
 }
+
+// resetKeyServiceServerCache drops the key service server of a previous test, which may use another vault config
+func resetKeyServiceServerCache() {
+	keyServiceServerCacheMutex.Lock()
+	defer keyServiceServerCacheMutex.Unlock()
+	keyServiceServerCache = nil
+}
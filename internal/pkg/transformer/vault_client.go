@@ -16,9 +16,18 @@ package transformer
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault-client-go"
 	"github.com/hashicorp/vault-client-go/schema"
@@ -26,62 +35,388 @@ import (
 	transformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
 )
 
+const (
+	kubernetesServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	vaultNamespaceHeader              = "X-Vault-Namespace"
+	vaultLoginRetries                 = 3
+	vaultLoginBackoff                 = time.Second
+	// tokens are replaced this long before the end of their lease
+	vaultTokenExpiryMargin = 60 * time.Second
+)
+
+// vaultClient manages the vault token used for the transit requests. The token is renewed in the background as
+// long as vault allows it and is replaced by a new login once it expires. It is safe for concurrent use.
 type vaultClient struct {
-	client           *vault.Client
-	ctx              context.Context
-	appRoleID        string
-	appRoleSecretID  string
-	appRoleMountPath string
-	token            string
-	tokenUntil       time.Time
-	logger           hclog.Logger
+	client       *vault.Client
+	httpClient   *http.Client
+	ctx          context.Context
+	login        vaultLogin
+	loginRetries int
+	loginBackoff time.Duration
+	mutex        sync.Mutex
+	token        string
+	tokenUntil   time.Time
+	pendingLogin *vaultPendingLogin
+	logger       hclog.Logger
+}
+
+// vaultPendingLogin is a login in progress, which all callers without valid token wait for
+type vaultPendingLogin struct {
+	done  chan struct{}
+	token string
+	err   error
 }
 
-func newVaultClient(config transformConfig.VaultConfig) *vaultClient {
+// vaultLogin authenticates with vault and returns the auth information of the new token
+type vaultLogin func(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error)
+
+func newVaultClient(config transformConfig.VaultConfig) (*vaultClient, error) {
+	httpClient, err := newVaultHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
 	client, err := vault.New(
 		vault.WithAddress(config.VaultAddr()),
+		vault.WithHTTPClient(httpClient),
 		vault.WithRequestTimeout(30*time.Second),
 	)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	ctx := context.Background()
 	return &vaultClient{
-		client:           client,
-		ctx:              ctx,
-		appRoleID:        config.VaultAppRoleID(),
-		appRoleSecretID:  config.VaultAppRoleSecretID(),
-		appRoleMountPath: "approle",
-		token:            "",
-		tokenUntil:       time.Now().Add(time.Duration(-24) * time.Hour),
-		logger:           config.Logger(),
+		client:       client,
+		httpClient:   httpClient,
+		ctx:          ctx,
+		login:        newVaultLogin(config),
+		loginRetries: vaultLoginRetries,
+		loginBackoff: vaultLoginBackoff,
+		token:        "",
+		tokenUntil:   time.Now().Add(time.Duration(-24) * time.Hour),
+		logger:       config.Logger(),
+	}, nil
+}
+
+// newVaultHTTPClient creates the HTTP client shared by the login and the transit requests. It applies the TLS
+// settings and sends the namespace header with every request.
+func newVaultHTTPClient(config transformConfig.VaultConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.VaultTLSServerName(),
+	}
+	if caCert := config.VaultCACert(); len(caCert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("vault CA bundle contains no PEM encoded certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.VaultClientCert()) > 0 || len(config.VaultClientKey()) > 0 {
+		cert, err := tls.X509KeyPair(config.VaultClientCert(), config.VaultClientKey())
+		if err != nil {
+			return nil, fmt.Errorf("error loading vault TLS client certificate and key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = tlsConfig
+	var roundTripper http.RoundTripper = transport
+	if namespace := config.VaultNamespace(); namespace != "" {
+		roundTripper = namespaceTransport{
+			namespace: namespace,
+			next:      transport,
+		}
+	}
+	return &http.Client{
+		Transport: roundTripper,
+	}, nil
+}
+
+// namespaceTransport adds the vault enterprise namespace to requests without an explicit namespace
+type namespaceTransport struct {
+	namespace string
+	next      http.RoundTripper
+}
+
+func (t namespaceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(vaultNamespaceHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(vaultNamespaceHeader, t.namespace)
+	}
+	return t.next.RoundTrip(req)
+}
+
+func newVaultLogin(config transformConfig.VaultConfig) vaultLogin {
+	method := config.VaultAuthMethod()
+	if method == "" {
+		method = transformConfig.VaultAuthMethodAppRole
+	}
+	// the auth method is mounted at the path of its name by default
+	mountPath := config.VaultAuthMount()
+	if mountPath == "" {
+		mountPath = method
+	}
+	switch method {
+	case transformConfig.VaultAuthMethodAppRole:
+		roleID := config.VaultAppRoleID()
+		secretID := config.VaultAppRoleSecretID()
+		return func(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+			return authFromResponse(client.Auth.AppRoleLogin(
+				ctx,
+				schema.AppRoleLoginRequest{
+					RoleId:   roleID,
+					SecretId: secretID,
+				},
+				vault.WithMountPath(mountPath),
+			))
+		}
+	case transformConfig.VaultAuthMethodToken:
+		token := config.VaultToken()
+		return func(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+			// a static token needs no login, but its lease decides about expiry and renewal
+			resp, err := client.Auth.TokenLookUpSelf(ctx, vault.WithToken(token))
+			if err != nil {
+				return nil, err
+			}
+			return staticTokenAuth(token, resp.Data)
+		}
+	case transformConfig.VaultAuthMethodKubernetes:
+		role := config.VaultAuthRole()
+		jwt := config.VaultAuthJWT()
+		jwtFile := config.VaultAuthJWTFile()
+		if jwt == "" && jwtFile == "" {
+			jwtFile = kubernetesServiceAccountTokenFile
+		}
+		return func(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+			// the service account token is rotated by kubernetes and has to be read on every login
+			token, err := readJWT(jwt, jwtFile)
+			if err != nil {
+				return nil, err
+			}
+			return authFromResponse(client.Auth.KubernetesLogin(
+				ctx,
+				schema.KubernetesLoginRequest{
+					Jwt:  token,
+					Role: role,
+				},
+				vault.WithMountPath(mountPath),
+			))
+		}
+	case transformConfig.VaultAuthMethodJWT:
+		role := config.VaultAuthRole()
+		jwt := config.VaultAuthJWT()
+		jwtFile := config.VaultAuthJWTFile()
+		return func(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+			token, err := readJWT(jwt, jwtFile)
+			if err != nil {
+				return nil, err
+			}
+			return authFromResponse(client.Auth.JwtLogin(
+				ctx,
+				schema.JwtLoginRequest{
+					Jwt:  token,
+					Role: role,
+				},
+				vault.WithMountPath(mountPath),
+			))
+		}
+	default:
+		return func(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+			return nil, fmt.Errorf("configuration failure, unknown vault auth method %q", method)
+		}
+	}
+}
+
+func authFromResponse(resp *vault.Response[map[string]interface{}], err error) (*vault.ResponseAuth, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("vault login response contains no client token")
+	}
+	return resp.Auth, nil
+}
+
+func readJWT(jwt, jwtFile string) (string, error) {
+	if jwtFile == "" {
+		return jwt, nil
+	}
+	data, err := os.ReadFile(jwtFile)
+	if err != nil {
+		return "", fmt.Errorf("can not read JWT file %s: %w", jwtFile, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func staticTokenAuth(token string, data map[string]interface{}) (*vault.ResponseAuth, error) {
+	auth := &vault.ResponseAuth{ClientToken: token}
+	if ttl, ok := data["ttl"]; ok {
+		leaseDuration, err := strconv.Atoi(fmt.Sprint(ttl))
+		if err != nil {
+			return nil, fmt.Errorf("vault token lookup contains invalid ttl %v: %w", ttl, err)
+		}
+		auth.LeaseDuration = leaseDuration
 	}
+	if renewable, ok := data["renewable"].(bool); ok {
+		auth.Renewable = renewable
+	}
+	return auth, nil
 }
 
-func (c *vaultClient) getToken() string {
+// getToken returns a valid token and logs in again when the current token has expired. Concurrent callers share a
+// single login, which runs without holding the mutex. A caller stops waiting for the login once its context is done,
+// the login itself goes on for the other callers.
+func (c *vaultClient) getToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
 	if len([]byte(c.token)) > 0 && time.Now().Before(c.tokenUntil) {
-		return c.token
+		defer c.mutex.Unlock()
+		return c.token, nil
 	}
-	if c.logger.IsDebug() {
-		c.logger.Log(hclog.Error, "create new token", "old-until", c.tokenUntil, "now", time.Now(), "before", time.Now().Before(c.tokenUntil), "token-len", len([]byte(c.token)))
-	}
-	timer := prometheus.NewTimer(vaultRequestDuration.WithLabelValues("token"))
-	defer timer.ObserveDuration()
-	resp, err := c.client.Auth.AppRoleLogin(
-		c.ctx,
-		schema.AppRoleLoginRequest{
-			RoleId:   c.appRoleID,
-			SecretId: c.appRoleSecretID,
-		},
-	)
+	login := c.pendingLogin
+	if login == nil {
+		if c.logger.IsDebug() {
+			c.logger.Debug("create new token", "old-until", c.tokenUntil, "now", time.Now(), "token-len", len([]byte(c.token)))
+		}
+		login = &vaultPendingLogin{done: make(chan struct{})}
+		c.pendingLogin = login
+		go c.loginShared(login)
+	}
+	c.mutex.Unlock()
+
+	select {
+	case <-login.done:
+		return login.token, login.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("vault login canceled: %w", ctx.Err())
+	}
+}
+
+// loginShared logs in for all callers waiting for the pending login and stores the new token
+func (c *vaultClient) loginShared(login *vaultPendingLogin) {
+	defer close(login.done)
+	auth, err := c.loginWithRetry()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pendingLogin = nil
 	if err != nil {
-		return ""
+		c.token = ""
+		login.err = fmt.Errorf("vault login failed: %w", err)
+		return
 	}
-	c.token = resp.Auth.ClientToken
-	// create new token 60s upfront end of duration
-	c.tokenUntil = time.Now().Add(time.Duration(resp.Auth.LeaseDuration-60) * time.Second)
+	c.token = auth.ClientToken
+	c.tokenUntil = tokenExpiry(auth.LeaseDuration)
+	login.token = c.token
+	vaultTokenIssued.Store(time.Now().UnixNano())
 	if c.logger.IsDebug() {
-		c.logger.Log(hclog.Error, "new token", "duration", resp.Auth.LeaseDuration, "until", c.tokenUntil)
+		c.logger.Debug("new token", "duration", auth.LeaseDuration, "renewable", auth.Renewable, "until", c.tokenUntil)
+	}
+	if auth.Renewable && auth.LeaseDuration > 0 {
+		go c.renew(auth.ClientToken, auth.LeaseDuration)
+	}
+}
+
+// check returns with error if vault is not reachable, not initialized, sealed or the login fails
+func (c *vaultClient) check(ctx context.Context) error {
+	timer := prometheus.NewTimer(vaultRequestDuration.WithLabelValues("health"))
+	// answer with 200 in every state, so the client does not retry and the state is read from the body
+	resp, err := c.client.System.ReadHealthStatus(ctx, vault.WithQueryParameters(url.Values{
+		"standbyok":     {"true"},
+		"perfstandbyok": {"true"},
+		"sealedcode":    {"200"},
+		"uninitcode":    {"200"},
+	}))
+	timer.ObserveDuration()
+	if err != nil {
+		return fmt.Errorf("vault is not reachable: %w", err)
+	}
+	if initialized, ok := resp.Data["initialized"].(bool); ok && !initialized {
+		return fmt.Errorf("vault is not initialized")
+	}
+	if sealed, ok := resp.Data["sealed"].(bool); ok && sealed {
+		return fmt.Errorf("vault is sealed")
+	}
+	_, err = c.getToken(ctx)
+	return err
+}
+
+// loginWithRetry logs in to vault and retries with an exponential backoff on failures
+func (c *vaultClient) loginWithRetry() (*vault.ResponseAuth, error) {
+	backoff := c.loginBackoff
+	for attempt := 1; ; attempt++ {
+		timer := prometheus.NewTimer(vaultRequestDuration.WithLabelValues("token"))
+		auth, err := c.login(c.ctx, c.client)
+		timer.ObserveDuration()
+		if err == nil {
+			return auth, nil
+		}
+		vaultTokenFailures.WithLabelValues("login").Inc()
+		if attempt >= c.loginRetries {
+			return nil, err
+		}
+		c.logger.Warn("vault login failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-c.ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// renew extends the lease of the token in the background until vault refuses to renew it or the token has been
+// replaced. A token which could not be renewed is replaced by a new login on its expiry.
+func (c *vaultClient) renew(token string, leaseDuration int) {
+	for {
+		// renew after two thirds of the lease
+		delay := time.Duration(leaseDuration) * time.Second * 2 / 3
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if !c.isCurrentToken(token) {
+			return
+		}
+		timer := prometheus.NewTimer(vaultRequestDuration.WithLabelValues("renew"))
+		resp, err := c.client.Auth.TokenRenewSelf(c.ctx, schema.TokenRenewSelfRequest{}, vault.WithToken(token))
+		timer.ObserveDuration()
+		if err == nil && (resp.Auth == nil || resp.Auth.LeaseDuration <= 0) {
+			err = fmt.Errorf("vault renew response contains no lease")
+		}
+		if err != nil {
+			vaultTokenFailures.WithLabelValues("renew").Inc()
+			c.logger.Warn("vault token renewal failed, token is replaced on expiry", "error", err)
+			return
+		}
+		c.mutex.Lock()
+		if c.token != token {
+			c.mutex.Unlock()
+			return
+		}
+		c.tokenUntil = tokenExpiry(resp.Auth.LeaseDuration)
+		c.mutex.Unlock()
+		if c.logger.IsDebug() {
+			c.logger.Debug("renewed token", "duration", resp.Auth.LeaseDuration, "renewable", resp.Auth.Renewable)
+		}
+		if !resp.Auth.Renewable {
+			return
+		}
+		leaseDuration = resp.Auth.LeaseDuration
+	}
+}
+
+func (c *vaultClient) isCurrentToken(token string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.token == token
+}
+
+func tokenExpiry(leaseDuration int) time.Time {
+	if leaseDuration <= 0 {
+		// tokens without lease duration do not expire
+		return time.Now().Add(time.Duration(100*365*24) * time.Hour)
 	}
-	return c.token
+	// create new token upfront end of duration
+	return time.Now().Add(time.Duration(leaseDuration)*time.Second - vaultTokenExpiryMargin)
 }
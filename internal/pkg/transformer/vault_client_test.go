@@ -0,0 +1,455 @@
+// Copyright 2026 The Terraform SOPS backend Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/stretchr/testify/assert"
+	terraformConfig "github.com/wtschreiter/terraformsopsbackend/internal/pkg/config"
+)
+
+func Test_vaultClient_getToken(t *testing.T) {
+	jwtFile := filepath.Join(t.TempDir(), "token")
+	if !assert.NoError(t, os.WriteFile(jwtFile, []byte("jwt-from-file\n"), 0600)) {
+		return
+	}
+	tests := []struct {
+		name         string
+		config       testConfig
+		wantPath     string
+		wantRequest  map[string]string
+		wantToken    string
+		loginFailure bool
+	}{
+		{
+			name: "approle default mount",
+			config: testConfig{
+				vaultAppRoleID:       "role-id",
+				vaultAppRoleSecretID: "secret-id",
+			},
+			wantPath:    "/v1/auth/approle/login",
+			wantRequest: map[string]string{"role_id": "role-id", "secret_id": "secret-id"},
+			wantToken:   "approle-token",
+		},
+		{
+			name: "approle custom mount",
+			config: testConfig{
+				vaultAuthMethod:      terraformConfig.VaultAuthMethodAppRole,
+				vaultAuthMount:       "ci/approle",
+				vaultAppRoleID:       "role-id",
+				vaultAppRoleSecretID: "secret-id",
+			},
+			wantPath:    "/v1/auth/ci/approle/login",
+			wantRequest: map[string]string{"role_id": "role-id", "secret_id": "secret-id"},
+			wantToken:   "approle-token",
+		},
+		{
+			name: "static token",
+			config: testConfig{
+				vaultAuthMethod: terraformConfig.VaultAuthMethodToken,
+				vaultToken:      "static-token",
+			},
+			wantPath:  "/v1/auth/token/lookup-self",
+			wantToken: "static-token",
+		},
+		{
+			name: "kubernetes",
+			config: testConfig{
+				vaultAuthMethod:  terraformConfig.VaultAuthMethodKubernetes,
+				vaultAuthMount:   "k8s-cluster",
+				vaultAuthRole:    "sops",
+				vaultAuthJWTFile: jwtFile,
+			},
+			wantPath:    "/v1/auth/k8s-cluster/login",
+			wantRequest: map[string]string{"jwt": "jwt-from-file", "role": "sops"},
+			wantToken:   "kubernetes-token",
+		},
+		{
+			name: "jwt",
+			config: testConfig{
+				vaultAuthMethod: terraformConfig.VaultAuthMethodJWT,
+				vaultAuthRole:   "ci",
+				vaultAuthJWT:    "jwt-value",
+			},
+			wantPath:    "/v1/auth/jwt/login",
+			wantRequest: map[string]string{"jwt": "jwt-value", "role": "ci"},
+			wantToken:   "jwt-token",
+		},
+		{
+			name: "failed login",
+			config: testConfig{
+				vaultAuthMethod: terraformConfig.VaultAuthMethodJWT,
+				vaultAuthJWT:    "jwt-value",
+			},
+			wantPath:     "/v1/auth/jwt/login",
+			wantRequest:  map[string]string{"jwt": "jwt-value"},
+			loginFailure: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				gotPath    string
+				gotRequest map[string]string
+				gotCalls   int
+			)
+			vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				gotCalls++
+				_ = json.NewDecoder(r.Body).Decode(&gotRequest)
+				if tt.loginFailure {
+					http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/v1/auth/token/lookup-self" {
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{
+						"data": map[string]interface{}{"ttl": 0, "renewable": false},
+					})
+					return
+				}
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": nil,
+					"auth": map[string]interface{}{
+						"client_token":   tt.wantToken,
+						"lease_duration": 3600,
+						"renewable":      false,
+					},
+				})
+			}))
+			defer vault.Close()
+			tt.config.vaultAddr = vault.URL
+			client, err := newVaultClient(tt.config)
+			if !assert.NoError(t, err) {
+				return
+			}
+			client.loginBackoff = time.Millisecond
+
+			got, err := client.getToken(context.Background())
+
+			if tt.loginFailure {
+				assert.Error(t, err)
+				assert.Equal(t, vaultLoginRetries, gotCalls)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, gotCalls)
+			}
+			assert.Equal(t, tt.wantToken, got)
+			assert.Equal(t, tt.wantPath, gotPath)
+			if tt.wantRequest != nil {
+				assert.Equal(t, tt.wantRequest, gotRequest)
+			}
+		})
+	}
+}
+
+func Test_vaultClient_concurrentGetToken(t *testing.T) {
+	var logins atomic.Int32
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logins.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": nil,
+			"auth": map[string]interface{}{"client_token": "approle-token", "lease_duration": 3600},
+		})
+	}))
+	defer vault.Close()
+	client, err := newVaultClient(testConfig{vaultAddr: vault.URL, vaultAppRoleID: "role-id", vaultAppRoleSecretID: "secret-id"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := client.getToken(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "approle-token", token)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), logins.Load())
+}
+
+func Test_vaultClient_getToken_pendingLogin(t *testing.T) {
+	var logins atomic.Int32
+	release := make(chan struct{})
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logins.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": nil,
+			"auth": map[string]interface{}{"client_token": "approle-token", "lease_duration": 3600},
+		})
+	}))
+	defer vault.Close()
+	client, err := newVaultClient(testConfig{vaultAddr: vault.URL, vaultAppRoleID: "role-id", vaultAppRoleSecretID: "secret-id"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	waiting := make(chan string)
+	go func() {
+		token, _ := client.getToken(context.Background())
+		waiting <- token
+	}()
+	assert.Eventually(t, func() bool { return logins.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.getToken(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, client.isCurrentToken("approle-token"), "the mutex is not held during the login")
+	close(release)
+	select {
+	case token := <-waiting:
+		assert.Equal(t, "approle-token", token)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "pending login has not finished")
+	}
+	assert.Equal(t, int32(1), logins.Load())
+}
+
+func Test_vaultClient_renew(t *testing.T) {
+	renewed := make(chan string, 1)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/auth/token/renew-self" {
+			renewed <- r.Header.Get("X-Vault-Token")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": nil,
+				"auth": map[string]interface{}{"client_token": "approle-token", "lease_duration": 3600},
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": nil,
+			"auth": map[string]interface{}{"client_token": "approle-token", "lease_duration": 1, "renewable": true},
+		})
+	}))
+	defer vault.Close()
+	client, err := newVaultClient(testConfig{vaultAddr: vault.URL, vaultAppRoleID: "role-id", vaultAppRoleSecretID: "secret-id"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = client.getToken(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	select {
+	case token := <-renewed:
+		assert.Equal(t, "approle-token", token)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "token has not been renewed")
+		return
+	}
+	assert.Eventually(t, func() bool {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		return time.Until(client.tokenUntil) > time.Hour-2*vaultTokenExpiryMargin
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_vaultClient_tls(t *testing.T) {
+	clientCert, clientKey := newTestCertificate(t)
+	var gotNamespaces []string
+	vault := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotNamespaces = append(gotNamespaces, r.Header.Get("X-Vault-Namespace"))
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/transit/encrypt/sops" {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"ciphertext": "vault:v1:ciphertext"},
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": nil,
+			"auth": map[string]interface{}{"client_token": "approle-token", "lease_duration": 3600},
+		})
+	}))
+	vault.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	vault.StartTLS()
+	defer vault.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: vault.Certificate().Raw})
+
+	tests := []struct {
+		name    string
+		config  testConfig
+		wantErr bool
+	}{
+		{
+			name: "CA bundle, client certificate and namespace",
+			config: testConfig{
+				vaultNamespace:  "team-a",
+				vaultCACert:     caCert,
+				vaultClientCert: clientCert,
+				vaultClientKey:  clientKey,
+			},
+		},
+		{
+			name: "matching server name",
+			config: testConfig{
+				vaultNamespace:     "team-a",
+				vaultCACert:        caCert,
+				vaultClientCert:    clientCert,
+				vaultClientKey:     clientKey,
+				vaultTLSServerName: "example.com",
+			},
+		},
+		{
+			name: "wrong server name",
+			config: testConfig{
+				vaultCACert:        caCert,
+				vaultClientCert:    clientCert,
+				vaultClientKey:     clientKey,
+				vaultTLSServerName: "vault.example.org",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown CA",
+			config: testConfig{
+				vaultClientCert: clientCert,
+				vaultClientKey:  clientKey,
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing client certificate",
+			config: testConfig{
+				vaultCACert: caCert,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotNamespaces = nil
+			tt.config.vaultAddr = vault.URL
+			tt.config.vaultAppRoleID = "role-id"
+			tt.config.vaultAppRoleSecretID = "secret-id"
+			server, err := newKeyServiceServer(tt.config, keyservice.Server{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			server.vaultClient.loginRetries = 1
+
+			_, err = server.Encrypt(context.Background(), &keyservice.EncryptRequest{
+				Key: &keyservice.Key{KeyType: &keyservice.Key_VaultKey{VaultKey: &keyservice.VaultKey{
+					VaultAddress: vault.URL,
+					EnginePath:   "transit",
+					KeyName:      "sops",
+				}}},
+				Plaintext: []byte("data key"),
+			})
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			// login and transit request
+			assert.Equal(t, []string{tt.config.vaultNamespace, tt.config.vaultNamespace}, gotNamespaces)
+		})
+	}
+}
+
+func Test_newVaultHTTPClient(t *testing.T) {
+	clientCert, clientKey := newTestCertificate(t)
+	tests := []struct {
+		name    string
+		config  testConfig
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:    "invalid CA bundle",
+			config:  testConfig{vaultCACert: []byte("no certificate")},
+			wantErr: true,
+		},
+		{
+			name:    "client certificate without key",
+			config:  testConfig{vaultClientCert: clientCert},
+			wantErr: true,
+		},
+		{
+			name:   "client certificate",
+			config: testConfig{vaultClientCert: clientCert, vaultClientKey: clientKey},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newVaultHTTPClient(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, client)
+		})
+	}
+}
+
+// newTestCertificate creates a self signed PEM encoded certificate and key
+func newTestCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "terraform-sops-backend"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}